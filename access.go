@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The typed getters are routed with the read permission, so the router has already checked the caller can read
+// in the guava the record belongs to. A transfer belongs to both the sending and the receiving guava, readers of
+// the receiving guava see it without the sending guava's internal approval and cancellation details.
+
+// ============================================================================================================================
+// get_account - read an account <account_id, caller>, transfers from other guavas are redacted
+// ============================================================================================================================
+
+func (t *GuavaChaincode) get_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <account_id, caller>")
+	}
+
+	acc, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = redact_incoming(stub, acc)
+	if err != nil {
+		return nil, err
+	}
+
+	accAsBytes, _ := json.Marshal(acc)
+	return accAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_transfer - read a transfer as held by the account that sent it <transfer_id, caller>
+// readers who only belong to the receiving guava get it redacted
+// ============================================================================================================================
+
+func (t *GuavaChaincode) get_transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <transfer_id, caller>")
+	}
+
+	transl, sending_acc, err := get_transfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := get_actor(stub, sending_acc.Guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+	sender_reads, err := user_allowed(stub, sending_acc.Guava_id, user, sending_acc.AccountID, "read", "get_transfer")
+	if err != nil {
+		return nil, err
+	}
+	if !sender_reads {
+		redact_transfer(transl)
+	}
+
+	transferAsBytes, _ := json.Marshal(transl)
+	return transferAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_user - read a user of a guava <guava_id, username, caller>, only owners can read users other than themselves
+// ============================================================================================================================
+
+func (t *GuavaChaincode) get_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, username, caller>")
+	}
+
+	guava_id := args[0]
+	username := args[1]
+	caller := args[2]
+
+	if strings.Compare(username, caller) != 0 {
+		caller_user, err := get_actor(stub, guava_id, caller)
+		if err != nil {
+			return nil, err
+		}
+		if caller_user == nil || !user_has_role(caller_user, "owner") {
+			return nil, errors.New("User " + caller + " can only read their own user in guava " + guava_id)
+		}
+	}
+
+	user, err := get_user(stub, guava_id, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("Could not find user " + username + " in guava " + guava_id)
+	}
+
+	userAsBytes, _ := json.Marshal(user)
+	return userAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_guava - read a guava and the ids of its accounts <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) get_guava(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	guava, err := get_guava(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	guavaAsBytes, _ := json.Marshal(guava)
+	return guavaAsBytes, nil
+}
+
+// ============================================================================================================================
+// redact_incoming - redact the incoming transfers of an account that were sent from other guavas
+// the reversal of a payment has no sending account and was made by the account's own guava
+// ============================================================================================================================
+
+func redact_incoming(stub shim.ChaincodeStubInterface, acc *Account) error {
+
+	guavas := map[int64]string{acc.AccountID: acc.Guava_id, 0: acc.Guava_id}
+	for i := 0; i < len(acc.IncomingTransfer); i++ {
+		from := acc.IncomingTransfer[i].From
+		if _, ok := guavas[from]; !ok {
+			sending_acc, err := get_account(stub, strconv.FormatInt(from, 10))
+			if err != nil {
+				return err
+			}
+			guavas[from] = sending_acc.Guava_id
+		}
+		if strings.Compare(guavas[from], acc.Guava_id) != 0 {
+			redact_transfer(&acc.IncomingTransfer[i])
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// redact_transfer - clear the sending guava's usernames and internal notes from a transfer read by another guava
+// ============================================================================================================================
+
+func redact_transfer(transl *Transfer) {
+
+	transl.Creator = ""
+	transl.Approver = ""
+	transl.Approvals = make([]Approval, 0)
+	transl.Cancelled_by = ""
+	transl.Cancel_reason = ""
+}
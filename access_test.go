@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// cross_guava adds guava 2 with account 3 "remote" and its reader frank, and settles a payment of 100 from account 1 to it.
+// Account 3 is given the incoming copy a payment credited to its To account before payments left the ledger
+func (l *test_ledger) cross_guava() int64 {
+	l.t.Helper()
+
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+	l.ok("create_user", "frank", "false", "false", "false", "true", "2", identity("frank"), "root")
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "invoice 7", "1", "100", "100", "1", "3", "payment", "2026-01-05", "bob", beneficiary), &resp)
+	l.ok("accept_transfer", "3", "1", format_int(resp.Transfer_id), "100", "100", "carol")
+	l.balance(3, 0)
+
+	acc := l.account(3)
+	acc.Balance = 100
+	acc.IncomingTransfer = append(acc.IncomingTransfer, l.transfer(1, resp.Transfer_id))
+	l.tx = l.tx + 1
+	txid := "tx" + strconv.Itoa(l.tx)
+	l.stub.MockTransactionStart(txid)
+	defer l.stub.MockTransactionEnd(txid)
+	if err := put_account(&timed_stub{MockStub: l.stub, now: l.now, history: l.history}, acc); err != nil {
+		l.t.Fatalf("legacy incoming transfer: %s", err)
+	}
+
+	return resp.Transfer_id
+}
+
+func TestGettersNeedRead(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_user", "nobody", "false", "false", "false", "false", "1", identity("nobody"), "alice")
+	id := l.payment("10")
+
+	l.fail_with("User nobody does not have the read permission in guava 1", "get_account", "1", "nobody")
+	l.fail_with("User mallory does not have the read permission in guava 1", "get_account", "1", "mallory")
+	l.fail_with("User nobody does not have the read permission in guava 1", "get_transfer", format_int(id), "nobody")
+	l.fail_with("User nobody does not have the read permission in guava 1", "get_guava", "1", "nobody")
+	l.fail_with("User nobody does not have the read permission in guava 1", "get_user", "1", "nobody", "nobody")
+	for _, function := range []string{"read_guava", "read_approval_policy", "read_transfer_ttl", "read_expired_transfers"} {
+		l.fail_with("User nobody does not have the read permission in guava 1", function, "1", "nobody")
+	}
+	l.ok("create_batch_transfer", "bob", "t", `[{"inc_value":1, "dec_value":1, "from":1, "to":2, "type":"internal"}]`)
+	l.fail_with("User nobody does not have the read permission in guava 1", "read_batch", "1", "nobody")
+
+	// readers of another guava can not read this one
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+	l.ok("create_user", "frank", "false", "false", "false", "true", "2", identity("frank"), "root")
+	l.fail_with("User frank does not have the read permission in guava 1", "get_account", "1", "frank")
+	l.fail_with("User frank does not have the read permission in guava 1", "get_transfer", format_int(id), "frank")
+	l.fail_with("User frank does not have the read permission in guava 1", "get_guava", "1", "frank")
+}
+
+func TestGetUserOwnersOnly(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var user User
+	l.decode(l.ok("get_user", "1", "dave", "dave"), &user)
+	if user.Username != "dave" || !user.Read || user.Approve {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	l.decode(l.ok("get_user", "1", "carol", "alice"), &user)
+	if user.Username != "carol" || !user.Approve {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	l.fail_with("User dave can only read their own user in guava 1", "get_user", "1", "carol", "dave")
+}
+
+func TestGetTransferRedaction(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	id := l.cross_guava()
+
+	var transl Transfer
+	l.decode(l.ok("get_transfer", format_int(id), "dave"), &transl)
+	if transl.Creator != "bob" || transl.Approver != "carol" || len(transl.Approvals) != 1 {
+		t.Fatalf("the sending guava got a redacted transfer %+v", transl)
+	}
+
+	// the receiving guava sees the amounts and message but not who handled it
+	l.decode(l.ok("get_transfer", format_int(id), "frank"), &transl)
+	if transl.Creator != "" || transl.Approver != "" || len(transl.Approvals) != 0 {
+		t.Fatalf("the receiving guava got sender details %+v", transl)
+	}
+	if transl.Inc_value != 100 || transl.Message != "invoice 7" || transl.Status != "approved" {
+		t.Fatalf("the receiving guava got too little %+v", transl)
+	}
+
+	var acc Account
+	l.decode(l.ok("get_account", "3", "frank"), &acc)
+	if len(acc.IncomingTransfer) != 1 || acc.IncomingTransfer[0].Creator != "" || acc.IncomingTransfer[0].Inc_value != 100 {
+		t.Fatalf("incoming transfer from another guava was not redacted %+v", acc.IncomingTransfer)
+	}
+
+	var accounts []Account
+	l.decode(l.ok("read_guava", "2", "frank"), &accounts)
+	if len(accounts) != 1 || len(accounts[0].IncomingTransfer) != 1 || accounts[0].IncomingTransfer[0].Creator != "" {
+		t.Fatalf("read_guava did not redact the incoming transfer %+v", accounts)
+	}
+
+	// transfers inside a guava are not redacted
+	l.ok("create_transfer", "sweep", "1", "10", "10", "1", "2", "internal", "t", "bob", "")
+	l.decode(l.ok("get_account", "2", "dave"), &acc)
+	if len(acc.IncomingTransfer) != 1 || acc.IncomingTransfer[0].Creator != "bob" {
+		t.Fatalf("internal incoming transfer was redacted %+v", acc.IncomingTransfer)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// An account statement lists what moved an account's balance over a period, oldest first with the balance after
+// each entry. It is built from the account's history (history.go): each version is compared with the one before it,
+// transfers that settled in between become entries and whatever is left of the change in balance is an adjustment
+// from increment_value, decrement_value or the initial balance.
+
+const StatementDateLayout = "2006-01-02"
+
+type AccountStatement struct {
+	Account_id      int64                   `json:"account_id"`
+	Account_name    string                  `json:"account_name"`
+	Guava_id        string                  `json:"guava_id"`
+	Currency        string                  `json:"currency"`
+	From            string                  `json:"from"`            //first day of the period, YYYY-MM-DD
+	To              string                  `json:"to"`              //last day of the period, YYYY-MM-DD
+	Opening_balance float64                 `json:"opening_balance"` //balance at the start of the first day
+	Closing_balance float64                 `json:"closing_balance"` //balance at the end of the last day
+	Total_debits    float64                 `json:"total_debits"`
+	Total_credits   float64                 `json:"total_credits"`
+	Entries         []AccountStatementEntry `json:"entries"`
+}
+
+type AccountStatementEntry struct {
+	Time         string  `json:"time"`         //transaction time of the change
+	Tx_id        string  `json:"tx_id"`        //transaction that made the change
+	Kind         string  `json:"kind"`         //<transfer,adjustment>
+	Transfer_id  int64   `json:"transfer_id"`  //transfer that settled, 0 for adjustments
+	Type         string  `json:"type"`         //type of the transfer <internal,payment,reversal>
+	Counterparty int64   `json:"counterparty"` //other account of the transfer
+	Description  string  `json:"description"`  //message of the transfer
+	Debit        float64 `json:"debit"`
+	Credit       float64 `json:"credit"`
+	Balance      float64 `json:"balance"` //running balance after the entry
+}
+
+// ============================================================================================================================
+// read_account_statement - the entries and running balance of an account over a period, as csv or json
+// <account_id, from_date, to_date, format(csv, json), caller>, dates are YYYY-MM-DD in UTC and both days are included
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_account_statement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5 arguments <account_id, from_date, to_date, format, caller>")
+	}
+
+	account_id := args[0]
+	format := args[3]
+
+	from, err := time.Parse(StatementDateLayout, args[1])
+	if err != nil {
+		return nil, errors.New("Invalid from_date " + args[1] + ", expecting YYYY-MM-DD")
+	}
+	to, err := time.Parse(StatementDateLayout, args[2])
+	if err != nil {
+		return nil, errors.New("Invalid to_date " + args[2] + ", expecting YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return nil, errors.New("to_date " + args[2] + " is before from_date " + args[1])
+	}
+	if format != "csv" && format != "json" {
+		return nil, errors.New("Unknown statement format " + format + ", expecting csv or json")
+	}
+
+	versions, err := account_history(stub, account_id)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := build_statement(versions, from, to.AddDate(0, 0, 1))
+	statement.From = args[1]
+	statement.To = args[2]
+
+	if format == "csv" {
+		return statement_csv(statement), nil
+	}
+
+	statementAsBytes, _ := json.Marshal(statement)
+	return statementAsBytes, nil
+}
+
+// ============================================================================================================================
+// build_statement - the statement of the versions written from start up to but not including end
+// ============================================================================================================================
+
+func build_statement(versions []account_version, start time.Time, end time.Time) *AccountStatement {
+
+	latest := versions[len(versions)-1].Account
+	for i := len(versions) - 1; latest == nil && i >= 0; i-- {
+		latest = versions[i].Account
+	}
+
+	statement := &AccountStatement{Entries: make([]AccountStatementEntry, 0)}
+	if latest != nil {
+		statement.Account_id = latest.AccountID
+		statement.Account_name = latest.AccountName
+		statement.Guava_id = latest.Guava_id
+		statement.Currency = latest.Currency
+	}
+
+	prev := &Account{}
+	for i := 0; i < len(versions); i++ {
+		version := versions[i]
+		if !version.Time.Before(end) {
+			break
+		}
+
+		cur := version.Account
+		if cur == nil {
+			cur = &Account{}
+		}
+
+		if version.Time.Before(start) {
+			prev = cur
+			statement.Opening_balance = cur.Balance
+			continue
+		}
+
+		entries := version_entries(prev, cur, version)
+		for j := 0; j < len(entries); j++ {
+			statement.Total_debits = statement.Total_debits + entries[j].Debit
+			statement.Total_credits = statement.Total_credits + entries[j].Credit
+		}
+		statement.Entries = append(statement.Entries, entries...)
+		prev = cur
+	}
+
+	statement.Closing_balance = prev.Balance
+	return statement
+}
+
+// ============================================================================================================================
+// version_entries - what changed the balance of an account between two of its versions
+// ============================================================================================================================
+
+func version_entries(prev *Account, cur *Account, version account_version) []AccountStatementEntry {
+
+	entries := make([]AccountStatementEntry, 0)
+	balance := prev.Balance
+	when := version.Time.Format(time.RFC3339)
+
+	// outgoing transfers debit the account when they are approved, reversals arrive already approved
+	for i := 0; i < len(cur.OutgoingTransfer); i++ {
+		transl := &cur.OutgoingTransfer[i]
+		before := find_transfer(prev.OutgoingTransfer, transl.Transfer_id)
+		if !debited(transl) || (before != nil && debited(before)) {
+			continue
+		}
+
+		balance = balance - transl.Dec_value
+		entries = append(entries, AccountStatementEntry{Time: when, Tx_id: version.Tx_id, Kind: "transfer", Transfer_id: transl.Transfer_id,
+			Type: transl.T_Type, Counterparty: transl.To, Description: transl.Message, Debit: transl.Dec_value, Balance: balance})
+	}
+
+	// incoming transfers are only added to the receiving account once they settle
+	for i := 0; i < len(cur.IncomingTransfer); i++ {
+		transl := &cur.IncomingTransfer[i]
+		if find_transfer(prev.IncomingTransfer, transl.Transfer_id) != nil {
+			continue
+		}
+
+		balance = balance + transl.Inc_value
+		entries = append(entries, AccountStatementEntry{Time: when, Tx_id: version.Tx_id, Kind: "transfer", Transfer_id: transl.Transfer_id,
+			Type: transl.T_Type, Counterparty: transl.From, Description: transl.Message, Credit: transl.Inc_value, Balance: balance})
+	}
+
+	rest := cur.Balance - balance
+	if math.Abs(rest) > 1e-9 {
+		entry := AccountStatementEntry{Time: when, Tx_id: version.Tx_id, Kind: "adjustment", Description: "balance adjustment", Balance: cur.Balance}
+		if prev.AccountID == 0 {
+			entry.Description = "initial balance"
+		}
+		if rest < 0 {
+			entry.Debit = -rest
+		} else {
+			entry.Credit = rest
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func debited(transl *Transfer) bool {
+
+	return transl.Status == "approved" || transl.Status == "settled" || transl.Status == "partially_reversed" || transl.Status == "reversed"
+}
+
+// ============================================================================================================================
+// statement_csv - render a statement as csv, one row per entry between an opening and a closing balance row
+// ============================================================================================================================
+
+func statement_csv(statement *AccountStatement) []byte {
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write([]string{"time", "tx_id", "kind", "transfer_id", "type", "counterparty", "description", "debit", "credit", "balance"})
+	w.Write([]string{statement.From, "", "opening_balance", "", "", "", "", "", "", format_float(statement.Opening_balance)})
+
+	for _, entry := range statement.Entries {
+		transfer_id, counterparty := "", ""
+		if entry.Transfer_id != 0 {
+			transfer_id = strconv.FormatInt(entry.Transfer_id, 10)
+			counterparty = strconv.FormatInt(entry.Counterparty, 10)
+		}
+		w.Write([]string{entry.Time, entry.Tx_id, entry.Kind, transfer_id, entry.Type, counterparty, entry.Description,
+			format_float(entry.Debit), format_float(entry.Credit), format_float(entry.Balance)})
+	}
+
+	w.Write([]string{statement.To, "", "closing_balance", "", "", "", "", format_float(statement.Total_debits),
+		format_float(statement.Total_credits), format_float(statement.Closing_balance)})
+	w.Flush()
+
+	return buf.Bytes()
+}
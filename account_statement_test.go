@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statement_month gives account 1 a month of activity after setup on January 5th
+func (l *test_ledger) statement_month() (int64, int64) {
+	l.t.Helper()
+
+	l.now = time.Date(2026, time.January, 6, 10, 0, 0, 0, time.UTC)
+	l.internal("100", "bob")
+	paid := l.payment("200")
+
+	l.now = time.Date(2026, time.January, 12, 10, 0, 0, 0, time.UTC)
+	l.ok("accept_transfer", "2", "1", format_int(paid), "200", "200", "carol")
+	l.ok("increment_value", "1", "50", "alice")
+
+	l.now = time.Date(2026, time.January, 20, 10, 0, 0, 0, time.UTC)
+	var back TransferResponse
+	l.decode(l.ok("create_transfer", "back", "1", "30", "30", "2", "1", "internal", "t", "bob", ""), &back)
+	l.ok("reverse_transfer", "1", format_int(paid), "20", "carol", "overpaid")
+
+	l.now = time.Date(2026, time.February, 2, 10, 0, 0, 0, time.UTC)
+	l.ok("decrement_value", "1", "10", "alice")
+	return paid, back.Transfer_id
+}
+
+func TestAccountStatementJSON(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	paid, back := l.statement_month()
+
+	var statement AccountStatement
+	l.decode(l.ok("read_account_statement", "1", "2026-01-06", "2026-01-31", "json", "dave"), &statement)
+
+	if statement.Account_id != 1 || statement.Account_name != "ops" || statement.Currency != "CAD" || statement.Guava_id != "1" ||
+		statement.From != "2026-01-06" || statement.To != "2026-01-31" {
+		t.Fatalf("unexpected statement header %+v", statement)
+	}
+	if statement.Opening_balance != 1000 || statement.Closing_balance != 800 || statement.Total_debits != 300 || statement.Total_credits != 100 {
+		t.Fatalf("unexpected statement totals %+v", statement)
+	}
+
+	// the pending payment only shows once it is accepted, the February decrement is after the period
+	want := []AccountStatementEntry{
+		{Kind: "transfer", Type: "internal", Counterparty: 2, Description: "sweep", Debit: 100, Balance: 900},
+		{Kind: "transfer", Transfer_id: paid, Type: "payment", Counterparty: 2, Description: "invoice", Debit: 200, Balance: 700},
+		{Kind: "adjustment", Description: "balance adjustment", Credit: 50, Balance: 750},
+		{Kind: "transfer", Transfer_id: back, Type: "internal", Counterparty: 2, Description: "back", Credit: 30, Balance: 780},
+		{Kind: "transfer", Type: "reversal", Description: "overpaid", Credit: 20, Balance: 800},
+	}
+	if len(statement.Entries) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), statement.Entries)
+	}
+	for i, entry := range statement.Entries {
+		w := want[i]
+		if entry.Kind != w.Kind || entry.Type != w.Type || entry.Counterparty != w.Counterparty || entry.Description != w.Description ||
+			entry.Debit != w.Debit || entry.Credit != w.Credit || entry.Balance != w.Balance || (w.Transfer_id != 0 && entry.Transfer_id != w.Transfer_id) {
+			t.Fatalf("entry %d = %+v, want %+v", i, entry, w)
+		}
+		if entry.Tx_id == "" || entry.Time == "" {
+			t.Fatalf("entry %d has no transaction %+v", i, entry)
+		}
+	}
+	if statement.Entries[1].Time != "2026-01-12T10:00:00Z" {
+		t.Fatalf("the payment should be dated when it was accepted, got %s", statement.Entries[1].Time)
+	}
+
+	// a period from before the account existed starts with its initial balance
+	l.decode(l.ok("read_account_statement", "1", "2026-01-01", "2026-01-05", "json", "dave"), &statement)
+	if statement.Opening_balance != 0 || statement.Closing_balance != 1000 || len(statement.Entries) != 1 ||
+		statement.Entries[0].Description != "initial balance" || statement.Entries[0].Credit != 1000 {
+		t.Fatalf("unexpected opening statement %+v", statement)
+	}
+
+	// the statement is built from history, not from the current account
+	l.decode(l.ok("read_account_statement", "1", "2026-02-01", "2026-02-28", "json", "dave"), &statement)
+	if statement.Opening_balance != 800 || statement.Closing_balance != 790 || len(statement.Entries) != 1 || statement.Entries[0].Debit != 10 {
+		t.Fatalf("unexpected February statement %+v", statement)
+	}
+}
+
+func TestAccountStatementCSV(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.statement_month()
+
+	rows, err := csv.NewReader(strings.NewReader(string(l.ok("read_account_statement", "1", "2026-01-06", "2026-01-31", "csv", "dave")))).ReadAll()
+	if err != nil {
+		t.Fatalf("could not parse the csv: %v", err)
+	}
+
+	if len(rows) != 8 || strings.Join(rows[0], ",") != "time,tx_id,kind,transfer_id,type,counterparty,description,debit,credit,balance" {
+		t.Fatalf("unexpected csv %v", rows)
+	}
+	if rows[1][0] != "2026-01-06" || rows[1][2] != "opening_balance" || rows[1][9] != "1000" {
+		t.Fatalf("unexpected opening row %v", rows[1])
+	}
+	if rows[4][2] != "adjustment" || rows[4][3] != "" || rows[4][8] != "50" || rows[4][9] != "750" {
+		t.Fatalf("unexpected adjustment row %v", rows[4])
+	}
+	if rows[7][0] != "2026-01-31" || rows[7][2] != "closing_balance" || rows[7][7] != "300" || rows[7][8] != "100" || rows[7][9] != "800" {
+		t.Fatalf("unexpected closing row %v", rows[7])
+	}
+}
+
+func TestAccountStatementErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("Invalid from_date 2026-1-6", "read_account_statement", "1", "2026-1-6", "2026-01-31", "json", "dave")
+	l.fail_with("to_date 2026-01-01 is before from_date 2026-01-31", "read_account_statement", "1", "2026-01-31", "2026-01-01", "json", "dave")
+	l.fail_with("Unknown statement format pdf, expecting csv or json", "read_account_statement", "1", "2026-01-01", "2026-01-31", "pdf", "dave")
+	l.fail_with("User nobody does not have the read permission in guava 1", "read_account_statement", "1", "2026-01-01", "2026-01-31", "json", "nobody")
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type BatchItem struct {
+	Transfer_id int64 `json:"transfer_id"` //id of the transfer created for this item
+	From        int64 `json:"from"`        //account the transfer was sent from
+	To          int64 `json:"to"`          //account the transfer was sent to
+}
+
+type Batch struct {
+	Batch_id int64       `json:"batch_id"` //unique identifier for batch
+	Creator  string      `json:"creator"`  //the username of the user who submitted the batch
+	Approver string      `json:"approver"` //the username of the user who approved or rejected the batch
+	Time     string      `json:"time"`     //time the batch was created
+	Status   string      `json:"status"`   //current status of batch <pending,approved,partial,rejected,closed>
+	Items    []BatchItem `json:"items"`    //transfers in submission order
+	Schema   int         `json:"schema"`   //layout version the batch was written with
+}
+
+// BatchTransfer - an item of transfers_json, only the fields a client sets when it creates a transfer
+type BatchTransfer struct {
+	Message     string          `json:"message"`
+	Fx_rate     float64         `json:"fx_rate"`
+	Inc_value   float64         `json:"inc_value"`
+	Dec_value   float64         `json:"dec_value"`
+	From        int64           `json:"from"`
+	To          int64           `json:"to"`
+	T_Type      string          `json:"type"`
+	Beneficiary json.RawMessage `json:"beneficiary"`
+}
+
+type BatchView struct {
+	Batch
+	Transfers []Transfer `json:"transfers"` //current state of every transfer in the batch
+}
+
+// ============================================================================================================================
+// account_cache - accounts touched by a batch, loaded once so every item sees the effect of the items before it
+// ============================================================================================================================
+
+type account_cache map[int64]*Account
+
+func (c account_cache) get(stub shim.ChaincodeStubInterface, account_id int64) (*Account, error) {
+
+	if acc, ok := c[account_id]; ok {
+		return acc, nil
+	}
+
+	acc, err := get_account(stub, strconv.FormatInt(account_id, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	c[account_id] = acc
+	return acc, nil
+}
+
+func (c account_cache) put_all(stub shim.ChaincodeStubInterface) error {
+
+	for _, acc := range c {
+		err := put_account(stub, acc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// create_batch_transfer - create several transfers at once, all or none <creator, time, transfers_json>
+// transfers_json is an array of {"message", "fx_rate", "inc_value", "dec_value", "from", "to", "type", "beneficiary"}
+// the creator needs create on the from account of every item
+// ============================================================================================================================
+
+func (t *GuavaChaincode) create_batch_transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var creator, time string
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <creator, time, transfers_json>")
+	}
+
+	creator = args[0]
+	time = args[1]
+
+	inputs, err := decode_batch_transfers(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	batch_id, err := next_ids(stub, "batch", 1)
+	if err != nil {
+		return nil, err
+	}
+	first_transfer_id, err := next_ids(stub, "transfer", int64(len(inputs)))
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := account_cache{}
+	pending := make(map[int64]float64) //funds already promised to pending payments in this batch
+	limits, err := new_limit_checker(stub)
+	if err != nil {
+		return nil, err
+	}
+	batch := Batch{
+		Batch_id: batch_id,
+		Creator:  creator,
+		Time:     time,
+		Status:   "approved",
+		Items:    make([]BatchItem, 0, len(inputs)),
+		Schema:   BatchSchema}
+	events := make([]GuavaEvent, 0)
+
+	// validate and apply every item against the cached accounts; nothing is written until all of them pass
+	items := make([]Transfer, len(inputs))
+	for i := 0; i < len(inputs); i++ {
+		input := &inputs[i]
+		item_str := "batch item " + strconv.Itoa(i) + ": "
+
+		if input.T_Type == "" {
+			return nil, errors.New(item_str + "missing transfer type")
+		}
+		if input.Dec_value <= 0 || input.Inc_value <= 0 {
+			return nil, errors.New(item_str + "transfer values must be positive")
+		}
+
+		beneficiary, err := parse_beneficiary(string(input.Beneficiary))
+		if err != nil {
+			return nil, errors.New(item_str + err.Error())
+		}
+
+		item := &items[i]
+		*item = Transfer{
+			From:        input.From,
+			To:          input.To,
+			Dec_value:   input.Dec_value,
+			Inc_value:   input.Inc_value,
+			Fx_rate:     input.Fx_rate,
+			Message:     input.Message,
+			T_Type:      input.T_Type,
+			Beneficiary: beneficiary,
+			Creator:     creator,
+			Time:        time,
+			Transfer_id: first_transfer_id + int64(i),
+			Batch_id:    batch_id,
+			Created:     created,
+			Schema:      TransferSchema}
+
+		from_acc, err := accounts.get(stub, item.From)
+		if err != nil {
+			return nil, errors.New(item_str + err.Error())
+		}
+		var to_acc *Account
+		if needs_receiver(item.T_Type, item.To) {
+			to_acc, err = accounts.get(stub, item.To)
+			if err != nil {
+				return nil, errors.New(item_str + err.Error())
+			}
+		}
+
+		if from_acc.Balance-pending[item.From] < item.Dec_value {
+			return nil, errors.New(item_str + "from account does not have enough funds " + strconv.FormatInt(item.From, 10))
+		}
+
+		settles, err := check_transfer_type(from_acc, to_acc, item)
+		if err != nil {
+			return nil, errors.New(item_str + err.Error())
+		}
+
+		err = check_counterparty(stub, from_acc, to_acc, item)
+		if err != nil {
+			return nil, errors.New(item_str + err.Error())
+		}
+
+		if settles {
+			err = limits.settle(stub, from_acc, item)
+		} else {
+			err = limits.check(stub, from_acc, item)
+		}
+		if err != nil {
+			return nil, errors.New(item_str + err.Error())
+		}
+
+		if settles {
+			item.Status = "approved"
+			item.Approver = creator
+			from_acc.Balance = from_acc.Balance - item.Dec_value
+			to_acc.Balance = to_acc.Balance + item.Inc_value
+			to_acc.IncomingTransfer = append(to_acc.IncomingTransfer, *item)
+			events = append(events, transfer_event("transfer_created", from_acc, item))
+			events = append(events, account_event("balance_changed", from_acc, -item.Dec_value))
+			events = append(events, account_event("balance_changed", to_acc, item.Inc_value))
+		} else {
+			item.Status = "pending"
+			item.Approver = "pending"
+			pending[item.From] = pending[item.From] + item.Dec_value
+			batch.Status = "pending"
+			events = append(events, transfer_event("transfer_created", from_acc, item))
+		}
+		from_acc.OutgoingTransfer = append(from_acc.OutgoingTransfer, *item)
+
+		batch.Items = append(batch.Items, BatchItem{Transfer_id: item.Transfer_id, From: item.From, To: item.To})
+	}
+
+	err = accounts.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = limits.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(items); i++ {
+		err = put_transfer_index(stub, &items[i])
+		if err != nil {
+			return nil, err
+		}
+		if strings.Compare(items[i].Status, "pending") == 0 && credits_receiver(&items[i]) {
+			to_acc, err := accounts.get(stub, items[i].To)
+			if err != nil {
+				return nil, err
+			}
+			err = put_pending_inbound(stub, to_acc.Guava_id, &items[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = put_batch(stub, &batch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch_response(&batch)
+}
+
+// decode_batch_transfers - decode transfers_json, the router reads it too to check the creator on every from account
+func decode_batch_transfers(transfers_json string) ([]BatchTransfer, error) {
+
+	var inputs []BatchTransfer
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(transfers_json)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&inputs)
+	if err != nil {
+		return nil, errors.New("Could not parse transfers_json: " + err.Error())
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("Batch does not contain any transfers")
+	}
+
+	return inputs, nil
+}
+
+// ============================================================================================================================
+// accept_batch - approve every pending transfer in a batch, all or none <batch_id, approver>
+// each transfer settles once its approval policy is satisfied, the approver needs approve on every sending account
+// ============================================================================================================================
+
+func (t *GuavaChaincode) accept_batch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var batch_id, approver string
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <batch_id, approver>")
+	}
+
+	batch_id = args[0]
+	approver = args[1]
+
+	batch, err := get_batch(stub, batch_id)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Compare(batch.Status, "pending") != 0 {
+		return nil, errors.New("Batch " + batch_id + " is not pending, status is " + batch.Status)
+	}
+
+	accounts := account_cache{}
+	statuses := make([]string, 0, len(batch.Items))
+	limits, err := new_limit_checker(stub)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]GuavaEvent, 0)
+
+	for i := 0; i < len(batch.Items); i++ {
+		item := batch.Items[i]
+		tran_id_str := strconv.FormatInt(item.Transfer_id, 10)
+
+		sending_acc, err := accounts.get(stub, item.From)
+		if err != nil {
+			return nil, err
+		}
+
+		transl := find_transfer(sending_acc.OutgoingTransfer, item.Transfer_id)
+		if transl == nil {
+			return nil, errors.New("The transfer id was not found: " + tran_id_str)
+		}
+		// a transfer the approver already approved on its own waits for the other approvers its policy needs
+		if strings.Compare(transl.Status, "pending") != 0 || approved_by(transl, approver) {
+			statuses = append(statuses, transl.Status)
+			continue
+		}
+
+		var receiving_acc *Account
+		if credits_receiver(transl) {
+			receiving_acc, err = accounts.get(stub, item.To)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		settled, err := approve_transfer(stub, limits, sending_acc, receiving_acc, transl, transl.Dec_value, transl.Inc_value, approver)
+		if err != nil {
+			return nil, errors.New("transfer " + tran_id_str + ": " + err.Error())
+		}
+		statuses = append(statuses, transl.Status)
+		if !settled {
+			events = append(events, transfer_event("transfer_approval_added", sending_acc, transl))
+		} else {
+			events = append(events, transfer_event("transfer_accepted", sending_acc, transl))
+			events = append(events, account_event("balance_changed", sending_acc, -transl.Dec_value))
+			if receiving_acc != nil {
+				events = append(events, account_event("balance_changed", receiving_acc, transl.Inc_value))
+			}
+		}
+	}
+
+	err = accounts.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = limits.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the batch stays pending while any of its transfers still needs approvals under the guava policy
+	batch.Status = batch_status(statuses)
+	batch.Approver = approver
+	err = put_batch(stub, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch_response(batch)
+}
+
+// ============================================================================================================================
+// reject_batch - reject every pending transfer in a batch <batch_id, approver>
+// the approver needs approve on every sending account
+// ============================================================================================================================
+
+func (t *GuavaChaincode) reject_batch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var batch_id, approver string
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <batch_id, approver>")
+	}
+
+	batch_id = args[0]
+	approver = args[1]
+
+	batch, err := get_batch(stub, batch_id)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Compare(batch.Status, "pending") != 0 {
+		return nil, errors.New("Batch " + batch_id + " is not pending, status is " + batch.Status)
+	}
+
+	accounts := account_cache{}
+	statuses := make([]string, 0, len(batch.Items))
+	events := make([]GuavaEvent, 0)
+
+	for i := 0; i < len(batch.Items); i++ {
+		item := batch.Items[i]
+
+		sending_acc, err := accounts.get(stub, item.From)
+		if err != nil {
+			return nil, err
+		}
+
+		transl := find_transfer(sending_acc.OutgoingTransfer, item.Transfer_id)
+		if transl == nil {
+			return nil, errors.New("The transfer id was not found: " + strconv.FormatInt(item.Transfer_id, 10))
+		}
+		if strings.Compare(transl.Status, "pending") == 0 {
+			transl.Status = "rejected"
+			transl.Approver = approver
+			events = append(events, transfer_event("transfer_rejected", sending_acc, transl))
+
+			err = del_pending_inbound(stub, accounts, transl)
+			if err != nil {
+				return nil, err
+			}
+		}
+		statuses = append(statuses, transl.Status)
+	}
+
+	err = accounts.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// internal transfers settled when the batch was created, so rejecting the rest leaves the batch partial
+	batch.Status = batch_status(statuses)
+	batch.Approver = approver
+	err = put_batch(stub, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch_response(batch)
+}
+
+// ============================================================================================================================
+// batch_status - the status of a batch from the statuses of its transfers
+// pending while any transfer is, approved once all of them settled, partial if only some did,
+// rejected if none did and one was rejected, and closed when every transfer was cancelled or expired
+// ============================================================================================================================
+
+func batch_status(statuses []string) string {
+
+	settled, rejected := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case "pending":
+			return "pending"
+		case "approved", "settled", "partially_reversed", "reversed":
+			settled++
+		case "rejected":
+			rejected++
+		}
+	}
+
+	if settled == len(statuses) {
+		return "approved"
+	}
+	if settled > 0 {
+		return "partial"
+	}
+	if rejected > 0 {
+		return "rejected"
+	}
+	return "closed"
+}
+
+// ============================================================================================================================
+// read_batch - read a batch and the current state of its transfers <batch_id, caller>
+// the caller needs read on every sending account, so the transfers are read as their sending guava holds them
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_batch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <batch_id, caller>")
+	}
+
+	batch, err := get_batch(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	view := BatchView{Batch: *batch, Transfers: make([]Transfer, 0, len(batch.Items))}
+	accounts := account_cache{}
+
+	for i := 0; i < len(batch.Items); i++ {
+		item := batch.Items[i]
+
+		sending_acc, err := accounts.get(stub, item.From)
+		if err != nil {
+			return nil, err
+		}
+
+		transl := find_transfer(sending_acc.OutgoingTransfer, item.Transfer_id)
+		if transl != nil {
+			view.Transfers = append(view.Transfers, *transl)
+		}
+	}
+
+	viewAsBytes, _ := json.Marshal(view)
+	return viewAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_batch / put_batch - load and store batch records
+// ============================================================================================================================
+
+func get_batch(stub shim.ChaincodeStubInterface, batch_id string) (*Batch, error) {
+
+	batch := Batch{}
+	found, err := get_record(stub, &batch, BatchKey, batch_id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Could not find batch " + batch_id)
+	}
+	upgrade_batch(&batch)
+
+	return &batch, nil
+}
+
+func put_batch(stub shim.ChaincodeStubInterface, batch *Batch) error {
+	return put_record(stub, batch, BatchKey, strconv.FormatInt(batch.Batch_id, 10))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateBatchTransfer(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp BatchResponse
+	l.decode(l.ok("create_batch_transfer", "bob", "2026-01-05", `[
+		{"message":"sweep", "fx_rate":1, "inc_value":100, "dec_value":100, "from":1, "to":2, "type":"internal"},
+		{"message":"rent", "fx_rate":1, "inc_value":300, "dec_value":300, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"message":"back", "fx_rate":1, "inc_value":50, "dec_value":50, "from":2, "to":1, "type":"internal"}]`), &resp)
+
+	if resp.Batch_id != 1 || resp.Status != "pending" || len(resp.Transfer_ids) != 3 || resp.Transfer_ids[2] != 3 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.balance(1, 950)
+	l.balance(2, 550)
+
+	for _, id := range resp.Transfer_ids {
+		if transl := l.transfer(l.account_of(id), id); transl.Batch_id != 1 || transl.Creator != "bob" {
+			t.Fatalf("unexpected transfer %+v", transl)
+		}
+	}
+
+	var view BatchView
+	l.decode(l.ok("read_batch", "1", "dave"), &view)
+	if len(view.Transfers) != 3 || view.Transfers[1].Status != "pending" || view.Transfers[0].Status != "approved" {
+		t.Fatalf("unexpected batch %+v", view)
+	}
+
+	// ids continue after the batch
+	if id := l.payment("1"); id != 4 {
+		t.Fatalf("next transfer id = %d, want 4", id)
+	}
+}
+
+func TestCreateBatchTransferAllOrNone(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	// the second payment would overdraw account 1 once the first is counted
+	l.fail_with("batch item 1: from account does not have enough funds", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":800, "dec_value":800, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":800, "dec_value":800, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.fail_with("batch item 1: Could not find account 9", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"internal"},
+		{"inc_value":10, "dec_value":10, "from":1, "to":9, "type":"internal"}]`)
+	l.fail_with("batch item 0: missing transfer type", "create_batch_transfer", "bob", "t", `[{"inc_value":10, "dec_value":10, "from":1, "to":2}]`)
+	l.fail_with("batch item 0: transfer values must be positive", "create_batch_transfer", "bob", "t", `[{"inc_value":-10, "dec_value":-10, "from":1, "to":2, "type":"internal"}]`)
+	l.fail_with("does not contain any transfers", "create_batch_transfer", "bob", "t", `[]`)
+	l.fail_with("Could not parse transfers_json", "create_batch_transfer", "bob", "t", `{`)
+	l.fail_with("batch item 0: Could not parse beneficiary_json", "create_batch_transfer", "bob", "t", `[{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"payment", "beneficiary":"acme"}]`)
+	l.fail_with("batch item 0: Beneficiary needs an iban or an account_number", "create_batch_transfer", "bob", "t", `[{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies"}}]`)
+
+	// only the fields a client sets on a new transfer are accepted, approvals and settlement state are the ledger's
+	for _, field := range []string{`"approvals":[{"approver":"carol"}]`, `"reversed_value":10`, `"export_reference":"X"`, `"settled_time":"t"`, `"cancelled_by":"bob"`, `"status":"approved"`} {
+		l.fail_with(`unknown field`, "create_batch_transfer", "bob", "t", `[{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"internal", `+field+`}]`)
+	}
+
+	l.balance(1, 1000)
+	l.balance(2, 500)
+	if len(l.account(1).OutgoingTransfer) != 0 {
+		t.Fatalf("a failed batch left transfers behind")
+	}
+	l.fail_with("Could not find batch 1", "read_batch", "1", "dave")
+}
+
+func TestAcceptBatch(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":100, "dec_value":100, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":200, "dec_value":200, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+
+	var resp BatchResponse
+	l.decode(l.ok("accept_batch", "1", "carol"), &resp)
+	if resp.Status != "approved" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.balance(1, 700)
+	l.balance(2, 500)
+	l.fail_with("is not pending", "accept_batch", "1", "carol")
+	l.fail_with("is not pending", "reject_batch", "1", "carol")
+	l.fail_with("Could not find batch 7", "accept_batch", "7", "carol")
+}
+
+func TestBatchPermissions(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "100", "root")
+
+	// the creator needs create on the from account of every item
+	l.fail_with("User bob does not have the create permission in guava 2", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"internal"},
+		{"inc_value":10, "dec_value":10, "from":3, "to":1, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.fail_with("User carol does not have the create permission in guava 1", "create_batch_transfer", "carol", "t", `[{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"internal"}]`)
+	l.balance(3, 100)
+
+	// and the approver approve on the sending account of every transfer
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":100, "dec_value":100, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.fail_with("User dave does not have the approve permission in guava 1", "accept_batch", "1", "dave")
+	l.fail_with("User bob does not have the approve permission in guava 1", "reject_batch", "1", "bob")
+	if status := l.transfer(1, 1).Status; status != "pending" {
+		t.Fatalf("transfer status = %s, want pending", status)
+	}
+}
+
+func TestAcceptBatchAllOrNone(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":600, "dec_value":600, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":300, "dec_value":300, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.ok("decrement_value", "1", "200", "alice")
+
+	l.fail_with("transfer 2: sending account does not have enough funds", "accept_batch", "1", "carol")
+	l.balance(1, 800)
+	l.balance(2, 500)
+	if status := l.transfer(1, 1).Status; status != "pending" {
+		t.Fatalf("first transfer status = %s, want pending", status)
+	}
+}
+
+func TestRejectBatch(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":100, "dec_value":100, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":200, "dec_value":200, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.ok("cancel_transfer", "1", "2", "bob", "duplicate")
+
+	var resp BatchResponse
+	l.decode(l.ok("reject_batch", "1", "carol"), &resp)
+	if resp.Status != "rejected" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if first, second := l.transfer(1, 1), l.transfer(1, 2); first.Status != "rejected" || second.Status != "cancelled" {
+		t.Fatalf("statuses = %s, %s", first.Status, second.Status)
+	}
+	l.balance(1, 1000)
+	l.balance(2, 500)
+}
+
+func TestRejectBatchKeepsSettledItems(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	// the internal item settled when the batch was created, rejecting the batch leaves it in place
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":50, "dec_value":50, "from":1, "to":2, "type":"internal"},
+		{"inc_value":100, "dec_value":100, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+
+	var resp BatchResponse
+	l.decode(l.ok("reject_batch", "1", "carol"), &resp)
+	if resp.Status != "partial" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if first, second := l.transfer(1, 1), l.transfer(1, 2); first.Status != "approved" || second.Status != "rejected" {
+		t.Fatalf("statuses = %s, %s", first.Status, second.Status)
+	}
+	l.balance(1, 950)
+	l.balance(2, 550)
+	l.fail_with("is not pending, status is partial", "accept_batch", "1", "carol")
+}
+
+func TestAcceptBatchClosed(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("set_transfer_ttl", "1", "alice", "payment", "3600")
+
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":100, "dec_value":100, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":200, "dec_value":200, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.ok("cancel_transfer", "1", "1", "bob", "duplicate")
+	l.now = l.now.Add(2 * time.Hour)
+	l.ok("expire_transfers", "1", "carol")
+
+	// nothing was left to approve, so nothing was approved
+	var resp BatchResponse
+	l.decode(l.ok("accept_batch", "1", "carol"), &resp)
+	if resp.Status != "closed" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	l.balance(1, 1000)
+}
+
+func TestAcceptBatchSkipsOwnApprovals(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("set_approval_policy", "1", "alice", `{"bands":[{"min_amount":0,"approvers":2}]}`)
+
+	l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":100, "dec_value":100, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":200, "dec_value":200, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`)
+	l.ok("accept_transfer", "2", "1", "1", "100", "100", "carol")
+
+	// carol's approval of the first item is not counted twice, the second item gets hers
+	var resp BatchResponse
+	l.decode(l.ok("accept_batch", "1", "carol"), &resp)
+	if resp.Status != "pending" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if first, second := l.transfer(1, 1), l.transfer(1, 2); len(first.Approvals) != 1 || len(second.Approvals) != 1 {
+		t.Fatalf("approvals = %+v, %+v", first.Approvals, second.Approvals)
+	}
+
+	l.decode(l.ok("accept_batch", "1", "alice"), &resp)
+	if resp.Status != "approved" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	l.balance(1, 700)
+}
+
+// account_of finds the account that sent a transfer in the setup guava
+func (l *test_ledger) account_of(transfer_id int64) int64 {
+	l.t.Helper()
+
+	for _, account_id := range []int64{1, 2} {
+		if find_transfer(l.account(account_id).OutgoingTransfer, transfer_id) != nil {
+			return account_id
+		}
+	}
+
+	l.t.Fatalf("transfer %d was not sent by any account", transfer_id)
+	return 0
+}
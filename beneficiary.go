@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Payments name the beneficiary they are paid to so settlement instructions can be generated from the transfer.
+// A beneficiary is paid either into an IBAN or into an account number at the bank a BIC identifies, and an account
+// pays from a bank account identified the same way. Lengths follow the limits of ISO 20022 payment messages and
+// count characters, not bytes.
+
+const MaxBeneficiaryName = 70
+const MaxRemittanceInfo = 140
+const MaxAddressLine = 70
+
+type Beneficiary struct {
+	Name            string   `json:"name"`            //who the payment is made to
+	Iban            string   `json:"iban"`            //international bank account number, stored without spaces
+	Account_number  string   `json:"account_number"`  //account number for banks without IBANs, needs a bic
+	Bic             string   `json:"bic"`             //business identifier code of the beneficiary's bank
+	Address         *Address `json:"address"`         //postal address of the beneficiary
+	Remittance_info string   `json:"remittance_info"` //unstructured remittance information passed to the beneficiary
+}
+
+type BankAccount struct {
+	Iban           string `json:"iban"`           //international bank account number, stored without spaces
+	Account_number string `json:"account_number"` //account number for banks without IBANs, needs a bic
+	Bic            string `json:"bic"`            //business identifier code of the bank
+}
+
+type Address struct {
+	Street   string `json:"street"`
+	Postcode string `json:"postcode"`
+	Town     string `json:"town"`
+	Country  string `json:"country"` //ISO 3166 two letter country code
+}
+
+// ============================================================================================================================
+// check_beneficiary - validate and normalise the beneficiary of a payment
+// ============================================================================================================================
+
+func check_beneficiary(beneficiary *Beneficiary) error {
+
+	if beneficiary == nil {
+		return errors.New("Payments need a beneficiary")
+	}
+
+	beneficiary.Name = strings.TrimSpace(beneficiary.Name)
+	if beneficiary.Name == "" {
+		return errors.New("Beneficiary name is missing")
+	}
+	if utf8.RuneCountInString(beneficiary.Name) > MaxBeneficiaryName {
+		return errors.New("Beneficiary name is longer than " + strconv.Itoa(MaxBeneficiaryName) + " characters")
+	}
+
+	if utf8.RuneCountInString(beneficiary.Remittance_info) > MaxRemittanceInfo {
+		return errors.New("Beneficiary remittance_info is longer than " + strconv.Itoa(MaxRemittanceInfo) + " characters")
+	}
+
+	err := check_bank_details("Beneficiary", &beneficiary.Iban, beneficiary.Account_number, &beneficiary.Bic)
+	if err != nil {
+		return err
+	}
+
+	return check_address(beneficiary.Address)
+}
+
+// ============================================================================================================================
+// check_bank_account - validate and normalise the bank account an account pays from
+// ============================================================================================================================
+
+func check_bank_account(bank *BankAccount) error {
+
+	return check_bank_details("Bank account", &bank.Iban, bank.Account_number, &bank.Bic)
+}
+
+// ============================================================================================================================
+// check_bank_details - validate and normalise an iban, or an account number at the bank a bic identifies
+// holder starts the error messages, the iban loses its spaces and both codes are upper cased
+// ============================================================================================================================
+
+func check_bank_details(holder string, iban *string, account_number string, bic *string) error {
+
+	if *iban != "" && account_number != "" {
+		return errors.New(holder + " has both an iban and an account_number, expecting one")
+	}
+
+	if *bic != "" {
+		*bic = strings.ToUpper(*bic)
+		if !valid_bic(*bic) {
+			return errors.New("Invalid " + strings.ToLower(holder) + " bic " + *bic)
+		}
+	}
+
+	if *iban != "" {
+		*iban = strings.ToUpper(strings.Replace(*iban, " ", "", -1))
+		if !valid_iban(*iban) {
+			return errors.New("Invalid " + strings.ToLower(holder) + " iban " + *iban)
+		}
+	} else if account_number != "" {
+		if *bic == "" {
+			return errors.New(holder + " account_number needs the bic of its bank")
+		}
+	} else {
+		return errors.New(holder + " needs an iban or an account_number")
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// parse_beneficiary - decode the beneficiary_json argument of a transfer, nil if it is empty
+// ============================================================================================================================
+
+func parse_beneficiary(beneficiary_json string) (*Beneficiary, error) {
+
+	if beneficiary_json == "" || beneficiary_json == "null" {
+		return nil, nil
+	}
+
+	beneficiary := Beneficiary{}
+	err := json.Unmarshal([]byte(beneficiary_json), &beneficiary)
+	if err != nil {
+		return nil, errors.New("Could not parse beneficiary_json: " + err.Error())
+	}
+
+	return &beneficiary, nil
+}
+
+func check_address(address *Address) error {
+
+	if address == nil {
+		return nil
+	}
+
+	for _, line := range []string{address.Street, address.Postcode, address.Town} {
+		if utf8.RuneCountInString(line) > MaxAddressLine {
+			return errors.New("Beneficiary address line is longer than " + strconv.Itoa(MaxAddressLine) + " characters")
+		}
+	}
+
+	address.Country = strings.ToUpper(address.Country)
+	if len(address.Country) != 2 || !all_letters(address.Country) {
+		return errors.New("Beneficiary address country must be a two letter country code, got " + address.Country)
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// valid_iban - check the format and mod 97 check digits of an IBAN without spaces
+// ============================================================================================================================
+
+func valid_iban(iban string) bool {
+
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	if !all_letters(iban[0:2]) || !all_digits(iban[2:4]) {
+		return false
+	}
+
+	// move the country code and check digits to the end and read letters as 10 to 35
+	var digits strings.Builder
+	rearranged := iban[4:] + iban[0:4]
+	for i := 0; i < len(rearranged); i++ {
+		c := rearranged[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteByte(c)
+		case c >= 'A' && c <= 'Z':
+			digits.WriteString(strconv.Itoa(int(c-'A') + 10))
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// ============================================================================================================================
+// valid_bic - check a BIC is a 4 letter bank code, 2 letter country code, 2 character location and optional 3 character branch
+// ============================================================================================================================
+
+func valid_bic(bic string) bool {
+
+	if len(bic) != 8 && len(bic) != 11 {
+		return false
+	}
+
+	return all_letters(bic[0:6]) && all_alphanumeric(bic[6:])
+}
+
+func all_letters(s string) bool {
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func all_digits(s string) bool {
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func all_alphanumeric(s string) bool {
+
+	for i := 0; i < len(s); i++ {
+		if !all_letters(s[i:i+1]) && !all_digits(s[i:i+1]) {
+			return false
+		}
+	}
+
+	return true
+}
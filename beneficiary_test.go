@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidIban(t *testing.T) {
+	valid := []string{"GB82WEST12345698765432", "DE89370400440532013000", "FR1420041010050500013M02606", "NL91ABNA0417164300"}
+	for _, iban := range valid {
+		if !valid_iban(iban) {
+			t.Fatalf("%s should be a valid iban", iban)
+		}
+	}
+
+	invalid := []string{"GB83WEST12345698765432", "GB82WEST1234569876543", "82GBWEST12345698765432", "GB82WEST1234569876543!", "GB82"}
+	for _, iban := range invalid {
+		if valid_iban(iban) {
+			t.Fatalf("%s should not be a valid iban", iban)
+		}
+	}
+}
+
+func TestValidBic(t *testing.T) {
+	for _, bic := range []string{"NWBKGB2L", "DEUTDEFF500", "ROYCCAT2"} {
+		if !valid_bic(bic) {
+			t.Fatalf("%s should be a valid bic", bic)
+		}
+	}
+
+	for _, bic := range []string{"NWBKGB2", "NWBK1B2L", "DEUTDEFF50", "NWBKGB2L-"} {
+		if valid_bic(bic) {
+			t.Fatalf("%s should not be a valid bic", bic)
+		}
+	}
+}
+
+func TestBeneficiaryValidation(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	pay := func(beneficiary_json string) []string {
+		return []string{"create_transfer", "m", "1", "10", "10", "1", "2", "payment", "t", "bob", beneficiary_json}
+	}
+
+	l.fail_with("Beneficiary name is missing", pay(`{"name":" ", "iban":"GB82WEST12345698765432"}`)...)
+	l.fail_with("Beneficiary name is longer than 70 characters", pay(`{"name":"`+strings.Repeat("a", 71)+`", "iban":"GB82WEST12345698765432"}`)...)
+	l.fail_with("Invalid beneficiary iban GB83WEST12345698765432", pay(`{"name":"Acme", "iban":"GB83 WEST 1234 5698 7654 32"}`)...)
+	l.fail_with("Invalid beneficiary bic NWBK1B2L", pay(`{"name":"Acme", "iban":"GB82WEST12345698765432", "bic":"nwbk1b2l"}`)...)
+	l.fail_with("Beneficiary account_number needs the bic of its bank", pay(`{"name":"Acme", "account_number":"555"}`)...)
+	l.fail_with("Beneficiary has both an iban and an account_number", pay(`{"name":"Acme", "iban":"GB82WEST12345698765432", "account_number":"555"}`)...)
+	l.fail_with("Beneficiary remittance_info is longer than 140 characters", pay(`{"name":"Acme", "iban":"GB82WEST12345698765432", "remittance_info":"`+strings.Repeat("r", 141)+`"}`)...)
+	l.fail_with("Beneficiary address country must be a two letter country code, got GBR", pay(`{"name":"Acme", "iban":"GB82WEST12345698765432", "address":{"town":"London", "country":"gbr"}}`)...)
+	l.fail_with("batch item 0: Invalid beneficiary iban", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme", "iban":"GB00"}}]`)
+
+	// a valid beneficiary is stored normalised
+	var resp TransferResponse
+	l.decode(l.ok(pay(`{"name":"Acme Supplies", "iban":"gb82 west 1234 5698 7654 32", "bic":"nwbkgb2l",
+		"address":{"street":"1 High Street", "postcode":"EC1A 1BB", "town":"London", "country":"gb"}, "remittance_info":"invoice 7"}`)...), &resp)
+
+	got := l.transfer(1, resp.Transfer_id).Beneficiary
+	if got == nil || got.Iban != "GB82WEST12345698765432" || got.Bic != "NWBKGB2L" || got.Address == nil ||
+		got.Address.Country != "GB" || got.Remittance_info != "invoice 7" {
+		t.Fatalf("unexpected beneficiary %+v", got)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// A guava can declare the guavas and accounts its accounts may send to. Accounts of the guava itself are always
+// allowed. Once a guava has a whitelist, transfers to anyone else need an approval from a user holding one of its
+// elevated roles before they settle, on top of what the approval policy asks for. Guavas without a whitelist send anywhere.
+
+type CounterpartyWhitelist struct {
+	Guavas         []string `json:"guavas"`         //guavas whose accounts may be sent to
+	Accounts       []int64  `json:"accounts"`       //accounts of other guavas that may be sent to
+	Elevated_roles []string `json:"elevated_roles"` //roles or access rights that may approve other counterparties, owner if empty
+	Schema         int      `json:"schema"`         //layout version the whitelist was written with
+}
+
+// ============================================================================================================================
+// set_counterparties - set the counterparty whitelist of a guava, owners only <guava_id, whitelist_json, owner>
+// whitelist_json is {"guavas":[], "accounts":[], "elevated_roles":[]}
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_counterparties(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, whitelist_json, owner>")
+	}
+
+	guava_id := args[0]
+	whitelist := CounterpartyWhitelist{}
+
+	err := json.Unmarshal([]byte(args[1]), &whitelist)
+	if err != nil {
+		return nil, errors.New("Could not parse whitelist_json: " + err.Error())
+	}
+
+	if whitelist.Guavas == nil {
+		whitelist.Guavas = make([]string, 0)
+	}
+	if whitelist.Accounts == nil {
+		whitelist.Accounts = make([]int64, 0)
+	}
+	if len(whitelist.Elevated_roles) == 0 {
+		whitelist.Elevated_roles = []string{"owner"}
+	}
+
+	for i := 0; i < len(whitelist.Guavas); i++ {
+		_, err = get_guava(stub, whitelist.Guavas[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < len(whitelist.Accounts); i++ {
+		_, err = get_account(stub, strconv.FormatInt(whitelist.Accounts[i], 10))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < len(whitelist.Elevated_roles); i++ {
+		_, err = get_role(stub, guava_id, whitelist.Elevated_roles[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	whitelist.Schema = WhitelistSchema
+	err = put_record(stub, &whitelist, WhitelistKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(WhitelistResponse{Version: ApiVersion, Guava_id: guava_id, Whitelist: &whitelist})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_counterparties - read the counterparty whitelist of a guava, null if it has none <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_counterparties(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	whitelist, err := get_whitelist(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	whitelistAsBytes, _ := json.Marshal(whitelist)
+	return whitelistAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_whitelist - load the counterparty whitelist of a guava, nil if it has none
+// ============================================================================================================================
+
+func get_whitelist(stub shim.ChaincodeStubInterface, guava_id string) (*CounterpartyWhitelist, error) {
+
+	whitelist := CounterpartyWhitelist{}
+
+	found, err := get_record(stub, &whitelist, WhitelistKey, guava_id)
+	if err != nil || !found {
+		return nil, err
+	}
+	upgrade_whitelist(&whitelist)
+
+	return &whitelist, nil
+}
+
+// ============================================================================================================================
+// check_counterparty - check a new transfer may go from one account to the other and flag it for elevated approval
+// if the sending guava has not whitelisted the receiving account. A payment without a To account has no counterparty on
+// the ledger to whitelist, so a guava with a whitelist always elevates it
+// ============================================================================================================================
+
+func check_counterparty(stub shim.ChaincodeStubInterface, from_acc *Account, to_acc *Account, transl *Transfer) error {
+
+	if to_acc != nil && strings.Compare(from_acc.Guava_id, to_acc.Guava_id) == 0 {
+		return nil
+	}
+
+	whitelist, err := get_whitelist(stub, from_acc.Guava_id)
+	if err != nil || whitelist == nil {
+		return err
+	}
+
+	transl.Elevated = to_acc == nil || !whitelisted(whitelist, to_acc)
+	return nil
+}
+
+func whitelisted(whitelist *CounterpartyWhitelist, acc *Account) bool {
+
+	for i := 0; i < len(whitelist.Guavas); i++ {
+		if strings.Compare(whitelist.Guavas[i], acc.Guava_id) == 0 {
+			return true
+		}
+	}
+
+	for i := 0; i < len(whitelist.Accounts); i++ {
+		if whitelist.Accounts[i] == acc.AccountID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ============================================================================================================================
+// elevated_approver - check an approver holds one of the elevated roles of the sending guava, assigned roles only count
+// when they cover the sending account
+// ============================================================================================================================
+
+func elevated_approver(stub shim.ChaincodeStubInterface, sending_acc *Account, approver string) (bool, error) {
+
+	guava_id := sending_acc.Guava_id
+
+	whitelist, err := get_whitelist(stub, guava_id)
+	if err != nil {
+		return false, err
+	}
+
+	roles := []string{"owner"}
+	if whitelist != nil {
+		roles = whitelist.Elevated_roles
+	}
+
+	user, err := get_actor(stub, guava_id, approver)
+	if err != nil {
+		return false, err
+	}
+
+	return user_holds_role(user, sending_acc.AccountID, roles...), nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInternalStaysInGuava(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	l.fail_with("Internal transfers must stay within a guava, account 3 is in guava 2", "create_transfer", "m", "1", "10", "10", "1", "3", "internal", "t", "bob", "")
+	l.fail_with("batch item 0: Internal transfers must stay within a guava", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":10, "dec_value":10, "from":1, "to":3, "type":"internal"}]`)
+	l.balance(1, 1000)
+	l.balance(3, 0)
+
+	// payments can still go to other guavas
+	l.ok("create_transfer", "m", "1", "10", "10", "1", "3", "payment", "t", "bob", beneficiary)
+}
+
+func TestCounterpartyWhitelist(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+	l.ok("create_account", "other", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	if string(l.ok("read_counterparties", "1", "dave")) != "null" {
+		t.Fatalf("a guava without a whitelist should read null")
+	}
+
+	var resp WhitelistResponse
+	l.decode(l.ok("set_counterparties", "1", `{"accounts":[3]}`, "alice"), &resp)
+	if resp.Whitelist == nil || len(resp.Whitelist.Accounts) != 1 || len(resp.Whitelist.Elevated_roles) != 1 ||
+		resp.Whitelist.Elevated_roles[0] != "owner" || resp.Whitelist.Schema != WhitelistSchema {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	l.fail_with("User bob does not have the owner permission in guava 1", "set_counterparties", "1", `{}`, "bob")
+	l.fail_with("Could not find account 9", "set_counterparties", "1", `{"accounts":[9]}`, "alice")
+	l.fail_with("Could not find role boss in guava 1", "set_counterparties", "1", `{"elevated_roles":["boss"]}`, "alice")
+
+	// whitelisted accounts and the guava's own accounts settle on a normal approval
+	listed := l.payment("10")
+	l.ok("accept_transfer", "2", "1", format_int(listed), "10", "10", "carol")
+	l.balance(1, 990)
+
+	var tr TransferResponse
+	l.decode(l.ok("create_transfer", "m", "1", "10", "10", "1", "3", "payment", "t", "bob", beneficiary), &tr)
+	l.ok("accept_transfer", "3", "1", format_int(tr.Transfer_id), "10", "10", "carol")
+	l.balance(1, 980)
+
+	// other counterparties wait for an owner
+	l.decode(l.ok("create_transfer", "m", "1", "10", "10", "1", "4", "payment", "t", "bob", beneficiary), &tr)
+	if !tr.Transfer.Elevated {
+		t.Fatalf("a transfer to a counterparty off the whitelist should be elevated")
+	}
+	l.decode(l.ok("accept_transfer", "4", "1", format_int(tr.Transfer_id), "10", "10", "carol"), &tr)
+	if tr.Status != "pending" || tr.Transfer.Approvals[0].Elevated {
+		t.Fatalf("an approval without an elevated role settled the transfer %+v", tr)
+	}
+	l.balance(1, 980)
+
+	l.decode(l.ok("accept_transfer", "4", "1", format_int(tr.Transfer_id), "10", "10", "alice"), &tr)
+	if tr.Status != "approved" || !tr.Transfer.Approvals[1].Elevated {
+		t.Fatalf("the owner approval did not settle the transfer %+v", tr)
+	}
+	l.balance(1, 970)
+
+	// a payment without a To account has no counterparty on the ledger to whitelist
+	l.decode(l.ok("create_transfer", "m", "1", "10", "10", "1", "0", "payment", "t", "bob", beneficiary), &tr)
+	if !tr.Transfer.Elevated {
+		t.Fatalf("a payment without a To account should be elevated by a guava with a whitelist")
+	}
+
+	// whitelisting the whole guava lets its accounts through
+	l.ok("set_counterparties", "1", `{"guavas":["3"]}`, "alice")
+	l.decode(l.ok("create_transfer", "m", "1", "10", "10", "1", "4", "payment", "t", "bob", beneficiary), &tr)
+	if tr.Transfer.Elevated {
+		t.Fatalf("a transfer to a whitelisted guava should not be elevated")
+	}
+
+	var whitelist CounterpartyWhitelist
+	l.decode(l.ok("read_counterparties", "1", "dave"), &whitelist)
+	if len(whitelist.Guavas) != 1 || whitelist.Guavas[0] != "3" || len(whitelist.Accounts) != 0 {
+		t.Fatalf("unexpected whitelist %+v", whitelist)
+	}
+}
+
+func TestElevatedRoles(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	l.ok("define_role", "1", "treasurer", `["approve"]`, "alice")
+	l.ok("assign_role", "1", "erin", "treasurer", `[2]`, "alice")
+	l.ok("set_counterparties", "1", `{"elevated_roles":["treasurer"]}`, "alice")
+
+	// erin's role only covers account 2, so her approval on account 1 is not elevated
+	var tr TransferResponse
+	l.decode(l.ok("create_transfer", "m", "1", "10", "10", "1", "3", "payment", "t", "bob", beneficiary), &tr)
+	l.decode(l.ok("accept_transfer", "3", "1", format_int(tr.Transfer_id), "10", "10", "erin"), &tr)
+	if tr.Status != "pending" {
+		t.Fatalf("an approval outside the role's accounts settled the transfer %+v", tr)
+	}
+
+	l.ok("assign_role", "1", "carol", "treasurer", `[1]`, "alice")
+	l.decode(l.ok("accept_transfer", "3", "1", format_int(tr.Transfer_id), "10", "10", "carol"), &tr)
+	if tr.Status != "approved" {
+		t.Fatalf("the treasurer approval did not settle the transfer %+v", tr)
+	}
+	l.balance(1, 990)
+	l.balance(3, 0)
+}
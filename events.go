@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Fabric keeps a single chaincode event per transaction, so every function collects its lifecycle
+// changes and emits them together. The event name is the type of the first change.
+
+type GuavaEvent struct {
+	Event_type  string  `json:"event_type"`  //<account_created,transfer_created,transfer_approval_added,transfer_accepted,transfer_rejected,transfer_cancelled,transfer_expired,transfer_reversed,transfer_exported,transfer_settled,balance_changed>
+	Guava_id    string  `json:"guava_id"`    //guava of the account, or of the sending account for transfers
+	Account_id  int64   `json:"account_id"`  //account whose balance changed or that was created
+	Transfer_id int64   `json:"transfer_id"` //transfer that changed
+	Batch_id    int64   `json:"batch_id"`    //batch the transfer belongs to
+	From        int64   `json:"from"`        //account number who generated transfer
+	To          int64   `json:"to"`          //account number receiving transfer
+	Amount      float64 `json:"amount"`      //dec_value of a transfer, or the change in balance
+	Balance     float64 `json:"balance"`     //resulting account balance
+	Currency    string  `json:"currency"`    //currency of the account
+	Status      string  `json:"status"`      //resulting transfer status
+}
+
+type EventPayload struct {
+	Events []GuavaEvent `json:"events"`
+}
+
+// ============================================================================================================================
+// transfer_event - describe a change to a transfer sent by from_acc
+// ============================================================================================================================
+
+func transfer_event(event_type string, from_acc *Account, transl *Transfer) GuavaEvent {
+
+	return GuavaEvent{
+		Event_type:  event_type,
+		Guava_id:    from_acc.Guava_id,
+		Transfer_id: transl.Transfer_id,
+		Batch_id:    transl.Batch_id,
+		From:        transl.From,
+		To:          transl.To,
+		Amount:      transl.Dec_value,
+		Status:      transl.Status}
+}
+
+// ============================================================================================================================
+// account_event - describe a change to an account, amount is the change in balance
+// ============================================================================================================================
+
+func account_event(event_type string, acc *Account, amount float64) GuavaEvent {
+
+	return GuavaEvent{
+		Event_type: event_type,
+		Guava_id:   acc.Guava_id,
+		Account_id: acc.AccountID,
+		Amount:     amount,
+		Balance:    acc.Balance,
+		Currency:   acc.Currency}
+}
+
+// ============================================================================================================================
+// emit_events - set the chaincode event for this transaction
+// ============================================================================================================================
+
+func emit_events(stub shim.ChaincodeStubInterface, events []GuavaEvent) error {
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	payloadAsBytes, _ := json.Marshal(EventPayload{Events: events})
+	return stub.SetEvent(events[0].Event_type, payloadAsBytes)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func (l *test_ledger) last_events(name string) []GuavaEvent {
+	l.t.Helper()
+
+	if len(l.events) == 0 {
+		l.t.Fatalf("no chaincode event was set")
+	}
+
+	event := l.events[len(l.events)-1]
+	if event.EventName != name {
+		l.t.Fatalf("event name = %s, want %s", event.EventName, name)
+	}
+
+	var payload EventPayload
+	l.decode(event.Payload, &payload)
+	return payload.Events
+}
+
+func TestAccountEvents(t *testing.T) {
+	l := new_ledger(t)
+	l.init()
+
+	l.ok("create_account", "ops", "-1", "CAD", "CA", "OPR", "1000", "root")
+	events := l.last_events("account_created")
+	if len(events) != 1 || events[0].Account_id != 1 || events[0].Guava_id != "1" || events[0].Balance != 1000 || events[0].Currency != "CAD" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	l.ok("create_user", "alice", "true", "true", "true", "true", "1", identity("alice"), "root")
+	l.ok("decrement_value", "1", "10", "alice")
+	events = l.last_events("balance_changed")
+	if len(events) != 1 || events[0].Amount != -10 || events[0].Balance != 990 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestTransferEvents(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("create_transfer", "sweep", "1", "100", "100", "1", "2", "internal", "t", "bob", "")
+	events := l.last_events("transfer_created")
+	if len(events) != 3 || events[0].Transfer_id != 1 || events[0].Status != "approved" ||
+		events[1].Account_id != 1 || events[1].Balance != 900 || events[2].Account_id != 2 || events[2].Balance != 600 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	id := strconv.FormatInt(l.payment("200"), 10)
+	events = l.last_events("transfer_created")
+	if len(events) != 1 || events[0].Status != "pending" || events[0].Amount != 200 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	// the payment leaves the ledger, only the sender's balance changes
+	l.ok("accept_transfer", "2", "1", id, "200", "200", "carol")
+	events = l.last_events("transfer_accepted")
+	if len(events) != 2 || events[0].Status != "approved" || events[1].Account_id != 1 || events[1].Amount != -200 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	id = strconv.FormatInt(l.payment("50"), 10)
+	l.ok("reject_transfer", "1", id, "carol")
+	events = l.last_events("transfer_rejected")
+	if len(events) != 1 || events[0].Status != "rejected" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestApprovalAddedEvent(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_approval_policy", "1", "alice", `{"bands":[{"min_amount":0,"approvers":2}]}`)
+	id := strconv.FormatInt(l.payment("200"), 10)
+
+	l.ok("accept_transfer", "2", "1", id, "200", "200", "carol")
+	events := l.last_events("transfer_approval_added")
+	if len(events) != 1 || events[0].Status != "pending" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type TransferTTLs struct {
+	Ttls   map[string]int64 `json:"ttls"`   //seconds a pending transfer of each type may stay pending
+	Schema int              `json:"schema"` //layout version the ttls were written with
+}
+
+// ============================================================================================================================
+// set_transfer_ttl - set how long pending transfers of a type may stay pending in a guava, owners only
+// <guava_id, owner, trans_type, ttl_seconds>, a ttl of 0 means transfers of that type never expire
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_transfer_ttl(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var guava_id, trans_type string
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, owner, trans_type, ttl_seconds>")
+	}
+
+	guava_id = args[0]
+	trans_type = args[2]
+
+	err := check_trans_type(trans_type)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || ttl < 0 {
+		return nil, errors.New("ttl_seconds must be a whole number of seconds, got " + args[3])
+	}
+
+	ttls, err := get_transfer_ttls(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl == 0 {
+		delete(ttls, trans_type)
+	} else {
+		ttls[trans_type] = ttl
+	}
+
+	err = put_record(stub, &TransferTTLs{Ttls: ttls, Schema: TTLSchema}, TTLKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(TTLResponse{Version: ApiVersion, Guava_id: guava_id, Ttls: ttls})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_transfer_ttl - read the time-to-live in seconds of each transfer type in a guava <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_transfer_ttl(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	ttls, err := get_transfer_ttls(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ttlsAsBytes, _ := json.Marshal(ttls)
+	return ttlsAsBytes, nil
+}
+
+// ============================================================================================================================
+// expire_transfers - move pending transfers older than their time-to-live to expired <guava_id, actor>
+// returns the ids of the transfers expired by this call
+// ============================================================================================================================
+
+func (t *GuavaChaincode) expire_transfers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, actor>")
+	}
+
+	guava_id := args[0]
+
+	ttls, err := get_transfer_ttls(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	now_str := now.Format(time.RFC3339)
+
+	guava, err := get_guava(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := account_cache{}
+	expired_ids := make([]int64, 0)
+	events := make([]GuavaEvent, 0)
+	account_nums := guava.Accounts
+
+	for i := 0; i < len(account_nums); i++ {
+		acc, err := accounts.get(stub, account_nums[i])
+		if err != nil {
+			return nil, err
+		}
+
+		changed := false
+		for j := 0; j < len(acc.OutgoingTransfer); j++ {
+			transl := &acc.OutgoingTransfer[j]
+			if strings.Compare(transl.Status, "pending") != 0 {
+				continue
+			}
+
+			ttl, ok := ttls[transl.T_Type]
+			if !ok {
+				continue
+			}
+
+			// transfers written before creation times were recorded can not be aged
+			created, err := time.Parse(time.RFC3339, transl.Created)
+			if err != nil {
+				continue
+			}
+
+			// pending transfers have not moved any funds, so expiring one leaves both balances as they are
+			if !now.Before(created.Add(time.Duration(ttl) * time.Second)) {
+				transl.Status = "expired"
+				transl.Expired_time = now_str
+				expired_ids = append(expired_ids, transl.Transfer_id)
+				events = append(events, transfer_event("transfer_expired", acc, transl))
+				changed = true
+
+				err = del_pending_inbound(stub, accounts, transl)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if changed {
+			err = put_account(stub, acc)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(ExpireResponse{Version: ApiVersion, Guava_id: guava_id, Transfer_ids: expired_ids})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_expired_transfers - list the expired outgoing transfers of every account in a guava <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_expired_transfers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	guava, err := get_guava(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make([]Transfer, 0)
+	account_nums := guava.Accounts
+
+	for i := 0; i < len(account_nums); i++ {
+		acc, err := get_account(stub, strconv.FormatInt(account_nums[i], 10))
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < len(acc.OutgoingTransfer); j++ {
+			if strings.Compare(acc.OutgoingTransfer[j].Status, "expired") == 0 {
+				expired = append(expired, acc.OutgoingTransfer[j])
+			}
+		}
+	}
+
+	expiredAsBytes, _ := json.Marshal(expired)
+	return expiredAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_transfer_ttls - load the time-to-live in seconds of each transfer type in a guava
+// ============================================================================================================================
+
+func get_transfer_ttls(stub shim.ChaincodeStubInterface, guava_id string) (map[string]int64, error) {
+
+	ttls := TransferTTLs{Ttls: make(map[string]int64), Schema: TTLSchema}
+
+	_, err := get_record(stub, &ttls, TTLKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+	upgrade_ttls(&ttls)
+
+	return ttls.Ttls, nil
+}
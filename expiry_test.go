@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpireTransfers(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var ttl TTLResponse
+	l.decode(l.ok("set_transfer_ttl", "1", "alice", "payment", "3600"), &ttl)
+	if ttl.Ttls["payment"] != 3600 {
+		t.Fatalf("unexpected response %+v", ttl)
+	}
+
+	old := l.payment("100")
+	l.now = l.now.Add(30 * time.Minute)
+	recent := l.payment("200")
+	l.ok("create_transfer", "sweep", "1", "50", "50", "1", "2", "internal", "t", "bob", "")
+
+	var resp ExpireResponse
+	l.now = l.now.Add(45 * time.Minute)
+	l.fail_with("User dave does not have the approve permission in guava 1", "expire_transfers", "1", "dave")
+	l.decode(l.ok("expire_transfers", "1", "carol"), &resp)
+	if len(resp.Transfer_ids) != 1 || resp.Transfer_ids[0] != old {
+		t.Fatalf("expired %v, want [%d]", resp.Transfer_ids, old)
+	}
+
+	if transl := l.transfer(1, old); transl.Status != "expired" || transl.Expired_time != "2026-01-05T10:15:00Z" {
+		t.Fatalf("unexpected transfer %+v", transl)
+	}
+	if status := l.transfer(1, recent).Status; status != "pending" {
+		t.Fatalf("recent transfer status = %s, want pending", status)
+	}
+
+	l.fail_with("is not pending", "accept_transfer", "2", "1", strconv.FormatInt(old, 10), "100", "100", "carol")
+	l.balance(1, 950)
+
+	l.now = l.now.Add(15 * time.Minute)
+	l.decode(l.ok("expire_transfers", "1", "carol"), &resp)
+	if len(resp.Transfer_ids) != 1 || resp.Transfer_ids[0] != recent {
+		t.Fatalf("expired %v, want [%d]", resp.Transfer_ids, recent)
+	}
+
+	var expired []Transfer
+	l.decode(l.ok("read_expired_transfers", "1", "dave"), &expired)
+	if len(expired) != 2 {
+		t.Fatalf("read_expired_transfers returned %d transfers, want 2", len(expired))
+	}
+}
+
+func TestExpireTransfersWithoutTTL(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_transfer_ttl", "1", "alice", "payment", "60")
+	l.ok("set_transfer_ttl", "1", "alice", "payment", "0")
+	l.payment("100")
+
+	var resp ExpireResponse
+	l.now = l.now.Add(24 * time.Hour)
+	l.decode(l.ok("expire_transfers", "1", "carol"), &resp)
+	if len(resp.Transfer_ids) != 0 {
+		t.Fatalf("expired %v without a ttl", resp.Transfer_ids)
+	}
+
+	var ttls map[string]int64
+	l.decode(l.ok("read_transfer_ttl", "1", "dave"), &ttls)
+	if len(ttls) != 0 {
+		t.Fatalf("unexpected ttls %v", ttls)
+	}
+}
+
+func TestSetTransferTTLErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("does not have the owner permission", "set_transfer_ttl", "1", "bob", "payment", "60")
+	l.fail_with("whole number of seconds", "set_transfer_ttl", "1", "alice", "payment", "-1")
+	l.fail_with("whole number of seconds", "set_transfer_ttl", "1", "alice", "payment", "1h")
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Approved payments are handed to the bank as ISO 20022 pain.001.001.03 customer credit transfer initiations.
+// export_payments collects the approved payments of a guava that have not been exported yet, marks them with the
+// reference of the export and renders the document, with one payment information block per sending account.
+// read_payment_export renders an earlier export again from the transfers it holds. The debtor of a block is the
+// bank account set on the sending account with set_bank_account, a payment is never exported without one.
+
+const Pain001Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"
+
+type PaymentExport struct {
+	Export_id    int64   `json:"export_id"`    //unique identifier for the export
+	Guava_id     string  `json:"guava_id"`     //guava whose payments were exported
+	Reference    string  `json:"reference"`    //message id of the document, also set on every exported transfer
+	Creator      string  `json:"creator"`      //the username of the user who exported the payments
+	Created      string  `json:"created"`      //transaction time of the export
+	Transfer_ids []int64 `json:"transfer_ids"` //exported payments in document order
+	Schema       int     `json:"schema"`       //layout version the export was written with
+}
+
+type Pain001Document struct {
+	XMLName    xml.Name          `xml:"urn:iso:std:iso:20022:tech:xsd:pain.001.001.03 Document"`
+	Initiation Pain001Initiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type Pain001Initiation struct {
+	GroupHeader Pain001GroupHeader `xml:"GrpHdr"`
+	Payments    []Pain001Payment   `xml:"PmtInf"`
+}
+
+type Pain001GroupHeader struct {
+	MsgId    string       `xml:"MsgId"`
+	CreDtTm  string       `xml:"CreDtTm"`
+	NbOfTxs  int          `xml:"NbOfTxs"`
+	CtrlSum  string       `xml:"CtrlSum"`
+	InitgPty Pain001Party `xml:"InitgPty"`
+}
+
+type Pain001Payment struct {
+	PmtInfId    string                  `xml:"PmtInfId"`
+	PmtMtd      string                  `xml:"PmtMtd"`
+	NbOfTxs     int                     `xml:"NbOfTxs"`
+	CtrlSum     string                  `xml:"CtrlSum"`
+	ReqdExctnDt string                  `xml:"ReqdExctnDt"`
+	Dbtr        Pain001Party            `xml:"Dbtr"`
+	DbtrAcct    Pain001Account          `xml:"DbtrAcct"`
+	DbtrAgt     Pain001Agent            `xml:"DbtrAgt"`
+	Transfers   []Pain001CreditTransfer `xml:"CdtTrfTxInf"`
+}
+
+type Pain001CreditTransfer struct {
+	EndToEndId string             `xml:"PmtId>EndToEndId"`
+	Amount     Pain001Amount      `xml:"Amt>InstdAmt"`
+	CdtrAgt    *Pain001Agent      `xml:"CdtrAgt,omitempty"`
+	Cdtr       Pain001Party       `xml:"Cdtr"`
+	CdtrAcct   Pain001Account     `xml:"CdtrAcct"`
+	RmtInf     *Pain001Remittance `xml:"RmtInf,omitempty"`
+}
+
+type Pain001Amount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type Pain001Party struct {
+	Nm      string          `xml:"Nm"`
+	PstlAdr *Pain001Address `xml:"PstlAdr,omitempty"`
+}
+
+type Pain001Address struct {
+	StrtNm string `xml:"StrtNm,omitempty"`
+	PstCd  string `xml:"PstCd,omitempty"`
+	TwnNm  string `xml:"TwnNm,omitempty"`
+	Ctry   string `xml:"Ctry,omitempty"`
+}
+
+type Pain001Account struct {
+	IBAN  string `xml:"Id>IBAN,omitempty"`
+	Other string `xml:"Id>Othr>Id,omitempty"`
+	Ccy   string `xml:"Ccy,omitempty"`
+}
+
+type Pain001Agent struct {
+	BIC   string `xml:"FinInstnId>BIC,omitempty"`
+	Other string `xml:"FinInstnId>Othr>Id,omitempty"`
+}
+
+type Pain001Remittance struct {
+	Ustrd string `xml:"Ustrd"`
+}
+
+// ============================================================================================================================
+// export_payments - mark the approved payments of a guava that have not been exported or settled and render them as a
+// pain.001 document <guava_id, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) export_payments(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, actor>")
+	}
+
+	guava_id := args[0]
+	actor := args[1]
+
+	guava, err := get_guava(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := account_cache{}
+	selected := make([]Transfer, 0)
+	senders := make([]*Account, 0)
+
+	for i := 0; i < len(guava.Accounts); i++ {
+		acc, err := accounts.get(stub, guava.Accounts[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < len(acc.OutgoingTransfer); j++ {
+			transl := &acc.OutgoingTransfer[j]
+			if !payable(transl) || transl.Export_reference != "" {
+				continue
+			}
+			selected = append(selected, *transl)
+			senders = append(senders, acc)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.New("Guava " + guava_id + " has no approved payments to export")
+	}
+
+	export_id, err := next_ids(stub, "export", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	export := PaymentExport{
+		Export_id:    export_id,
+		Guava_id:     guava_id,
+		Reference:    "GUAVA-" + guava_id + "-" + strconv.FormatInt(export_id, 10),
+		Creator:      actor,
+		Created:      created,
+		Transfer_ids: make([]int64, 0, len(selected)),
+		Schema:       ExportSchema}
+	events := make([]GuavaEvent, 0, len(selected))
+
+	// payments are only held by the sending account, they never reach a receiving account on the ledger
+	for i := 0; i < len(selected); i++ {
+		sending_acc := senders[i]
+		transl := find_transfer(sending_acc.OutgoingTransfer, selected[i].Transfer_id)
+		transl.Export_reference = export.Reference
+		transl.Exported_time = created
+		selected[i] = *transl
+
+		export.Transfer_ids = append(export.Transfer_ids, transl.Transfer_id)
+		events = append(events, transfer_event("transfer_exported", sending_acc, transl))
+	}
+
+	document, err := render_pain001(&export, selected, senders)
+	if err != nil {
+		return nil, err
+	}
+
+	err = accounts.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_record(stub, &export, ExportKey, strconv.FormatInt(export_id, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(ExportResponse{Version: ApiVersion, Export: &export, Document: string(document)})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_payment_export - render an earlier payment export of a guava again <guava_id, export_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_payment_export(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, export_id, caller>")
+	}
+
+	guava_id := args[0]
+	export_id := args[1]
+
+	export := PaymentExport{}
+	found, err := get_record(stub, &export, ExportKey, export_id)
+	if err != nil {
+		return nil, err
+	}
+	if !found || strings.Compare(export.Guava_id, guava_id) != 0 {
+		return nil, errors.New("Could not find payment export " + export_id + " in guava " + guava_id)
+	}
+	upgrade_export(&export)
+
+	transfers := make([]Transfer, 0, len(export.Transfer_ids))
+	senders := make([]*Account, 0, len(export.Transfer_ids))
+	for i := 0; i < len(export.Transfer_ids); i++ {
+		transl, sending_acc, err := get_transfer(stub, strconv.FormatInt(export.Transfer_ids[i], 10))
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, *transl)
+		senders = append(senders, sending_acc)
+	}
+
+	return render_pain001(&export, transfers, senders)
+}
+
+// ============================================================================================================================
+// set_bank_account - set the bank account the payments of an account are made from, owners only
+// <account_id, bank_json, owner>, bank_json is {"iban", "account_number", "bic"} and an account_number needs the bic
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_bank_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <account_id, bank_json, owner>")
+	}
+
+	bank := BankAccount{}
+	err := json.Unmarshal([]byte(args[1]), &bank)
+	if err != nil {
+		return nil, errors.New("Could not parse bank_json: " + err.Error())
+	}
+
+	err = check_bank_account(&bank)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	acc.Bank = &bank
+	err = put_account(stub, acc)
+	if err != nil {
+		return nil, err
+	}
+
+	return account_response(acc.Guava_id, acc)
+}
+
+// ============================================================================================================================
+// render_pain001 - render the payments of an export, senders[i] is the account that sent transfers[i]
+// ============================================================================================================================
+
+func render_pain001(export *PaymentExport, transfers []Transfer, senders []*Account) ([]byte, error) {
+
+	now, err := time.Parse(time.RFC3339, export.Created)
+	if err != nil {
+		return nil, errors.New("Payment export " + export.Reference + " has an invalid creation time " + export.Created)
+	}
+
+	doc := Pain001Document{}
+	doc.Initiation.GroupHeader = Pain001GroupHeader{
+		MsgId:    export.Reference,
+		CreDtTm:  now.Format("2006-01-02T15:04:05"),
+		NbOfTxs:  len(transfers),
+		InitgPty: Pain001Party{Nm: "Guava " + export.Guava_id}}
+
+	total := 0.0
+	sums := make(map[int64]float64)
+	blocks := make(map[int64]*Pain001Payment)
+	order := make([]int64, 0)
+
+	for i := 0; i < len(transfers); i++ {
+		transl := &transfers[i]
+		acc := senders[i]
+
+		block, ok := blocks[acc.AccountID]
+		if !ok {
+			if acc.Bank == nil {
+				return nil, errors.New("Account " + strconv.FormatInt(acc.AccountID, 10) + " has no bank account to pay transfer " +
+					strconv.FormatInt(transl.Transfer_id, 10) + " from, set one with set_bank_account")
+			}
+			block = &Pain001Payment{
+				PmtInfId:    export.Reference + "-" + strconv.FormatInt(acc.AccountID, 10),
+				PmtMtd:      "TRF",
+				ReqdExctnDt: now.Format("2006-01-02"),
+				Dbtr:        Pain001Party{Nm: truncate_text(acc.AccountName, MaxBeneficiaryName)},
+				DbtrAcct:    Pain001Account{IBAN: acc.Bank.Iban, Ccy: acc.Currency},
+				DbtrAgt:     Pain001Agent{BIC: acc.Bank.Bic}}
+			if acc.Bank.Iban == "" {
+				block.DbtrAcct.Other = acc.Bank.Account_number
+			}
+			if acc.Bank.Bic == "" {
+				block.DbtrAgt.Other = "NOTPROVIDED"
+			}
+			blocks[acc.AccountID] = block
+			order = append(order, acc.AccountID)
+		}
+
+		amount := round_amount(payment_amount(transl))
+		block.Transfers = append(block.Transfers, credit_transfer(transl, acc))
+		block.NbOfTxs = block.NbOfTxs + 1
+		sums[acc.AccountID] = sums[acc.AccountID] + amount
+		total = total + amount
+	}
+
+	// control sums add up the amounts as they appear in the document
+	for i := 0; i < len(order); i++ {
+		block := blocks[order[i]]
+		block.CtrlSum = format_amount(sums[order[i]])
+		doc.Initiation.Payments = append(doc.Initiation.Payments, *block)
+	}
+	doc.Initiation.GroupHeader.CtrlSum = format_amount(total)
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, errors.New("Could not render payment export " + export.Reference + ": " + err.Error())
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
+
+func credit_transfer(transl *Transfer, acc *Account) Pain001CreditTransfer {
+
+	tx := Pain001CreditTransfer{
+		EndToEndId: strconv.FormatInt(transl.Transfer_id, 10),
+		Amount:     Pain001Amount{Currency: acc.Currency, Value: format_amount(payment_amount(transl))}}
+
+	beneficiary := transl.Beneficiary
+	if beneficiary == nil {
+		beneficiary = &Beneficiary{}
+	}
+
+	if beneficiary.Bic != "" {
+		tx.CdtrAgt = &Pain001Agent{BIC: beneficiary.Bic}
+	}
+
+	tx.Cdtr = Pain001Party{Nm: beneficiary.Name}
+	if beneficiary.Address != nil {
+		tx.Cdtr.PstlAdr = &Pain001Address{
+			StrtNm: beneficiary.Address.Street,
+			PstCd:  beneficiary.Address.Postcode,
+			TwnNm:  beneficiary.Address.Town,
+			Ctry:   beneficiary.Address.Country}
+	}
+
+	if beneficiary.Iban != "" {
+		tx.CdtrAcct = Pain001Account{IBAN: beneficiary.Iban}
+	} else {
+		tx.CdtrAcct = Pain001Account{Other: beneficiary.Account_number}
+	}
+
+	remittance := beneficiary.Remittance_info
+	if remittance == "" {
+		remittance = transl.Message
+	}
+	remittance = truncate_text(remittance, MaxRemittanceInfo)
+	if remittance != "" {
+		tx.RmtInf = &Pain001Remittance{Ustrd: remittance}
+	}
+
+	return tx
+}
+
+// ============================================================================================================================
+// payable - whether a payment is approved and still to be paid, a partial reversal leaves the rest of it to pay
+// ============================================================================================================================
+
+func payable(transl *Transfer) bool {
+
+	if strings.Compare(transl.T_Type, PaymentTransfer) != 0 || transl.Settled_time != "" {
+		return false
+	}
+
+	return strings.Compare(transl.Status, "approved") == 0 || strings.Compare(transl.Status, "partially_reversed") == 0
+}
+
+// payment_amount - the dec_value of a payment less the share its reversals refunded to the sending account
+func payment_amount(transl *Transfer) float64 {
+
+	return transl.Dec_value - transl.Reversed_value*transl.Dec_value/transl.Inc_value
+}
+
+// truncate_text - cut text to at most limit characters
+func truncate_text(text string, limit int) string {
+
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+
+	return string([]rune(text)[:limit])
+}
+
+func round_amount(amount float64) float64 {
+
+	rounded, _ := strconv.ParseFloat(format_amount(amount), 64)
+	return rounded
+}
+
+func format_amount(amount float64) string {
+
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
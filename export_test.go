@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestExportPayments(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("Guava 1 has no approved payments to export", "export_payments", "1", "alice")
+
+	first := l.payment("100")
+	second := l.payment("50.5")
+	pending := l.payment("10")
+	l.ok("accept_transfer", "2", "1", format_int(first), "100", "100", "carol")
+	l.ok("accept_transfer", "2", "1", format_int(second), "50.5", "50.5", "carol")
+	l.internal("5", "bob")
+
+	var third TransferResponse
+	l.decode(l.ok("create_transfer", "rent & fees", "1", "20", "20", "2", "1", "payment", "t", "bob",
+		`{"name":"Landlord <Ltd>", "account_number":"555", "bic":"ROYCCAT2", "address":{"street":"1 Bay St", "town":"Toronto", "country":"CA"}}`), &third)
+	l.ok("accept_transfer", "1", "2", format_int(third.Transfer_id), "20", "20", "carol")
+
+	l.fail_with("User bob does not have the owner permission in guava 1", "export_payments", "1", "bob")
+	l.ok("set_bank_account", "1", `{"iban":"DE89 3704 0044 0532 0130 00", "bic":"cobadeffxxx"}`, "alice")
+	l.ok("set_bank_account", "2", `{"account_number":"00123", "bic":"ROYCCAT2"}`, "alice")
+
+	var resp ExportResponse
+	l.decode(l.ok("export_payments", "1", "alice"), &resp)
+	if resp.Export == nil || resp.Export.Reference != "GUAVA-1-1" || resp.Export.Schema != ExportSchema ||
+		len(resp.Export.Transfer_ids) != 3 || resp.Export.Transfer_ids[0] != first || resp.Export.Transfer_ids[2] != third.Transfer_id {
+		t.Fatalf("unexpected export %+v", resp.Export)
+	}
+	if !strings.HasPrefix(resp.Document, `<?xml version="1.0" encoding="UTF-8"?>`) ||
+		!strings.Contains(resp.Document, `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03">`) {
+		t.Fatalf("the document is not a pain.001 document:\n%s", resp.Document)
+	}
+
+	var doc Pain001Document
+	err := xml.Unmarshal([]byte(resp.Document), &doc)
+	if err != nil {
+		t.Fatalf("could not parse the document: %v", err)
+	}
+
+	header := doc.Initiation.GroupHeader
+	if header.MsgId != "GUAVA-1-1" || header.NbOfTxs != 3 || header.CtrlSum != "170.50" || header.CreDtTm != "2026-01-05T09:00:00" {
+		t.Fatalf("unexpected group header %+v", header)
+	}
+
+	// one block per sending account
+	if len(doc.Initiation.Payments) != 2 {
+		t.Fatalf("expected a payment block for each of the two sending accounts, got %d", len(doc.Initiation.Payments))
+	}
+	ops := doc.Initiation.Payments[0]
+	if ops.PmtInfId != "GUAVA-1-1-1" || ops.NbOfTxs != 2 || ops.CtrlSum != "150.50" || ops.ReqdExctnDt != "2026-01-05" ||
+		ops.Dbtr.Nm != "ops" || ops.DbtrAcct.IBAN != "DE89370400440532013000" || ops.DbtrAcct.Other != "" || ops.DbtrAcct.Ccy != "CAD" ||
+		ops.DbtrAgt.BIC != "COBADEFFXXX" {
+		t.Fatalf("unexpected payment block %+v", ops)
+	}
+	tx := ops.Transfers[1]
+	if tx.EndToEndId != format_int(second) || tx.Amount.Value != "50.50" || tx.Amount.Currency != "CAD" ||
+		tx.CdtrAcct.IBAN != "GB82WEST12345698765432" || tx.CdtrAgt.BIC != "NWBKGB2L" || tx.Cdtr.Nm != "Acme Supplies" || tx.RmtInf.Ustrd != "invoice" {
+		t.Fatalf("unexpected credit transfer %+v", tx)
+	}
+
+	// names and messages are escaped, account numbers go in Othr
+	savings := doc.Initiation.Payments[1]
+	if savings.DbtrAcct.Other != "00123" || savings.DbtrAcct.IBAN != "" || savings.DbtrAgt.BIC != "ROYCCAT2" {
+		t.Fatalf("unexpected payment block %+v", savings)
+	}
+	tx = savings.Transfers[0]
+	if tx.Cdtr.Nm != "Landlord <Ltd>" || tx.CdtrAcct.Other != "555" || tx.CdtrAcct.IBAN != "" || tx.Cdtr.PstlAdr.TwnNm != "Toronto" || tx.RmtInf.Ustrd != "rent & fees" {
+		t.Fatalf("unexpected credit transfer %+v", tx)
+	}
+	if !strings.Contains(resp.Document, "Landlord &lt;Ltd&gt;") {
+		t.Fatalf("the creditor name was not escaped:\n%s", resp.Document)
+	}
+
+	// exported payments are marked, pending payments are left for a later export
+	if got := l.transfer(1, first); got.Export_reference != "GUAVA-1-1" || got.Exported_time != "2026-01-05T09:00:00Z" {
+		t.Fatalf("the exported payment was not marked %+v", got)
+	}
+	if got := l.transfer(1, pending); got.Export_reference != "" {
+		t.Fatalf("a pending payment was exported %+v", got)
+	}
+	l.fail_with("Transfer "+format_int(first)+" was exported in GUAVA-1-1 and can not be reversed", "reverse_transfer", "1", format_int(first), "10", "carol", "refund")
+	l.fail_with("Guava 1 has no approved payments to export", "export_payments", "1", "alice")
+
+	l.ok("accept_transfer", "2", "1", format_int(pending), "10", "10", "carol")
+	l.decode(l.ok("export_payments", "1", "alice"), &resp)
+	if resp.Export.Reference != "GUAVA-1-2" || len(resp.Export.Transfer_ids) != 1 || resp.Export.Transfer_ids[0] != pending {
+		t.Fatalf("unexpected second export %+v", resp.Export)
+	}
+}
+
+func TestReadPaymentExport(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	id := l.payment("100")
+	l.ok("accept_transfer", "2", "1", format_int(id), "100", "100", "carol")
+
+	var resp ExportResponse
+	l.ok("set_bank_account", "1", `{"iban":"DE89370400440532013000"}`, "alice")
+	l.decode(l.ok("export_payments", "1", "alice"), &resp)
+
+	document := string(l.ok("read_payment_export", "1", "1", "dave"))
+	if document != resp.Document {
+		t.Fatalf("the export rendered differently when read again:\n%s\n%s", document, resp.Document)
+	}
+
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+	l.ok("create_user", "frank", "false", "false", "false", "true", "2", identity("frank"), "root")
+	l.fail_with("User frank does not have the read permission in guava 1", "read_payment_export", "1", "1", "frank")
+	l.fail_with("Could not find payment export 1 in guava 2", "read_payment_export", "2", "1", "frank")
+	l.fail_with("Could not find payment export 7 in guava 1", "read_payment_export", "1", "7", "dave")
+}
+
+func TestSetBankAccount(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp AccountResponse
+	l.decode(l.ok("set_bank_account", "1", `{"iban":"de89 3704 0044 0532 0130 00"}`, "alice"), &resp)
+	if resp.Account.Bank == nil || resp.Account.Bank.Iban != "DE89370400440532013000" || l.account(1).Bank.Iban != "DE89370400440532013000" {
+		t.Fatalf("unexpected account %+v", resp.Account)
+	}
+
+	l.fail_with("User bob does not have the owner permission in guava 1", "set_bank_account", "1", `{"iban":"DE89370400440532013000"}`, "bob")
+	l.fail_with("Invalid bank account iban DE88370400440532013000", "set_bank_account", "1", `{"iban":"DE88370400440532013000"}`, "alice")
+	l.fail_with("Bank account account_number needs the bic of its bank", "set_bank_account", "1", `{"account_number":"00123"}`, "alice")
+	l.fail_with("Bank account needs an iban or an account_number", "set_bank_account", "1", `{}`, "alice")
+	l.fail_with("Could not find the guava of account 9", "set_bank_account", "9", `{"iban":"DE89370400440532013000"}`, "alice")
+}
+
+func TestExportPaymentDetails(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	// the debtor is the bank account of the sending account, the ledger account id means nothing to the bank
+	id := l.payment("100")
+	l.ok("accept_transfer", "2", "1", format_int(id), "100", "100", "carol")
+	l.fail_with("Account 1 has no bank account to pay transfer "+format_int(id)+" from, set one with set_bank_account", "export_payments", "1", "alice")
+	if got := l.transfer(1, id); got.Export_reference != "" {
+		t.Fatalf("a failed export marked the payment %+v", got)
+	}
+
+	// a partly reversed payment still pays what was not refunded
+	l.ok("reverse_transfer", "1", format_int(id), "40", "carol", "short delivery")
+	if status := l.transfer(1, id).Status; status != "partially_reversed" {
+		t.Fatalf("transfer status = %s, want partially_reversed", status)
+	}
+
+	// names and remittance are cut to their limits in characters, not bytes
+	l.ok("create_account", strings.Repeat("é", 80), "1", "CAD", "CA", "OPR", "100", "alice")
+	var named TransferResponse
+	l.decode(l.ok("create_transfer", strings.Repeat("ü", 150), "1", "10", "10", "3", "2", "payment", "t", "bob",
+		`{"name":"Acme Supplies", "iban":"GB82WEST12345698765432"}`), &named)
+	l.ok("accept_transfer", "2", "3", format_int(named.Transfer_id), "10", "10", "carol")
+
+	l.ok("set_bank_account", "1", `{"iban":"DE89370400440532013000"}`, "alice")
+	l.ok("set_bank_account", "3", `{"iban":"GB82WEST12345698765432"}`, "alice")
+	var resp ExportResponse
+	l.decode(l.ok("export_payments", "1", "alice"), &resp)
+
+	var doc Pain001Document
+	err := xml.Unmarshal([]byte(resp.Document), &doc)
+	if err != nil {
+		t.Fatalf("could not parse the document: %v", err)
+	}
+	if len(doc.Initiation.Payments) != 2 || doc.Initiation.GroupHeader.CtrlSum != "70.00" {
+		t.Fatalf("unexpected document %+v", doc.Initiation)
+	}
+	if ops := doc.Initiation.Payments[0]; ops.Transfers[0].Amount.Value != "60.00" || ops.CtrlSum != "60.00" {
+		t.Fatalf("unexpected payment block %+v", ops)
+	}
+	named_block := doc.Initiation.Payments[1]
+	if named_block.Dbtr.Nm != strings.Repeat("é", MaxBeneficiaryName) {
+		t.Fatalf("debtor name was not cut to %d characters: %q", MaxBeneficiaryName, named_block.Dbtr.Nm)
+	}
+	if ustrd := named_block.Transfers[0].RmtInf.Ustrd; ustrd != strings.Repeat("ü", MaxRemittanceInfo) {
+		t.Fatalf("remittance was not cut to %d characters: %q", MaxRemittanceInfo, ustrd)
+	}
+
+	// the bank settles the rest of the partly reversed payment
+	var imported StatementResponse
+	l.decode(l.ok("import_statement", "1", "camt053", camt053("CAMT-1", `
+      <Ntry>
+        <Amt Ccy="CAD">60.00</Amt><CdtDbtInd>DBIT</CdtDbtInd>
+        <NtryDtls><TxDtls><Refs><EndToEndId>`+format_int(id)+`</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>`), "alice"), &imported)
+	if len(imported.Import.Matched) != 1 || l.transfer(1, id).Status != "settled" {
+		t.Fatalf("unexpected import %+v", imported.Import)
+	}
+}
@@ -6,61 +6,76 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
 
 // SimpleChaincode example simple Chaincode implementation
 type GuavaChaincode struct {
 }
 
-var GuavaMapkey = "_guavamapkey"
-var UserMapkey = "_usermapkey"
-
-var accountcount int64 = 1
-var transcount int64 = 1
-
-var guavacount int64 = 1
-
 type User struct {
-	Username string `json:"username"`
-	Owner    bool   `json:"owner"`
-	Create   bool   `json:"create"`
-	Approve  bool   `json:"approve"`
-	Read     bool   `json:"read"`
+	Username string      `json:"username"`
+	Owner    bool        `json:"owner"`
+	Create   bool        `json:"create"`
+	Approve  bool        `json:"approve"`
+	Read     bool        `json:"read"`
+	Disabled bool        `json:"disabled"` //a disabled user keeps its rights but can not use them
+	Identity string      `json:"identity"` //client identity the user acts with (identity.go), empty until one is set
+	Roles    []RoleGrant `json:"roles"`    //roles assigned on top of the built-in roles the flags stand for
+	Schema   int         `json:"schema"`   //layout version the user was written with
 }
 
 type Transfer struct {
-	From        int64   `json:"from"`        //account number who generated transfer
-	To          int64   `json:"to"`          //account number receiving transfer
-	Dec_value   float64 `json:"dec_value"`   //amount to decrease in from account
-	Inc_value   float64 `json:"inc_value"`   //amount to increase in to account
-	Fx_rate     float64 `json:"fx_rate"`     //fx_rate for the transfer
-	Message     string  `json:"message"`     //description of desired transfer
-	Status      string  `json:"status"`      //current status of transfer <accept,reject,pending>
-	T_Type      string  `json:"type"`        //type of fund transfer <internal,external>
-	Creator     string  `json:"creator"`     //the username of the user who created the transactions
-	Approver    string  `json:"approver"`    //the username of the user who approved the payment
-	Time        string  `json:"time"`        // time the transfer was created
-	Transfer_id int64   `json:"transfer_id"` //unique identifier for transfer
+	From             int64        `json:"from"`             //account number who generated transfer
+	To               int64        `json:"to"`               //account number receiving transfer
+	Dec_value        float64      `json:"dec_value"`        //amount to decrease in from account
+	Inc_value        float64      `json:"inc_value"`        //amount to increase in to account
+	Fx_rate          float64      `json:"fx_rate"`          //fx_rate for the transfer
+	Message          string       `json:"message"`          //description of desired transfer
+	Status           string       `json:"status"`           //current status of transfer <pending,approved,settled,rejected,cancelled,expired,partially_reversed,reversed>
+	T_Type           string       `json:"type"`             //type of fund transfer <internal,payment,reversal>
+	Beneficiary      *Beneficiary `json:"beneficiary"`      //who a payment is made to, nil for other types
+	Creator          string       `json:"creator"`          //the username of the user who created the transactions
+	Approver         string       `json:"approver"`         //the username of the user who approved the payment
+	Time             string       `json:"time"`             // time the transfer was created
+	Transfer_id      int64        `json:"transfer_id"`      //unique identifier for transfer
+	Batch_id         int64        `json:"batch_id"`         //batch the transfer was submitted in, 0 if submitted alone
+	Approvals        []Approval   `json:"approvals"`        //approvals collected so far under the guava approval policy
+	Cancelled_by     string       `json:"cancelled_by"`     //the username of the user who cancelled the transfer
+	Cancel_reason    string       `json:"cancel_reason"`    //why the transfer was cancelled
+	Cancel_time      string       `json:"cancel_time"`      //transaction time of the cancellation
+	Created          string       `json:"created"`          //transaction time the transfer was created, used for expiry
+	Expired_time     string       `json:"expired_time"`     //transaction time the transfer expired
+	Reversal_of      int64        `json:"reversal_of"`      //transfer this one reverses, 0 if it is not a reversal
+	Reversals        []int64      `json:"reversals"`        //reversal transfers created against this one
+	Reversed_value   float64      `json:"reversed_value"`   //total inc_value returned by reversals so far
+	Elevated         bool         `json:"elevated"`         //the counterparty is not whitelisted, an elevated approval is needed
+	Export_reference string       `json:"export_reference"` //reference of the payment export the transfer was sent to the bank in
+	Exported_time    string       `json:"exported_time"`    //transaction time of the export
+	Settled_time     string       `json:"settled_time"`     //transaction time a bank statement reported the payment
+	Statement_id     string       `json:"statement_id"`     //bank statement the payment was settled by
+	Schema           int          `json:"schema"`           //layout version the transfer was written with
 }
 
 // Transfers = make(map[String]Account[])
 
 type Account struct {
-	AccountName      string     `json:"name"`              // the name of the account
-	AccountID        int64      `json:"id"`                //unique accountid
-	Currency         string     `json:"currency"`          //currency representing the
-	Country          string     `json:"country"`           //operational or savings acco
-	Balance          float64    `json:"balance"`           //current account balance
-	Type             string     `json:"type"`              //operational or savings acco
-	IncomingTransfer []Transfer `json:"incoming_transfer"` //array of incoming transfers
-	OutgoingTransfer []Transfer `json:"outgoing_transfer"` //array of outgoing transactions
+	AccountName      string       `json:"name"`              // the name of the account
+	AccountID        int64        `json:"id"`                //unique accountid
+	Guava_id         string       `json:"guava_id"`          //guava the account was created in
+	Currency         string       `json:"currency"`          //currency representing the
+	Country          string       `json:"country"`           //operational or savings acco
+	Balance          float64      `json:"balance"`           //current account balance
+	Type             string       `json:"type"`              //operational or savings acco
+	IncomingTransfer []Transfer   `json:"incoming_transfer"` //array of incoming transfers
+	OutgoingTransfer []Transfer   `json:"outgoing_transfer"` //array of outgoing transactions
+	Bank             *BankAccount `json:"bank"`              //bank account its payments are made from, nil until set
+	Schema           int          `json:"schema"`            //layout version the account was written with
 }
 
-var GuavaMap = make(map[string][]int64)
-var UserMap = make(map[string][]User)
-
 // ============================================================================================================================
 // Main
 // ============================================================================================================================
@@ -72,150 +87,127 @@ func main() {
 }
 
 // ============================================================================================================================
-// Init - reset all the things
+// Init - called when the chaincode is instantiated or upgraded <init, value>
 // ============================================================================================================================
-func (t *GuavaChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	//var Aval int
-	//	var err error
+func (t *GuavaChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
-	}
-
-	//this is a test entry into the worldstate
-	err := stub.PutState("hello", []byte(args[0]))
+	_, err := t.init_state(stub, args)
 	if err != nil {
-		return nil, err
+		return shim.Error(err.Error())
 	}
 
-	return nil, nil
-}
-
-// ============================================================================================================================
-// Run - Our entry point for Invocations - [LEGACY] obc-peer 4/25/2016
-// ============================================================================================================================
-func (t *GuavaChaincode) Run(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("run is running " + function)
-	return t.Invoke(stub, function, args)
+	return shim.Success(nil)
 }
 
 // ============================================================================================================================
-// Invoke - Our entry point for Invocations
+// Invoke - Our entry point for Invocations and Queries
 // ============================================================================================================================
-func (t *GuavaChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	if function == "init" { //initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	} else if function == "create_account" { //create a new account
-
-		return t.create_account(stub, args)
-	} else if function == "create_transfer" { //create a new transfer
-
-		return t.create_transfer(stub, args)
-	} else if function == "increment_value" { //increment balance for an account
+func (t *GuavaChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
 
-		return t.increment_value(stub, args)
-	} else if function == "decrement_value" { //decrement balance for an account
-
-		return t.decrement_value(stub, args)
-	} else if function == "accept_transfer" { //accept a pending open transfer
-
-		return t.accept_transfer(stub, args)
-	} else if function == "reject_transfer" { //reject a pending open transfer
-
-		return t.reject_transfer(stub, args)
-	} else if function == "create_user" {
-
-		return t.create_user(stub, args)
+	result, err := t.route(stub, function, args)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	fmt.Println("invoke did not find func: " + function) //error invoke function not found
-
-	return nil, errors.New("Received unknown function invocation")
+	return shim.Success(result)
 }
 
 // ============================================================================================================================
-// Query - Our entry point for Queries
+// init_state - reset all the things, the caller becomes the chaincode admin under the name admin <value, admin>
 // ============================================================================================================================
-func (t *GuavaChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
+func (t *GuavaChaincode) init_state(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//var Aval int
+	//	var err error
 
-	// Handle different functions
-	if function == "read" { //read a variable
-		return t.read(stub, args)
-	} else if function == "read_guava" {
-		return t.read_guava(stub, args)
+	args, err := decode_request("init", func() request { return &InitRequest{} }, args)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("query did not find func: " + function) //error
 
-	return nil, errors.New("Received unknown function query")
-}
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <value, admin>")
+	}
+	if args[1] == "" {
+		return nil, errors.New("The chaincode admin needs a name")
+	}
 
-// ============================================================================================================================
-// Read - read a account_num
-// ============================================================================================================================
-func (t *GuavaChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var account_num, jsonResp string
-	var err error
+	// ids handed out before migrate moves the legacy records must not be ones they hold
+	err = raise_legacy_counters(stub)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	//this is a test entry into the worldstate
+	key, err := make_key(stub, ConfigKey, "hello")
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, []byte(args[0]))
+	if err != nil {
+		return nil, err
 	}
 
-	account_num = args[0]
-	valAsbytes, err := stub.GetState(account_num) //get the var from chaincode state
+	admin := ChaincodeAdmin{Username: args[1]}
+	admin.Identity, err = caller_identity(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = put_record(stub, &admin, ConfigKey, "admin")
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + account_num + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
 	}
 
-	return valAsbytes, nil //send it onward
+	return nil, nil
 }
 
 // ============================================================================================================================
-// Read_guava - read a account_num
+// Read_guava - read every account of a guava <guava_id, caller>, transfers from other guavas are redacted
 // ============================================================================================================================
 func (t *GuavaChaincode) read_guava(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var guava_id, jsonResp string
-	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting quava_id to query")
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
 	}
 
 	guava_id = args[0]
 
-	account_nums := GuavaMap[guava_id]
+	// a guava that does not exist has no accounts
+	guava := Guava{Accounts: make([]int64, 0)}
+	_, err := get_record(stub, &guava, GuavaKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	account_nums := guava.Accounts
 	account_slice := make([]Account, 0)
 
 	for i := 0; i < len(account_nums); i++ {
 
 		acc_id_str := strconv.FormatInt(account_nums[i], 10)
-		valAsbytes, err := stub.GetState(acc_id_str) //get the var from chaincode state
+		acc, err := get_account(stub, acc_id_str)
 		if err != nil {
 			jsonResp = "{\"Error\":\"Failed to get account for " + acc_id_str + "\"}"
 			return nil, errors.New(jsonResp)
 		}
-		acc := Account{}
-		json.Unmarshal(valAsbytes, &acc)
-		account_slice = append(account_slice, acc)
+		err = redact_incoming(stub, acc)
+		if err != nil {
+			return nil, err
+		}
+		account_slice = append(account_slice, *acc)
 
 	}
 
 	sliceAsBytes, _ := json.Marshal(account_slice)
 
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + guava_id + "\"}"
-		return nil, errors.New(jsonResp)
-	}
-
 	return sliceAsBytes, nil //send it onward
 }
 
 // ============================================================================================================================
-// create_account - create new account expected arguments <account_name, guava_id, currency, country, acctype>
+// create_account - create new account expected arguments <account_name, guava_id, currency, country, acctype, initial_balance, actor>
+// owners add accounts to their guava, a new guava (guava_id -1) is created by the chaincode admin
 // ============================================================================================================================
 func (t *GuavaChaincode) create_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var account_name, currency, country, acctype, guava_id string // Entities
@@ -224,8 +216,8 @@ func (t *GuavaChaincode) create_account(stub shim.ChaincodeStubInterface, args [
 	var initialbalance float64
 	var err error
 
-	if len(args) != 6 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 5 arguments <account_name, guava_id, currency, country, acctype>")
+	if len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 7 arguments <account_name, guava_id, currency, country, acctype, initial_balance, actor>")
 	}
 
 	account_name = args[0]
@@ -236,14 +228,29 @@ func (t *GuavaChaincode) create_account(stub shim.ChaincodeStubInterface, args [
 	acctype = args[4]
 
 	initialbalance, err = strconv.ParseFloat(args[5], 64)
+	if err != nil {
+		return nil, errors.New("Invalid initial balance " + args[5])
+	}
 
-	account_number = accountcount
-	accountcount = accountcount + 1
-
+	var guava *Guava
 	if strings.Compare(guava_id, "-1") == 0 {
 
-		guava_id = strconv.FormatInt(guavacount, 10)
-		guavacount = guavacount + 1
+		guava_number, err := next_ids(stub, "guava", 1)
+		if err != nil {
+			return nil, err
+		}
+		guava_id = strconv.FormatInt(guava_number, 10)
+		guava = &Guava{Guava_id: guava_id, Accounts: make([]int64, 0), Schema: GuavaSchema}
+	} else {
+		guava, err = get_guava(stub, guava_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	account_number, err = next_ids(stub, "account", 1)
+	if err != nil {
+		return nil, err
 	}
 
 	incoming_t := make([]Transfer, 0)
@@ -252,44 +259,45 @@ func (t *GuavaChaincode) create_account(stub shim.ChaincodeStubInterface, args [
 	new_Account := &Account{
 		AccountName:      account_name,
 		AccountID:        account_number,
+		Guava_id:         guava_id,
 		Currency:         currency,
 		Country:          country,
 		Balance:          initialbalance,
 		Type:             acctype,
 		IncomingTransfer: incoming_t,
-		OutgoingTransfer: outgoing_t}
-
-	new_Account_m, _ := json.Marshal(new_Account)
+		OutgoingTransfer: outgoing_t,
+		Schema:           AccountSchema}
 
-	new_Account_string := string(new_Account_m)
-
-	err = stub.PutState(strconv.FormatInt(account_number, 10), []byte(new_Account_string)) //store the account
+	err = put_account(stub, new_Account) //store the account
 	if err != nil {
 		return nil, err
 	}
 
-	//add the account number to the OwnerAccountMap
-	GuavaMap[guava_id] = append(GuavaMap[guava_id], account_number)
+	//add the account number to its guava
+	guava.Accounts = append(guava.Accounts, account_number)
 
-	//var accounts AccountStruct
-	jsonAsBytes, _ := json.Marshal(GuavaMap)
-	ownwermap_string := string(jsonAsBytes)
+	err = put_guava(stub, guava)
+	if err != nil {
+		return nil, err
+	}
 
-	err = stub.PutState(GuavaMapkey, []byte(ownwermap_string))
+	err = emit_events(stub, []GuavaEvent{account_event("account_created", new_Account, initialbalance)})
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return account_response(guava_id, new_Account)
 }
 
 // ============================================================================================================================
-// create_transfer - create new account expected arguments <message, fx_rate, value_inc, value_dec, from_id, to_id, tans_type(internal, payment), time, creator>
+// create_transfer - create new account expected arguments <message, fx_rate, value_inc, value_dec, from_id, to_id, tans_type(internal, payment), time, creator, beneficiary_json>
+// beneficiary_json is {"name", "iban", "account_number", "bic", "address", "remittance_info"}, payments need one and internal
+// transfers leave it empty. A payment only debits the sender, its to_id is 0 or the account of the counterparty if it has one
 // ============================================================================================================================
 
 func (t *GuavaChaincode) create_transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
-	var trans_type, message, status, time, creator, approver string // Entities
+	var trans_type, message, time, creator, beneficiary_json string // Entities
 	var from_id, to_id string
 	var fx_rate string
 	var value_inc, value_dec string
@@ -297,13 +305,10 @@ func (t *GuavaChaincode) create_transfer(stub shim.ChaincodeStubInterface, args
 
 	var from_id_int, to_id_int int64
 
-	if len(args) != 9 {
+	if len(args) != 10 {
 		return nil, errors.New("Incorrect number of arguments.")
 	}
 
-	var trans_id = transcount
-	transcount = transcount + 1
-
 	message = args[0]
 	fx_rate = args[1]
 	value_inc = args[2]
@@ -313,23 +318,46 @@ func (t *GuavaChaincode) create_transfer(stub shim.ChaincodeStubInterface, args
 	trans_type = args[6]
 	time = args[7]
 	creator = args[8]
-
-	if strings.Compare(trans_type, "internal") == 0 {
-		approver = args[8]
-		status = "approved"
-	} else {
-		approver = "pending"
-	}
+	beneficiary_json = args[9]
 
 	dec_float, err := strconv.ParseFloat(value_dec, 64)
+	if err != nil {
+		return nil, errors.New("Invalid value_dec " + value_dec)
+	}
 	inc_float, err := strconv.ParseFloat(value_inc, 64)
+	if err != nil {
+		return nil, errors.New("Invalid value_inc " + value_inc)
+	}
 	fx_rate_float, err := strconv.ParseFloat(fx_rate, 64)
+	if err != nil {
+		return nil, errors.New("Invalid fx_rate " + fx_rate)
+	}
 
 	from_id_int, err = strconv.ParseInt(from_id, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid from_id " + from_id)
+	}
 	to_id_int, err = strconv.ParseInt(to_id, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid to_id " + to_id)
+	}
+
+	if dec_float <= 0 || inc_float <= 0 {
+		return nil, errors.New("Transfer values must be positive")
+	}
+
+	beneficiary, err := parse_beneficiary(beneficiary_json)
+	if err != nil {
+		return nil, err
+	}
 
 	//create transfer
 
+	trans_id, err := next_ids(stub, "transfer", 1)
+	if err != nil {
+		return nil, err
+	}
+
 	new_transfer := &Transfer{
 		From:        from_id_int,
 		To:          to_id_int,
@@ -337,150 +365,213 @@ func (t *GuavaChaincode) create_transfer(stub shim.ChaincodeStubInterface, args
 		Inc_value:   inc_float,
 		Fx_rate:     fx_rate_float,
 		Message:     message,
-		Status:      status,
+		Status:      "pending",
 		T_Type:      trans_type,
+		Beneficiary: beneficiary,
 		Creator:     creator,
-		Approver:    approver,
+		Approver:    "pending",
 		Time:        time,
-		Transfer_id: trans_id}
+		Transfer_id: trans_id,
+		Schema:      TransferSchema}
+
+	new_transfer.Created, err = tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
 
 	//find the account entry for from_id
-	fromAccountAsBytes, err := stub.GetState(from_id)
+	from_acc, err := get_account(stub, from_id)
 	if err != nil {
 		return nil, errors.New("Could not find the account that is sending funds " + from_id)
 	}
 
-	from_acc := Account{}
-	json.Unmarshal(fromAccountAsBytes, &from_acc)
+	//find account entry for to_id
+	var to_acc *Account
+	if needs_receiver(trans_type, to_id_int) {
+		to_acc, err = get_account(stub, to_id)
+		if err != nil {
+			return nil, errors.New("Could not find this account that is receiving funds " + to_id)
+		}
+		if from_id_int == to_id_int {
+			to_acc = from_acc
+		}
+	}
 
-	//check that account has enough funds, decrement if internal otherwise set status as pending
+	settles, err := check_transfer_type(from_acc, to_acc, new_transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = check_counterparty(stub, from_acc, to_acc, new_transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	// internal transfers settle now and count against the limits, others are checked and counted once approved
+	limits, err := new_limit_checker(stub)
+	if err != nil {
+		return nil, err
+	}
+	if settles {
+		err = limits.settle(stub, from_acc, new_transfer)
+	} else {
+		err = limits.check(stub, from_acc, new_transfer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//check that account has enough funds, decrement if it settles now otherwise leave it pending
 
 	if from_acc.Balance < new_transfer.Dec_value {
 		return nil, errors.New("from account does not have enough funds " + from_id)
-	} else if strings.Compare(new_transfer.T_Type, "internal") == 0 {
-
+	} else if settles {
+		new_transfer.Status = "approved"
+		new_transfer.Approver = creator
 		from_acc.Balance = from_acc.Balance - new_transfer.Dec_value
-	} else {
-		new_transfer.Status = "pending"
 	}
 
 	//add transfer to outgoing transfer
 	from_acc.OutgoingTransfer = append(from_acc.OutgoingTransfer, *new_transfer)
 
-	//find account entry for to_id
-	toAccountAsBytes, err := stub.GetState(to_id)
-	if err != nil {
-		return nil, errors.New("Could not find this account that is receiving funds")
-	}
-	to_acc := Account{}
-	json.Unmarshal(toAccountAsBytes, &to_acc)
-
 	//add transaction to incoming transfer
 
 	//increment this value
-	if strings.Compare(new_transfer.T_Type, "internal") == 0 {
+	if settles {
 		to_acc.Balance = to_acc.Balance + new_transfer.Inc_value
 		to_acc.IncomingTransfer = append(to_acc.IncomingTransfer, *new_transfer)
 
+		err = put_account(stub, to_acc)
+		if err != nil {
+			return nil, err
+		}
 	}
 	//update the account states
 
-	toaccAsBytes, _ := json.Marshal(to_acc)
+	err = put_account(stub, from_acc)
+	if err != nil {
+		return nil, err
+	}
 
-	to_acc_string := string(toaccAsBytes)
-	err = stub.PutState(to_id, []byte(to_acc_string))
+	err = put_transfer_index(stub, new_transfer)
 	if err != nil {
 		return nil, err
 	}
 
-	fromaccAsBytes, _ := json.Marshal(from_acc)
-	from_acc_string := string(fromaccAsBytes)
-	err = stub.PutState(from_id, []byte(from_acc_string))
+	if !settles && to_acc != nil {
+		err = put_pending_inbound(stub, to_acc.Guava_id, new_transfer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = limits.put_all(stub)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	events := []GuavaEvent{transfer_event("transfer_created", from_acc, new_transfer)}
+	if settles {
+		events = append(events, account_event("balance_changed", from_acc, -new_transfer.Dec_value))
+		events = append(events, account_event("balance_changed", to_acc, new_transfer.Inc_value))
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer_response(new_transfer, from_acc, to_acc)
 
 }
 
 // ============================================================================================================================
-// increment_value - increase balance in account <account_id, value>
+// increment_value - increase balance in account <account_id, value, actor>
 // ============================================================================================================================
 
 func (t *GuavaChaincode) increment_value(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
 	var account_id string
 
-	if len(args) != 2 {
+	if len(args) != 3 {
 		return nil, errors.New("Incorrect number of arguments.")
 	}
 
 	account_id = args[0]
 	inc_val, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || inc_val <= 0 {
+		return nil, errors.New("Value must be a positive number, got " + args[1])
+	}
 
-	incAccountAsBytes, err := stub.GetState(account_id)
+	inc_acc, err := get_account(stub, account_id)
 	if err != nil {
-		return nil, errors.New("Could not find the account to increment" + account_id)
+		return nil, errors.New("Could not find the account to increment " + account_id)
 	}
 
-	inc_acc := Account{}
-	json.Unmarshal(incAccountAsBytes, &inc_acc)
-
 	inc_acc.Balance = inc_acc.Balance + inc_val
-	newAccountAsBytes, _ := json.Marshal(inc_acc)
-	newacc_string := string(newAccountAsBytes)
-	err = stub.PutState(account_id, []byte(newacc_string))
+	err = put_account(stub, inc_acc)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	err = emit_events(stub, []GuavaEvent{account_event("balance_changed", inc_acc, inc_val)})
+	if err != nil {
+		return nil, err
+	}
+
+	return account_response(inc_acc.Guava_id, inc_acc)
 }
 
 // ============================================================================================================================
-// decrement_value - decrease balance in account <account_id, value>
+// decrement_value - decrease balance in account <account_id, value, actor>
 // ============================================================================================================================
 
 func (t *GuavaChaincode) decrement_value(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
 	var account_id string
 
-	if len(args) != 2 {
+	if len(args) != 3 {
 		return nil, errors.New("Incorrect number of arguments.")
 	}
 
 	account_id = args[0]
 	dec_val, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || dec_val <= 0 {
+		return nil, errors.New("Value must be a positive number, got " + args[1])
+	}
 
-	decAccountAsBytes, err := stub.GetState(account_id)
+	dec_acc, err := get_account(stub, account_id)
 	if err != nil {
-		return nil, errors.New("Could not find the account to increment" + account_id)
+		return nil, errors.New("Could not find the account to decrement " + account_id)
 	}
 
-	dec_acc := Account{}
-	json.Unmarshal(decAccountAsBytes, &dec_acc)
+	if dec_acc.Balance < dec_val {
+		return nil, errors.New("account does not have enough funds to decrement " + account_id)
+	}
 
 	dec_acc.Balance = dec_acc.Balance - dec_val
-	newAccountAsBytes, _ := json.Marshal(dec_acc)
-	newacc_string := string(newAccountAsBytes)
-	err = stub.PutState(account_id, []byte(newacc_string))
+	err = put_account(stub, dec_acc)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	err = emit_events(stub, []GuavaEvent{account_event("balance_changed", dec_acc, -dec_val)})
+	if err != nil {
+		return nil, err
+	}
+
+	return account_response(dec_acc.Guava_id, dec_acc)
 
 }
 
 // ============================================================================================================================
 // accept_transfer - accept the transfer from the outgoing array<to_id, from_id, transfer_id, dec_value, inc_value, approver>
+// to_id is the To of the transfer, 0 for a payment without one
 // ============================================================================================================================
 
 func (t *GuavaChaincode) accept_transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
 	var receiving_id, sending_id, transfer_id, approver string
-	var found bool
 
 	if len(args) != 6 {
 		return nil, errors.New("Incorrect number of arguments.")
@@ -490,76 +581,99 @@ func (t *GuavaChaincode) accept_transfer(stub shim.ChaincodeStubInterface, args
 	sending_id = args[1]
 	transfer_id = args[2]
 	dec_value, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return nil, errors.New("Invalid dec_value " + args[3])
+	}
 	inc_value, err := strconv.ParseFloat(args[4], 64)
+	if err != nil {
+		return nil, errors.New("Invalid inc_value " + args[4])
+	}
 	approver = args[5]
 	//get the account from the passed in receiving_id (should be account who accepted)
 
 	//rec_id_int, err := strconv.ParseInt(receiving_id, 10, 64)
 	//sen_id_int, err := strconv.ParseInt(sending_id, 10, 64)
 	tran_id_int, err := strconv.ParseInt(transfer_id, 10, 64)
-
-	recAccountAsBytes, err := stub.GetState(receiving_id)
 	if err != nil {
-		return nil, errors.New("Could not find the account that is receiving funds " + receiving_id)
+		return nil, errors.New("Invalid transfer id " + transfer_id)
 	}
 
-	receiving_acc := Account{}
-	json.Unmarshal(recAccountAsBytes, &receiving_acc)
-
 	// find the account that is sending the transaction from the transaction
-	sendAccountAsBytes, err := stub.GetState(sending_id)
+	sending_acc, err := get_account(stub, sending_id)
 	if err != nil {
 		return nil, errors.New("Could not find the account that is sending funds " + sending_id)
 	}
 
-	sending_acc := Account{}
-	json.Unmarshal(sendAccountAsBytes, &sending_acc)
-
-	// decrement sending account
-	// increcment receiving account
+	transl := find_transfer(sending_acc.OutgoingTransfer, tran_id_int)
+	if transl == nil {
+		return nil, errors.New("The transfer id was not found: " + transfer_id)
+	}
 
-	if sending_acc.Balance < dec_value {
-		return nil, errors.New("sending account does not have enough funds " + sending_id)
-	} else {
+	// the arguments confirm what the approver saw, the transfer itself decides where the money goes
+	if strings.Compare(strconv.FormatInt(transl.To, 10), receiving_id) != 0 {
+		return nil, errors.New("Transfer " + transfer_id + " is not to account " + receiving_id)
+	}
+	if transl.Dec_value != dec_value || transl.Inc_value != inc_value {
+		return nil, errors.New("Transfer " + transfer_id + " values do not match, expected dec_value " + format_float(transl.Dec_value) + " and inc_value " + format_float(transl.Inc_value))
+	}
 
-		sending_acc.Balance = sending_acc.Balance - dec_value
-		receiving_acc.Balance = receiving_acc.Balance + inc_value
+	// a payment leaves the ledger, only the other transfers credit the receiving account
+	var receiving_acc *Account
+	if credits_receiver(transl) {
+		receiving_acc, err = get_account(stub, receiving_id)
+		if err != nil {
+			return nil, errors.New("Could not find the account that is receiving funds " + receiving_id)
+		}
+		if strings.Compare(receiving_id, sending_id) == 0 {
+			receiving_acc = sending_acc
+		}
 	}
 
-	trans_list_o := sending_acc.OutgoingTransfer
-	found = false
+	limits, err := new_limit_checker(stub)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len(trans_list_o); i++ {
-		transl := &trans_list_o[i]
-		if transl.Transfer_id == tran_id_int {
-			transl.Status = "approved"
-			transl.Approver = approver
-			receiving_acc.IncomingTransfer = append(receiving_acc.IncomingTransfer, *transl)
-			found = true
-		}
+	// record the approval, decrement sending account and increment receiving account once the policy is satisfied
+	settled, err := approve_transfer(stub, limits, sending_acc, receiving_acc, transl, dec_value, inc_value, approver)
+	if err != nil {
+		return nil, err
 	}
 
-	if found == false {
-		return nil, errors.New("The transfer id was not found: " + transfer_id)
+	err = limits.put_all(stub)
+	if err != nil {
+		return nil, err
 	}
 
 	//update the account states
 
-	newrecAccountasBytes, _ := json.Marshal(receiving_acc)
-	rec_acc_string := string(newrecAccountasBytes)
-	err = stub.PutState(receiving_id, []byte(rec_acc_string))
+	if receiving_acc != nil {
+		err = put_account(stub, receiving_acc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = put_account(stub, sending_acc)
 	if err != nil {
 		return nil, err
 	}
 
-	newsendAccountAsBytes, _ := json.Marshal(sending_acc)
-	send_acc_string := string(newsendAccountAsBytes)
-	err = stub.PutState(sending_id, []byte(send_acc_string))
+	events := []GuavaEvent{transfer_event("transfer_approval_added", sending_acc, transl)}
+	if settled {
+		events[0].Event_type = "transfer_accepted"
+		events = append(events, account_event("balance_changed", sending_acc, -dec_value))
+		if receiving_acc != nil {
+			events = append(events, account_event("balance_changed", receiving_acc, inc_value))
+		}
+	}
+
+	err = emit_events(stub, events)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return transfer_response(transl, sending_acc, receiving_acc)
 
 }
 
@@ -582,93 +696,350 @@ func (t *GuavaChaincode) reject_transfer(stub shim.ChaincodeStubInterface, args
 
 	//	sen_id_int, err := strconv.ParseInt(sending_id, 10, 64)
 	tran_id_int, err := strconv.ParseInt(transfer_id, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid transfer id " + transfer_id)
+	}
 
 	// find the account that is sending the transfer
-	sendAccountAsBytes, err := stub.GetState(sending_id)
+	sending_acc, err := get_account(stub, sending_id)
 	if err != nil {
 		return nil, errors.New("Could not find the account that is sending funds " + sending_id)
 	}
 
-	sending_acc := Account{}
-	json.Unmarshal(sendAccountAsBytes, &sending_acc)
-
 	trans_list_o := sending_acc.OutgoingTransfer
+	events := make([]GuavaEvent, 0)
+	var rejected *Transfer
 
 	for i := 0; i < len(trans_list_o); i++ {
 		transl := &trans_list_o[i]
 		if transl.Transfer_id == tran_id_int {
+			if strings.Compare(transl.Status, "pending") != 0 {
+				return nil, errors.New("Transfer " + transfer_id + " is not pending, status is " + transl.Status)
+			}
 			transl.Status = "rejected"
 			transl.Approver = approver
+			events = append(events, transfer_event("transfer_rejected", sending_acc, transl))
+			rejected = transl
 		}
 	}
 
-	newsendAccountAsBytes, _ := json.Marshal(sending_acc)
-	send_acc_string := string(newsendAccountAsBytes)
-	err = stub.PutState(sending_id, []byte(send_acc_string))
+	if rejected == nil {
+		return nil, errors.New("The transfer id was not found: " + transfer_id)
+	}
+
+	err = del_pending_inbound(stub, account_cache{sending_acc.AccountID: sending_acc}, rejected)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	err = put_account(stub, sending_acc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer_response(rejected, sending_acc)
+
+}
+
+// ============================================================================================================================
+// cancel_transfer - withdraw a pending transfer, by its creator or an owner of the sending guava <from_id, trans_id, actor, reason>
+// the creator needs create on the sending account, anyone else owner
+// ============================================================================================================================
+
+func (t *GuavaChaincode) cancel_transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var sending_id, transfer_id, actor, reason string
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <from_id, trans_id, actor, reason>")
+	}
+
+	sending_id = args[0]
+	transfer_id = args[1]
+	actor = args[2]
+	reason = args[3]
+
+	tran_id_int, err := strconv.ParseInt(transfer_id, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid transfer id " + transfer_id)
+	}
+
+	sending_acc, err := get_account(stub, sending_id)
+	if err != nil {
+		return nil, errors.New("Could not find the account that is sending funds " + sending_id)
+	}
+
+	transl := find_transfer(sending_acc.OutgoingTransfer, tran_id_int)
+	if transl == nil {
+		return nil, errors.New("The transfer id was not found: " + transfer_id)
+	}
+	if strings.Compare(transl.Status, "pending") != 0 {
+		return nil, errors.New("Transfer " + transfer_id + " is not pending, status is " + transl.Status)
+	}
+
+	// the router let the actor in with either right, check the one that applies
+	user, err := get_actor(stub, sending_acc.Guava_id, actor)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Compare(actor, transl.Creator) == 0 {
+		allowed, err := user_allowed(stub, sending_acc.Guava_id, user, sending_acc.AccountID, "create", "cancel_transfer")
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errors.New("User " + actor + " does not have the create permission in guava " + sending_acc.Guava_id)
+		}
+	} else {
+		allowed, err := user_allowed(stub, sending_acc.Guava_id, user, sending_acc.AccountID, "owner", "cancel_transfer")
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errors.New("User " + actor + " is neither the creator of transfer " + transfer_id + " nor an owner of the sending account")
+		}
+	}
+
+	now, err := tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// a pending transfer has not moved any funds yet, so cancelling it leaves both balances as they are
+	transl.Status = "cancelled"
+	transl.Cancelled_by = actor
+	transl.Cancel_reason = reason
+	transl.Cancel_time = now
+
+	err = del_pending_inbound(stub, account_cache{sending_acc.AccountID: sending_acc}, transl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_account(stub, sending_acc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, []GuavaEvent{transfer_event("transfer_cancelled", sending_acc, transl)})
+	if err != nil {
+		return nil, err
+	}
 
+	return transfer_response(transl, sending_acc)
 }
 
 // ============================================================================================================================
-// create_user - create a new user with the specific access rights and add it to the User map <username, owner, create, approve, read>
+// create_user - create a new user with the specific access rights in a guava <username, owner, create, approve, read, guava_id, identity, actor>
+// identity is the client identity the user acts with, empty for the caller's own, the chaincode admin creates the first owner of a guava
 // ============================================================================================================================
 
 func (t *GuavaChaincode) create_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var username string
 
-	var owner, create, approve, read bool
 	var guava_id string
 
-	if len(args) != 6 {
+	if len(args) != 8 {
 		return nil, errors.New("Incorrect number of arguments.")
 	}
 
 	username = args[0]
-	owner, err := strconv.ParseBool(args[1])
-	create, err = strconv.ParseBool(args[2])
-	approve, err = strconv.ParseBool(args[3])
-	read, err = strconv.ParseBool(args[4])
-	//guava_id, err = strconv.ParseInt(args[5], 10, 64)
+
+	rights := make([]bool, 4)
+	for i := 0; i < len(rights); i++ {
+		right, err := strconv.ParseBool(args[1+i])
+		if err != nil {
+			return nil, errors.New("Invalid access right " + args[1+i])
+		}
+		rights[i] = right
+	}
 
 	guava_id = args[5]
+	identity, err := check_identity(stub, args[6])
+	if err != nil {
+		return nil, err
+	}
 	// create User struct
 
-	guava_id_int, err := strconv.ParseInt(guava_id, 10, 64)
-
 	new_user := &User{
 		Username: username,
-		Owner:    owner,
-		Create:   create,
-		Approve:  approve,
-		Read:     read}
+		Owner:    rights[0],
+		Create:   rights[1],
+		Approve:  rights[2],
+		Read:     rights[3],
+		Identity: identity,
+		Roles:    make([]RoleGrant, 0),
+		Schema:   UserSchema}
+
+	_, err = get_guava(stub, guava_id)
+	if err != nil {
+		return nil, errors.New("Guava id does not exist")
+	}
 
-	//find guava_id in user map
-	if guava_id_int <= guavacount-1 {
-		//add the account number to the OwnerAccountMap
-		// add user struct to the array
+	// a user is stored under its name, so a second user of the same name would replace the first
+	existing, err := get_user(stub, guava_id, username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("User " + username + " already exists in guava " + guava_id)
+	}
+
+	err = put_user(stub, guava_id, new_user)
+	if err != nil {
+		return nil, err
+	}
 
-		UserMap[guava_id] = append(UserMap[guava_id], *new_user)
+	err = record_user_change(stub, guava_id, new_user, "created", args[7])
+	if err != nil {
+		return nil, err
+	}
 
-		//var accounts AccountStruct
-		jsonAsBytes, _ := json.Marshal(UserMap)
-		usermap_string := string(jsonAsBytes)
+	respAsBytes, _ := json.Marshal(UserResponse{Version: ApiVersion, Guava_id: guava_id, User: new_user})
+	return respAsBytes, nil
 
-		// add the new map to the world state
+}
+
+// ============================================================================================================================
+// get_account - load an account from the world state upgraded to the current schema, erroring if it does not exist
+// ============================================================================================================================
+
+func get_account(stub shim.ChaincodeStubInterface, account_id string) (*Account, error) {
+
+	acc := Account{}
+	found, err := get_record(stub, &acc, AccountKey, account_id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Could not find account " + account_id)
+	}
+	upgrade_account(&acc)
+
+	return &acc, nil
+}
+
+// ============================================================================================================================
+// put_account - write an account back to the world state under its account id
+// ============================================================================================================================
+
+func put_account(stub shim.ChaincodeStubInterface, acc *Account) error {
+	return put_record(stub, acc, AccountKey, strconv.FormatInt(acc.AccountID, 10))
+}
+
+// ============================================================================================================================
+// find_transfer - find a transfer by id in a list of transfers, nil if it is not there
+// ============================================================================================================================
+
+func find_transfer(transfers []Transfer, transfer_id int64) *Transfer {
+
+	for i := 0; i < len(transfers); i++ {
+		if transfers[i].Transfer_id == transfer_id {
+			return &transfers[i]
+		}
+	}
 
-		err = stub.PutState(UserMapkey, []byte(usermap_string))
+	return nil
+}
+
+// ============================================================================================================================
+// get_transfer - find a transfer by id through its index, returning it as held by the account that sent it
+// the reversal of a payment comes from outside the ledger and is only held by the account it refunds
+// ============================================================================================================================
+
+func get_transfer(stub shim.ChaincodeStubInterface, transfer_id string) (*Transfer, *Account, error) {
+
+	index, err := get_transfer_index(stub, transfer_id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if index.From == 0 {
+		receiving_acc, err := get_account(stub, strconv.FormatInt(index.To, 10))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		transl := find_transfer(receiving_acc.IncomingTransfer, index.Transfer_id)
+		if transl == nil {
+			return nil, nil, errors.New("Transfer " + transfer_id + " is indexed to account " + strconv.FormatInt(index.To, 10) + " but is not held by it")
 		}
+		return transl, receiving_acc, nil
+	}
 
-	} else {
-		return nil, errors.New("Guava id does not exist")
+	sending_acc, err := get_account(stub, strconv.FormatInt(index.From, 10))
+	if err != nil {
+		return nil, nil, err
+	}
 
+	transl := find_transfer(sending_acc.OutgoingTransfer, index.Transfer_id)
+	if transl == nil {
+		return nil, nil, errors.New("Transfer " + transfer_id + " is indexed to account " + strconv.FormatInt(index.From, 10) + " but is not held by it")
 	}
 
-	return nil, nil
+	return transl, sending_acc, nil
+}
+
+// ============================================================================================================================
+// user_has_role - check a user holds the access right named by role <owner, create, approve, read> across its guava,
+// through its flags or a built-in role granted for every account, disabled users hold none
+// ============================================================================================================================
+
+func user_has_role(user *User, role string) bool {
+
+	if user.Disabled {
+		return false
+	}
+
+	for i := 0; i < len(user.Roles); i++ {
+		if strings.Compare(user.Roles[i].Role, role) == 0 && len(user.Roles[i].Accounts) == 0 {
+			return true
+		}
+	}
+
+	switch role {
+	case "owner":
+		return user.Owner
+	case "create":
+		return user.Create
+	case "approve":
+		return user.Approve
+	case "read":
+		return user.Read
+	}
+
+	return false
+}
+
+func is_user_role(role string) bool {
+
+	return user_has_role(&User{Owner: true, Create: true, Approve: true, Read: true}, role)
+}
+
+// ============================================================================================================================
+// tx_time - the timestamp of the current transaction, the same on every peer
+// ============================================================================================================================
+
+func tx_time(stub shim.ChaincodeStubInterface) (time.Time, error) {
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, errors.New("Could not get the transaction timestamp")
+	}
+
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+func tx_time_string(stub shim.ChaincodeStubInterface) (string, error) {
+
+	now, err := tx_time(stub)
+	if err != nil {
+		return "", err
+	}
 
+	return now.Format(time.RFC3339), nil
 }
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ============================================================================================================================
+// test_ledger - a GuavaChaincode on a MockStub whose transaction time the test controls
+// ============================================================================================================================
+
+type test_ledger struct {
+	t       *testing.T
+	cc      *GuavaChaincode
+	stub    *shimtest.MockStub
+	now     time.Time
+	tx      int
+	caller  string //user the next call is signed by, the actor it names if empty
+	events  []*pb.ChaincodeEvent
+	history map[string][]*queryresult.KeyModification
+}
+
+// timed_stub hands the chaincode the test's arguments and transaction time, MockInvoke would stamp the wall clock.
+// It also keeps the history of every key the chaincode writes, which MockStub does not.
+type timed_stub struct {
+	*shimtest.MockStub
+	args    []string
+	now     time.Time
+	history map[string][]*queryresult.KeyModification
+}
+
+func (s *timed_stub) GetFunctionAndParameters() (string, []string) {
+	return s.args[0], s.args[1:]
+}
+
+func (s *timed_stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.now), nil
+}
+
+func (s *timed_stub) PutState(key string, value []byte) error {
+	s.record(key, value, false)
+	return s.MockStub.PutState(key, value)
+}
+
+func (s *timed_stub) DelState(key string) error {
+	s.record(key, nil, true)
+	return s.MockStub.DelState(key)
+}
+
+// record keeps the last write of a key in each transaction, as the ledger does
+func (s *timed_stub) record(key string, value []byte, deleted bool) {
+	mod := &queryresult.KeyModification{TxId: s.GetTxID(), Value: value, Timestamp: timestamppb.New(s.now), IsDelete: deleted}
+
+	mods := s.history[key]
+	if len(mods) > 0 && mods[len(mods)-1].TxId == mod.TxId {
+		mods = mods[:len(mods)-1]
+	}
+	s.history[key] = append(mods, mod)
+}
+
+// GetHistoryForKey returns the newest version first like the peer does
+func (s *timed_stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	mods := s.history[key]
+	newest := make([]*queryresult.KeyModification, len(mods))
+	for i := range mods {
+		newest[len(mods)-1-i] = mods[i]
+	}
+	return &history_iterator{mods: newest}, nil
+}
+
+type history_iterator struct {
+	mods []*queryresult.KeyModification
+}
+
+func (it *history_iterator) HasNext() bool {
+	return len(it.mods) > 0
+}
+
+func (it *history_iterator) Next() (*queryresult.KeyModification, error) {
+	mod := it.mods[0]
+	it.mods = it.mods[1:]
+	return mod, nil
+}
+
+func (it *history_iterator) Close() error {
+	return nil
+}
+
+func new_ledger(t *testing.T) *test_ledger {
+	cc := new(GuavaChaincode)
+	return &test_ledger{
+		t:       t,
+		cc:      cc,
+		stub:    shimtest.NewMockStub("guava", cc),
+		now:     time.Date(2026, time.January, 5, 9, 0, 0, 0, time.UTC),
+		history: make(map[string][]*queryresult.KeyModification)}
+}
+
+// cert is the serialized identity a test user signs with and identity its fingerprint
+func cert(username string) []byte {
+	return []byte("x509::CN=" + username)
+}
+
+func identity(username string) string {
+	sum := sha256.Sum256(cert(username))
+	return hex.EncodeToString(sum[:])
+}
+
+// as signs the next call as username whichever actor it names
+func (l *test_ledger) as(username string) *test_ledger {
+	l.caller = username
+	return l
+}
+
+// actor_of is the user a call names as its actor, empty for functions without one or arguments that do not decode
+func actor_of(args []string) string {
+	route, ok := routes[args[0]]
+	if !ok || route.Actor == "" {
+		return ""
+	}
+
+	decoded, err := route.decode(args[1:])
+	if err != nil {
+		return ""
+	}
+
+	return decoded[route.arg_index(route.Actor)]
+}
+
+func (l *test_ledger) invoke(args ...string) pb.Response {
+	l.tx = l.tx + 1
+	txid := "tx" + strconv.Itoa(l.tx)
+
+	if l.caller == "" {
+		l.caller = actor_of(args)
+	}
+	l.stub.Creator = cert(l.caller)
+	l.caller = ""
+
+	l.stub.MockTransactionStart(txid)
+	resp := l.cc.Invoke(&timed_stub{MockStub: l.stub, args: args, now: l.now, history: l.history})
+	l.stub.MockTransactionEnd(txid)
+
+	for len(l.stub.ChaincodeEventsChannel) > 0 {
+		l.events = append(l.events, <-l.stub.ChaincodeEventsChannel)
+	}
+
+	return resp
+}
+
+func (l *test_ledger) ok(args ...string) []byte {
+	l.t.Helper()
+
+	resp := l.invoke(args...)
+	if resp.Status != shim.OK {
+		l.t.Fatalf("%v failed: %s", args, resp.Message)
+	}
+
+	return resp.Payload
+}
+
+func (l *test_ledger) fail(args ...string) string {
+	l.t.Helper()
+
+	resp := l.invoke(args...)
+	if resp.Status == shim.OK {
+		l.t.Fatalf("%v succeeded, expected an error", args)
+	}
+
+	return resp.Message
+}
+
+func (l *test_ledger) fail_with(substr string, args ...string) {
+	l.t.Helper()
+
+	msg := l.fail(args...)
+	if !strings.Contains(msg, substr) {
+		l.t.Fatalf("%v failed with %q, expected it to mention %q", args, msg, substr)
+	}
+}
+
+func (l *test_ledger) account(account_id int64) *Account {
+	l.t.Helper()
+
+	acc, err := get_account(l.stub, strconv.FormatInt(account_id, 10))
+	if err != nil {
+		l.t.Fatalf("account %d: %s", account_id, err)
+	}
+
+	return acc
+}
+
+func (l *test_ledger) transfer(from int64, transfer_id int64) Transfer {
+	l.t.Helper()
+
+	transl := find_transfer(l.account(from).OutgoingTransfer, transfer_id)
+	if transl == nil {
+		l.t.Fatalf("transfer %d not found on account %d", transfer_id, from)
+	}
+
+	return *transl
+}
+
+func (l *test_ledger) balance(account_id int64, want float64) {
+	l.t.Helper()
+
+	if got := l.account(account_id).Balance; got != want {
+		l.t.Fatalf("account %d balance = %v, want %v", account_id, got, want)
+	}
+}
+
+// state reads the raw value stored under a composite key, nil if there is none
+func (l *test_ledger) state(kind string, attributes ...string) []byte {
+	l.t.Helper()
+
+	key, err := make_key(l.stub, kind, attributes...)
+	if err != nil {
+		l.t.Fatalf("%s key: %s", kind, err)
+	}
+
+	return l.stub.State[key]
+}
+
+func (l *test_ledger) decode(payload []byte, v interface{}) {
+	l.t.Helper()
+
+	err := json.Unmarshal(payload, v)
+	if err != nil {
+		l.t.Fatalf("could not decode %s: %s", payload, err)
+	}
+}
+
+// setup creates guava 1 with two CAD accounts and a user for each access right:
+// account 1 "ops" holds 1000, account 2 "savings" holds 500
+// alice is an owner with every right, bob can create, carol and erin can approve, dave can only read
+// root, the chaincode admin, creates the guava and its first owner alice
+func (l *test_ledger) setup() {
+	l.init()
+	l.ok("create_account", "ops", "-1", "CAD", "CA", "OPR", "1000", "root")
+	l.ok("create_account", "savings", "1", "CAD", "CA", "SAVINGS", "500", "root")
+
+	l.ok("create_user", "alice", "true", "true", "true", "true", "1", identity("alice"), "root")
+	l.ok("create_user", "bob", "false", "true", "false", "true", "1", identity("bob"), "alice")
+	l.ok("create_user", "carol", "false", "false", "true", "true", "1", identity("carol"), "alice")
+	l.ok("create_user", "dave", "false", "false", "false", "true", "1", identity("dave"), "alice")
+	l.ok("create_user", "erin", "false", "false", "true", "true", "1", identity("erin"), "alice")
+}
+
+// init instantiates the chaincode with root as its admin
+func (l *test_ledger) init() {
+	l.t.Helper()
+
+	l.stub.Creator = cert("root")
+	resp := l.stub.MockInit("init", [][]byte{[]byte("init"), []byte("world"), []byte("root")})
+	if resp.Status != shim.OK {
+		l.t.Fatalf("Init failed: %s", resp.Message)
+	}
+}
+
+// payment creates a pending payment of amount from account 1 to account 2 by bob and returns its id
+// beneficiary is the beneficiary_json the tests pay
+const beneficiary = `{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}`
+
+func (l *test_ledger) payment(amount string) int64 {
+	l.t.Helper()
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "invoice", "1", amount, amount, "1", "2", "payment", "2026-01-05", "bob", beneficiary), &resp)
+	return resp.Transfer_id
+}
+
+// ============================================================================================================================
+// Tests
+// ============================================================================================================================
+
+func TestInit(t *testing.T) {
+	l := new_ledger(t)
+
+	l.stub.Creator = cert("root")
+	resp := l.stub.MockInit("init", [][]byte{[]byte("init"), []byte("world"), []byte("root")})
+	if resp.Status != shim.OK {
+		t.Fatalf("Init failed: %s", resp.Message)
+	}
+	if got := string(l.state(ConfigKey, "hello")); got != "world" {
+		t.Fatalf("hello = %q, want world", got)
+	}
+
+	resp = l.stub.MockInit("init", [][]byte{[]byte("init"), []byte("world")})
+	if resp.Status == shim.OK {
+		t.Fatalf("Init without an admin succeeded")
+	}
+
+	// only the admin can init again, under its own name and identity
+	l.ok("init", "again", "root")
+	if got := string(l.state(ConfigKey, "hello")); got != "again" {
+		t.Fatalf("hello = %q, want again", got)
+	}
+	l.fail_with("User mallory is not the chaincode admin", "init", "again", "mallory")
+	l.as("mallory").fail_with("User root is not the chaincode admin", "init", "again", "root")
+}
+
+func TestCreateAccount(t *testing.T) {
+	l := new_ledger(t)
+	l.init()
+
+	var resp AccountResponse
+	l.decode(l.ok("create_account", "ops", "-1", "CAD", "CA", "OPR", "1000", "root"), &resp)
+	if resp.Guava_id != "1" || resp.Account_id != 1 || resp.Account.Balance != 1000 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.decode(l.ok("create_account", "savings", "1", "USD", "US", "SAVINGS", "0", "root"), &resp)
+	if resp.Guava_id != "1" || resp.Account_id != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.decode(l.ok("create_account", "other", "-1", "EUR", "DE", "OPR", "0", "root"), &resp)
+	if resp.Guava_id != "2" || resp.Account_id != 3 {
+		t.Fatalf("a new guava was not created: %+v", resp)
+	}
+
+	acc := l.account(2)
+	if acc.AccountName != "savings" || acc.Currency != "USD" || acc.Country != "US" || acc.Type != "SAVINGS" {
+		t.Fatalf("unexpected account %+v", acc)
+	}
+
+	guava, err := get_guava(l.stub, "1")
+	if err != nil || !reflect.DeepEqual(guava.Accounts, []int64{1, 2}) {
+		t.Fatalf("unexpected guava %+v", guava)
+	}
+	if acc.Guava_id != "1" || l.account(3).Guava_id != "2" {
+		t.Fatalf("accounts do not record their guava")
+	}
+	l.fail_with("Could not find guava 7", "create_account", "ops", "7", "CAD", "CA", "OPR", "0", "root")
+
+	// owners add accounts to their own guava, only the chaincode admin creates guavas
+	l.ok("create_user", "alice", "true", "true", "true", "true", "1", identity("alice"), "root")
+	l.ok("create_account", "usd", "1", "USD", "US", "OPR", "0", "alice")
+	l.fail_with("User alice does not have the owner permission in guava 2", "create_account", "eur", "2", "EUR", "DE", "OPR", "0", "alice")
+	l.fail_with("User alice does not have the owner permission in guava -1", "create_account", "new", "-1", "CAD", "CA", "OPR", "0", "alice")
+
+	l.fail_with("Incorrect number of arguments", "create_account", "ops", "-1", "CAD", "CA", "OPR", "root")
+	l.fail_with("Invalid initial balance", "create_account", "ops", "-1", "CAD", "CA", "OPR", "lots", "root")
+}
+
+func TestCreateUser(t *testing.T) {
+	l := new_ledger(t)
+	l.init()
+	l.ok("create_account", "ops", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	// a guava without users has no owner, only the chaincode admin can create its first one
+	l.fail_with("User mallory does not have the owner permission in guava 1", "create_user", "mallory", "true", "true", "true", "true", "1", identity("mallory"), "mallory")
+	l.fail_with("User mallory does not have the owner permission in guava 1", "increment_value", "1", "1000000", "mallory")
+	if users, err := get_users(l.stub, "1"); err != nil || len(users) != 0 {
+		t.Fatalf("a stranger added users %v to guava 1", users)
+	}
+
+	var resp UserResponse
+	l.decode(l.ok("create_user", "alice", "true", "false", "true", "false", "1", identity("alice"), "root"), &resp)
+	if resp.Guava_id != "1" || resp.User.Username != "alice" || !resp.User.Owner || resp.User.Create || !resp.User.Approve || resp.User.Read {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	user, err := get_user(l.stub, "1", "alice")
+	if err != nil || user == nil || user.Username != "alice" || !user.Owner || user.Schema != UserSchema {
+		t.Fatalf("alice was not added to guava 1: %+v", user)
+	}
+
+	l.fail_with("User alice already exists in guava 1", "create_user", "alice", "true", "true", "true", "true", "1", identity("alice"), "alice")
+
+	l.fail_with("Guava id does not exist", "create_user", "bob", "true", "true", "true", "true", "7", identity("bob"), "root")
+
+	// only its owners and the chaincode admin add more
+	l.ok("create_user", "bob", "false", "true", "false", "true", "1", identity("bob"), "alice")
+	l.fail_with("User bob does not have the owner permission in guava 1", "create_user", "mallory", "true", "true", "true", "true", "1", identity("mallory"), "bob")
+	l.fail_with("User mallory does not have the owner permission in guava 1", "create_user", "mallory", "true", "true", "true", "true", "1", identity("mallory"), "mallory")
+	l.ok("create_user", "carol", "false", "false", "true", "true", "1", identity("carol"), "root")
+
+	l.fail_with("Invalid access right maybe", "create_user", "dave", "false", "maybe", "false", "true", "1", identity("dave"), "alice")
+	l.fail_with("Incorrect number of arguments", "create_user", "bob", "true", "true", "true", "true", "alice")
+}
+
+func TestCreateTransferInternal(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "sweep", "1", "250", "250", "1", "2", "internal", "2026-01-05", "bob", ""), &resp)
+	if resp.Transfer_id != 1 || resp.Status != "approved" || len(resp.Balances) != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.balance(1, 750)
+	l.balance(2, 750)
+
+	transl := l.transfer(1, 1)
+	if transl.Approver != "bob" || transl.Created != "2026-01-05T09:00:00Z" {
+		t.Fatalf("unexpected transfer %+v", transl)
+	}
+	if find_transfer(l.account(2).IncomingTransfer, 1) == nil {
+		t.Fatalf("transfer was not added to the receiving account")
+	}
+}
+
+func TestCreateTransferPayment(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	id := l.payment("300")
+
+	l.balance(1, 1000)
+	l.balance(2, 500)
+
+	transl := l.transfer(1, id)
+	if transl.Status != "pending" || transl.Approver != "pending" {
+		t.Fatalf("unexpected transfer %+v", transl)
+	}
+	if len(l.account(2).IncomingTransfer) != 0 {
+		t.Fatalf("a pending payment was added to the receiving account")
+	}
+}
+
+func TestCreateTransferErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("not have enough funds", "create_transfer", "m", "1", "1001", "1001", "1", "2", "internal", "t", "bob", "")
+	l.fail_with("Could not find the guava of account 9", "create_transfer", "m", "1", "1", "1", "9", "2", "internal", "t", "bob", "")
+	l.fail_with("Could not find this account that is receiving", "create_transfer", "m", "1", "1", "1", "1", "9", "internal", "t", "bob", "")
+	l.fail_with("Invalid value_dec", "create_transfer", "m", "1", "1", "x", "1", "2", "internal", "t", "bob", "")
+	l.fail_with("must be positive", "create_transfer", "m", "1", "-5", "-5", "1", "2", "internal", "t", "bob", "")
+	l.fail_with("does not have the create permission", "create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "carol", "")
+	l.fail_with("does not have the create permission", "create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "mallory", "")
+	l.fail_with("Incorrect number of arguments", "create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t")
+
+	if l.state(AccountKey, "9") != nil {
+		t.Fatalf("a failed transfer wrote an account for a missing id")
+	}
+	l.balance(1, 1000)
+	l.balance(2, 500)
+}
+
+func TestIncrementDecrementValue(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp AccountResponse
+	l.decode(l.ok("increment_value", "1", "20.5", "alice"), &resp)
+	if resp.Account_id != 1 || resp.Account.Balance != 1020.5 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.ok("decrement_value", "2", "100", "alice")
+	l.balance(1, 1020.5)
+	l.balance(2, 400)
+
+	l.fail_with("User bob does not have the owner permission in guava 1", "increment_value", "1", "5", "bob")
+	l.fail_with("User bob does not have the owner permission in guava 1", "decrement_value", "1", "5", "bob")
+	l.fail_with("Could not find the guava of account 9", "increment_value", "9", "1", "alice")
+	l.fail_with("Could not find the guava of account 9", "decrement_value", "9", "1", "alice")
+	l.fail_with("positive number", "increment_value", "1", "-1", "alice")
+	l.fail_with("positive number", "decrement_value", "1", "abc", "alice")
+	l.fail_with("not have enough funds to decrement", "decrement_value", "2", "400.01", "alice")
+	l.balance(2, 400)
+}
+
+func TestAcceptTransfer(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	id := l.payment("300")
+	id_str := strconv.FormatInt(id, 10)
+
+	l.fail_with("does not have the approve permission", "accept_transfer", "2", "1", id_str, "300", "300", "bob")
+	l.fail_with("The transfer id was not found", "accept_transfer", "2", "1", "99", "300", "300", "carol")
+	l.fail_with("Invalid transfer id", "accept_transfer", "2", "1", "x", "300", "300", "carol")
+	l.fail_with("is not to account 1", "accept_transfer", "1", "1", id_str, "300", "300", "carol")
+	l.fail_with("values do not match", "accept_transfer", "2", "1", id_str, "300", "3000", "carol")
+
+	var resp TransferResponse
+	l.decode(l.ok("accept_transfer", "2", "1", id_str, "300", "300", "carol"), &resp)
+	if resp.Status != "approved" || resp.Transfer.Approver != "carol" || len(resp.Transfer.Approvals) != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// the payment is paid out to its beneficiary, its To account is not credited
+	l.balance(1, 700)
+	l.balance(2, 500)
+	if find_transfer(l.account(2).IncomingTransfer, id) != nil {
+		t.Fatalf("accepted payment was added to the receiving account")
+	}
+
+	// a settled transfer can not be accepted or rejected again
+	l.fail_with("is not pending", "accept_transfer", "2", "1", id_str, "300", "300", "erin")
+	l.fail_with("is not pending", "reject_transfer", "1", id_str, "erin")
+	l.balance(1, 700)
+	l.balance(2, 500)
+}
+
+func TestPaymentWithoutTo(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "invoice", "1", "300", "300", "1", "0", "payment", "t", "bob", beneficiary), &resp)
+	if resp.Transfer.To != 0 || resp.Status != "pending" || len(resp.Balances) != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	id_str := strconv.FormatInt(resp.Transfer_id, 10)
+
+	l.fail_with("is not to account 2", "accept_transfer", "2", "1", id_str, "300", "300", "carol")
+	l.decode(l.ok("accept_transfer", "0", "1", id_str, "300", "300", "carol"), &resp)
+	if resp.Status != "approved" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	l.balance(1, 700)
+	l.balance(2, 500)
+
+	// only payments may leave the To account out
+	l.fail_with("Could not find this account that is receiving funds 0", "create_transfer", "m", "1", "10", "10", "1", "0", "internal", "t", "bob", "")
+}
+
+func TestAcceptTransferInsufficientFunds(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	id := l.payment("900")
+	l.ok("decrement_value", "1", "500", "alice")
+
+	l.fail_with("not have enough funds", "accept_transfer", "2", "1", strconv.FormatInt(id, 10), "900", "900", "carol")
+	l.balance(1, 500)
+	l.balance(2, 500)
+	if status := l.transfer(1, id).Status; status != "pending" {
+		t.Fatalf("status = %s, want pending", status)
+	}
+}
+
+func TestRejectTransfer(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	id := l.payment("300")
+	id_str := strconv.FormatInt(id, 10)
+
+	l.fail_with("does not have the approve permission", "reject_transfer", "1", id_str, "dave")
+	l.fail_with("The transfer id was not found", "reject_transfer", "1", "99", "carol")
+	l.fail_with("Could not find the guava of account 9", "reject_transfer", "9", id_str, "carol")
+
+	var resp TransferResponse
+	l.decode(l.ok("reject_transfer", "1", id_str, "carol"), &resp)
+	if resp.Status != "rejected" || resp.Transfer.Approver != "carol" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.balance(1, 1000)
+	l.balance(2, 500)
+	l.fail_with("is not pending", "accept_transfer", "2", "1", id_str, "300", "300", "carol")
+}
+
+func TestCancelTransfer(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	first := strconv.FormatInt(l.payment("100"), 10)
+	second := strconv.FormatInt(l.payment("200"), 10)
+
+	l.fail_with("User carol does not have the create permission in guava 1", "cancel_transfer", "1", first, "carol", "not mine")
+	l.fail_with("User mallory does not have the create permission in guava 1", "cancel_transfer", "1", first, "mallory", "not mine")
+	l.ok("create_user", "frank", "false", "true", "false", "true", "1", identity("frank"), "alice")
+	l.fail_with("neither the creator", "cancel_transfer", "1", first, "frank", "not mine")
+	l.fail_with("The transfer id was not found", "cancel_transfer", "1", "99", "bob", "typo")
+
+	var resp TransferResponse
+	l.decode(l.ok("cancel_transfer", "1", first, "bob", "wrong amount"), &resp)
+	if resp.Status != "cancelled" || resp.Transfer.Cancelled_by != "bob" || resp.Transfer.Cancel_reason != "wrong amount" || resp.Transfer.Cancel_time == "" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// an owner may cancel someone else's transfer
+	l.ok("cancel_transfer", "1", second, "alice", "duplicate")
+
+	l.fail_with("is not pending", "cancel_transfer", "1", first, "bob", "again")
+
+	// a creator who can no longer create can not withdraw its transfers, an owner without create still can
+	third := strconv.FormatInt(l.payment("50"), 10)
+	l.ok("update_user", "1", "bob", "false", "false", "false", "true", "alice")
+	l.fail_with("User bob does not have the create permission in guava 1", "cancel_transfer", "1", third, "bob", "mine")
+	l.ok("update_user", "1", "bob", "true", "false", "false", "true", "alice")
+	l.fail_with("User bob does not have the create permission in guava 1", "cancel_transfer", "1", third, "bob", "mine")
+	l.ok("cancel_transfer", "1", third, "alice", "for bob")
+	l.fail_with("is not pending", "accept_transfer", "2", "1", second, "200", "200", "carol")
+	l.balance(1, 1000)
+	l.balance(2, 500)
+}
+
+func TestGetAndReadGuava(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var acc Account
+	l.decode(l.ok("get_account", "1", "dave"), &acc)
+	if acc.AccountID != 1 || acc.Balance != 1000 {
+		t.Fatalf("unexpected account %+v", acc)
+	}
+
+	var accounts []Account
+	l.decode(l.ok("read_guava", "1", "dave"), &accounts)
+	if len(accounts) != 2 || accounts[0].AccountID != 1 || accounts[1].AccountID != 2 {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+
+	l.fail_with("User dave does not have the read permission in guava 5", "read_guava", "5", "dave")
+}
+
+func TestPaymentScenario(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	// sweep to savings, pay two invoices, accept one and reject the other
+	l.ok("create_transfer", "sweep", "1", "200", "200", "1", "2", "internal", "t", "bob", "")
+	first := strconv.FormatInt(l.payment("300"), 10)
+	second := strconv.FormatInt(l.payment("400"), 10)
+	l.ok("accept_transfer", "2", "1", first, "300", "300", "carol")
+	l.ok("reject_transfer", "1", second, "carol")
+
+	l.balance(1, 500)
+	l.balance(2, 700)
+
+	statuses := make([]string, 0)
+	for _, transl := range l.account(1).OutgoingTransfer {
+		statuses = append(statuses, transl.Status)
+	}
+	if strings.Join(statuses, ",") != "approved,approved,rejected" {
+		t.Fatalf("outgoing statuses = %v", statuses)
+	}
+	if got := len(l.account(2).IncomingTransfer); got != 1 {
+		t.Fatalf("savings has %d incoming transfers, want the sweep", got)
+	}
+}
+
+func TestUnknownFunction(t *testing.T) {
+	l := new_ledger(t)
+
+	l.fail_with("Received unknown function", "transfer_everything")
+}
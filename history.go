@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The world state only holds the latest version of an account. Statements and past balances are rebuilt from the
+// versions the ledger kept of the account's key, oldest first. Chaincode can not see which block wrote a version,
+// so points in time are transaction timestamps. Accounts written before records moved to composite
+// keys have their earlier versions under the legacy key, so both are read.
+
+type AccountBalanceAt struct {
+	Account_id int64    `json:"account_id"`
+	As_of      string   `json:"as_of"`    //the point in time asked for
+	Tx_id      string   `json:"tx_id"`    //last transaction that wrote the account by then
+	Time       string   `json:"time"`     //timestamp of that transaction
+	Balance    float64  `json:"balance"`  //balance at that point
+	Currency   string   `json:"currency"` //currency of the account
+	Account    *Account `json:"account"`  //the account as it was, transfers from other guavas redacted
+}
+
+type GuavaBalanceAt struct {
+	Guava_id string             `json:"guava_id"`
+	As_of    string             `json:"as_of"`    //the point in time asked for
+	Accounts []AccountBalance   `json:"accounts"` //balance of each account that existed by then
+	Totals   map[string]float64 `json:"totals"`   //sum of the balances in each currency
+}
+
+type account_version struct {
+	Tx_id   string    //transaction that wrote this version
+	Time    time.Time //timestamp of that transaction
+	Account *Account  //the account as written, nil if the key was deleted
+}
+
+// ============================================================================================================================
+// account_history - every version written of an account, oldest first
+// ============================================================================================================================
+
+func account_history(stub shim.ChaincodeStubInterface, account_id string) ([]account_version, error) {
+
+	key, err := make_key(stub, AccountKey, account_id)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := key_history(stub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy, err := key_history(stub, account_id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the legacy key was deleted when the account moved, that is not a deletion of the account
+	for i := 0; i < len(legacy); i++ {
+		if legacy[i].Account != nil {
+			versions = append(versions, legacy[i])
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, errors.New("Could not find account " + account_id)
+	}
+
+	// transactions of one block can share a timestamp, the stable sort keeps them in ledger order
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Time.Before(versions[j].Time) })
+	return versions, nil
+}
+
+func key_history(stub shim.ChaincodeStubInterface, key string) ([]account_version, error) {
+
+	iter, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, errors.New("Could not read the history of " + key + ": " + err.Error())
+	}
+	defer iter.Close()
+
+	versions := make([]account_version, 0)
+	for iter.HasNext() {
+		mod, err := iter.Next()
+		if err != nil {
+			return nil, errors.New("Could not read the history of " + key + ": " + err.Error())
+		}
+
+		version := account_version{Tx_id: mod.TxId}
+		if mod.Timestamp != nil {
+			version.Time = mod.Timestamp.AsTime().UTC()
+		}
+
+		if !mod.IsDelete {
+			acc := Account{}
+			err = json.Unmarshal(mod.Value, &acc)
+			if err != nil {
+				return nil, errors.New("Could not decode a version of " + key + " written by " + mod.TxId)
+			}
+			upgrade_account(&acc)
+			version.Account = &acc
+		}
+
+		versions = append(versions, version)
+	}
+
+	// the peer returns the newest version first
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
+
+// ============================================================================================================================
+// read_balance_at - the balance and state of an account at a point in time <account_id, as_of, caller>
+// as_of is an RFC3339 timestamp, or a YYYY-MM-DD date for the end of that day in UTC
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_balance_at(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <account_id, as_of, caller>")
+	}
+
+	account_id := args[0]
+
+	cutoff, err := parse_as_of(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := account_history(stub, account_id)
+	if err != nil {
+		return nil, err
+	}
+
+	version := version_at(versions, cutoff)
+	if version == nil {
+		return nil, errors.New("Account " + account_id + " did not exist at " + args[1])
+	}
+
+	err = redact_incoming(stub, version.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceAsBytes, _ := json.Marshal(AccountBalanceAt{
+		Account_id: version.Account.AccountID,
+		As_of:      args[1],
+		Tx_id:      version.Tx_id,
+		Time:       version.Time.Format(time.RFC3339),
+		Balance:    version.Account.Balance,
+		Currency:   version.Account.Currency,
+		Account:    version.Account})
+	return balanceAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_guava_balance_at - the balance of every account of a guava at a point in time and their totals by currency
+// <guava_id, as_of, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_guava_balance_at(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, as_of, caller>")
+	}
+
+	cutoff, err := parse_as_of(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := guava_balances_at(stub, args[0], cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	position := GuavaBalanceAt{Guava_id: args[0], As_of: args[1], Accounts: balances, Totals: make(map[string]float64)}
+	for i := 0; i < len(balances); i++ {
+		position.Totals[balances[i].Currency] = position.Totals[balances[i].Currency] + balances[i].Balance
+	}
+
+	positionAsBytes, _ := json.Marshal(position)
+	return positionAsBytes, nil
+}
+
+// ============================================================================================================================
+// guava_balances_at - the balance of each account of a guava that existed before cutoff
+// ============================================================================================================================
+
+func guava_balances_at(stub shim.ChaincodeStubInterface, guava_id string, cutoff time.Time) ([]AccountBalance, error) {
+
+	guava, err := get_guava(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]AccountBalance, 0)
+	for i := 0; i < len(guava.Accounts); i++ {
+		versions, err := account_history(stub, strconv.FormatInt(guava.Accounts[i], 10))
+		if err != nil {
+			return nil, err
+		}
+
+		version := version_at(versions, cutoff)
+		if version == nil {
+			continue
+		}
+		balances = append(balances, AccountBalance{Account_id: version.Account.AccountID, Balance: version.Account.Balance, Currency: version.Account.Currency})
+	}
+
+	return balances, nil
+}
+
+// ============================================================================================================================
+// version_at - the last version of an account written before cutoff, nil if it did not exist then
+// ============================================================================================================================
+
+func version_at(versions []account_version, cutoff time.Time) *account_version {
+
+	var found *account_version
+	for i := 0; i < len(versions) && versions[i].Time.Before(cutoff); i++ {
+		found = &versions[i]
+	}
+
+	if found == nil || found.Account == nil {
+		return nil
+	}
+
+	return found
+}
+
+// parse_as_of returns the first instant after the point in time, a timestamp includes itself and a date its whole day
+func parse_as_of(as_of string) (time.Time, error) {
+
+	if day, err := time.Parse(StatementDateLayout, as_of); err == nil {
+		return day.AddDate(0, 0, 1), nil
+	}
+
+	at, err := time.Parse(time.RFC3339, as_of)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid as_of " + as_of + ", expecting an RFC3339 timestamp or YYYY-MM-DD")
+	}
+
+	return at.UTC().Add(time.Nanosecond), nil
+}
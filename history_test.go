@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBalanceAt(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	paid, _ := l.statement_month()
+
+	var at AccountBalanceAt
+	l.decode(l.ok("read_balance_at", "1", "2026-01-11", "dave"), &at)
+	if at.Account_id != 1 || at.Balance != 900 || at.Currency != "CAD" || at.As_of != "2026-01-11" || at.Time != "2026-01-06T10:00:00Z" || at.Tx_id == "" {
+		t.Fatalf("unexpected balance %+v", at)
+	}
+
+	// the account is returned as it was, with the payment still pending
+	if at.Account == nil || len(at.Account.OutgoingTransfer) != 2 || find_transfer(at.Account.OutgoingTransfer, paid).Status != "pending" {
+		t.Fatalf("unexpected account state %+v", at.Account)
+	}
+
+	// a timestamp includes the transaction written at that instant
+	l.decode(l.ok("read_balance_at", "1", "2026-01-12T10:00:00Z", "dave"), &at)
+	if at.Balance != 750 {
+		t.Fatalf("balance at the accept and increment = %v, want 750", at.Balance)
+	}
+	l.decode(l.ok("read_balance_at", "1", "2026-01-12T09:59:59Z", "dave"), &at)
+	if at.Balance != 900 {
+		t.Fatalf("balance just before the accept = %v, want 900", at.Balance)
+	}
+	l.decode(l.ok("read_balance_at", "1", "2026-03-01", "dave"), &at)
+	if at.Balance != 790 {
+		t.Fatalf("current balance = %v, want 790", at.Balance)
+	}
+
+	l.fail_with("Account 1 did not exist at 2026-01-04", "read_balance_at", "1", "2026-01-04", "dave")
+	l.fail_with("Invalid as_of yesterday", "read_balance_at", "1", "yesterday", "dave")
+	l.fail_with("User nobody does not have the read permission in guava 1", "read_balance_at", "1", "2026-01-11", "nobody")
+}
+
+func TestBalanceAtRedacts(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	id := l.cross_guava()
+
+	var at AccountBalanceAt
+	l.decode(l.ok("read_balance_at", "3", "2026-01-05", "frank"), &at)
+	if at.Balance != 100 || len(at.Account.IncomingTransfer) != 1 || at.Account.IncomingTransfer[0].Transfer_id != id || at.Account.IncomingTransfer[0].Creator != "" {
+		t.Fatalf("the incoming transfer from another guava was not redacted %+v", at.Account)
+	}
+}
+
+func TestGuavaBalanceAt(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.statement_month()
+
+	l.ok("create_account", "usd", "1", "USD", "US", "OPR", "40", "root")
+
+	var position GuavaBalanceAt
+	l.decode(l.ok("read_guava_balance_at", "1", "2026-01-11", "dave"), &position)
+	if position.Guava_id != "1" || len(position.Accounts) != 2 || position.Accounts[0].Balance != 900 || position.Accounts[1].Balance != 600 ||
+		len(position.Totals) != 1 || position.Totals["CAD"] != 1500 {
+		t.Fatalf("unexpected position %+v", position)
+	}
+
+	// accounts created later join once they exist
+	l.decode(l.ok("read_guava_balance_at", "1", "2026-02-02T10:00:00Z", "dave"), &position)
+	if len(position.Accounts) != 3 || position.Totals["CAD"] != 1360 || position.Totals["USD"] != 40 {
+		t.Fatalf("unexpected position %+v", position)
+	}
+
+	var empty GuavaBalanceAt
+	l.decode(l.ok("read_guava_balance_at", "1", "2026-01-01", "dave"), &empty)
+	if len(empty.Accounts) != 0 || len(empty.Totals) != 0 {
+		t.Fatalf("a guava before its accounts existed should be empty %+v", empty)
+	}
+
+	l.fail_with("User frank does not have the read permission in guava 1", "read_guava_balance_at", "1", "2026-01-11", "frank")
+}
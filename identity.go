@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Usernames in the arguments only say which user the caller acts as. Every user is bound to a client identity, the
+// fingerprint of the serialized identity (MSP id and certificate) that signs its proposals, and the router only lets
+// the caller act as a user bound to the identity that signed the transaction. The chaincode admin is the identity
+// that ran Init, it runs the functions that are not part of any guava and can bind the users of any guava.
+
+type ChaincodeAdmin struct {
+	Username string `json:"username"` //name the admin acts under
+	Identity string `json:"identity"` //client identity that ran Init
+}
+
+// ============================================================================================================================
+// caller_identity - the fingerprint of the client identity that signed the transaction proposal
+// ============================================================================================================================
+
+func caller_identity(stub shim.ChaincodeStubInterface) (string, error) {
+
+	creator, err := stub.GetCreator()
+	if err != nil || len(creator) == 0 {
+		return "", errors.New("Could not get the identity of the caller")
+	}
+
+	sum := sha256.Sum256(creator)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ============================================================================================================================
+// get_actor - load the user a caller acts as, nil if there is no such user or it is bound to another identity
+// ============================================================================================================================
+
+func get_actor(stub shim.ChaincodeStubInterface, guava_id string, username string) (*User, error) {
+
+	user, err := get_user(stub, guava_id, username)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	identity, err := caller_identity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if user.Identity == "" || strings.Compare(user.Identity, identity) != 0 {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// ============================================================================================================================
+// is_admin - check the caller is the chaincode admin acting under its own name
+// ============================================================================================================================
+
+func is_admin(stub shim.ChaincodeStubInterface, username string) (bool, error) {
+
+	admin := ChaincodeAdmin{}
+	found, err := get_record(stub, &admin, ConfigKey, "admin")
+	if err != nil || !found {
+		return false, err
+	}
+
+	identity, err := caller_identity(stub)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Compare(admin.Username, username) == 0 && strings.Compare(admin.Identity, identity) == 0, nil
+}
+
+// ============================================================================================================================
+// read_identity - the client identity of the caller, what an owner binds its user to <>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_identity(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	identity, err := caller_identity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	identityAsBytes, _ := json.Marshal(identity)
+	return identityAsBytes, nil
+}
+
+// ============================================================================================================================
+// set_user_identity - bind a user to a client identity, owners or the chaincode admin <guava_id, username, identity, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_user_identity(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, username, identity, actor>")
+	}
+
+	guava_id := args[0]
+
+	user, err := find_guava_user(stub, guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	user.Identity, err = check_identity(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return change_user(stub, guava_id, user, "identity_set", args[3])
+}
+
+// check_identity - validate the identity a user is bound to, empty binds the caller's own identity
+func check_identity(stub shim.ChaincodeStubInterface, identity string) (string, error) {
+
+	if identity == "" {
+		return caller_identity(stub)
+	}
+
+	identity = strings.ToLower(identity)
+	if decoded, err := hex.DecodeString(identity); err != nil || len(decoded) != sha256.Size {
+		return "", errors.New("Invalid identity " + identity + ", expecting the fingerprint read_identity returns")
+	}
+
+	return identity, nil
+}
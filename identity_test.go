@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestActorMustBeTheCaller(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	// naming another user does not lend the caller its rights
+	l.as("bob").fail_with("User alice does not have the owner permission in guava 1", "set_approval_policy", "1", "alice", `{}`)
+	l.as("dave").fail_with("User bob does not have the create permission in guava 1", "create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "bob", "")
+	l.as("mallory").fail_with("User carol does not have the approve permission in guava 1", "accept_transfer", "2", "1", "1", "1", "1", "carol")
+	l.as("carol").fail_with("User dave does not have the read permission in guava 1", "get_account", "1", "dave")
+
+	// functions that check the actor themselves do too
+	id := format_int(l.payment("10"))
+	l.ok("accept_transfer", "2", "1", id, "10", "10", "carol")
+	l.as("bob").fail_with("User alice may not reverse transfers of guava 1", "reverse_transfer", "1", id, "10", "alice", "r")
+}
+
+func TestReadIdentity(t *testing.T) {
+	l := new_ledger(t)
+
+	var got string
+	l.decode(l.as("frank").ok("read_identity"), &got)
+	if got != identity("frank") {
+		t.Fatalf("read_identity = %s, want %s", got, identity("frank"))
+	}
+}
+
+func TestSetUserIdentity(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	// dave moves to a new certificate, the old one stops working
+	var resp UserResponse
+	l.decode(l.ok("set_user_identity", "1", "dave", identity("dave-2027"), "alice"), &resp)
+	if resp.User.Identity != identity("dave-2027") {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	l.fail_with("User dave does not have the read permission", "get_account", "1", "dave")
+	l.as("dave-2027").ok("get_account", "1", "dave")
+
+	var history UserHistory
+	l.decode(l.ok("read_user_history", "1", "dave", "alice"), &history)
+	if last := history.Changes[len(history.Changes)-1]; last.Action != "identity_set" || last.By != "alice" || last.Identity != identity("dave-2027") {
+		t.Fatalf("unexpected history %+v", history)
+	}
+
+	// an empty identity binds the caller's own
+	l.as("bob-laptop").fail_with("does not have the owner permission", "set_user_identity", "1", "bob", "", "bob")
+	l.as("alice").ok("set_user_identity", "1", "alice", "", "alice")
+	l.ok("get_account", "1", "alice")
+
+	l.fail_with("Invalid identity", "set_user_identity", "1", "bob", "bob", "alice")
+	l.fail_with("Could not find user mallory in guava 1", "set_user_identity", "1", "mallory", identity("mallory"), "alice")
+	l.fail_with("does not have the owner permission", "set_user_identity", "1", "bob", identity("bob"), "bob")
+
+	// the chaincode admin can bind users of any guava
+	l.ok("set_user_identity", "1", "bob", identity("bob-2"), "root")
+	l.as("bob-2").ok("get_account", "1", "bob")
+}
+
+func TestCreateUserIdentity(t *testing.T) {
+	l := new_ledger(t)
+	l.init()
+	l.ok("create_account", "ops", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	var resp UserResponse
+	l.decode(l.ok("create_user", "root", "true", "true", "true", "true", "1", "", "root"), &resp)
+	if resp.User.Identity != identity("root") {
+		t.Fatalf("create_user did not bind the caller: %+v", resp.User)
+	}
+
+	l.fail_with("Invalid identity", "create_user", "bob", "false", "true", "false", "true", "1", "not-a-fingerprint", "root")
+}
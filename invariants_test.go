@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// ============================================================================================================================
+// Randomized invariant tests - long sequences of valid and invalid operations checked against a reference model
+// ============================================================================================================================
+
+const invariant_epsilon = 1e-6
+
+// model_transfer is what the test asked for, never what the chaincode reports back
+type model_transfer struct {
+	from     int64
+	to       int64
+	dec      float64
+	inc      float64
+	status   string
+	reversed float64
+	batch_id int64
+	payment  bool //paid out of the ledger, only the sender is debited
+}
+
+type model struct {
+	balances  map[int64]float64
+	currency  map[int64]string
+	transfers map[int64]*model_transfer
+	batches   []int64
+}
+
+type invariant_run struct {
+	*test_ledger
+	rnd  *rand.Rand
+	m    model
+	seed int64
+	step int
+	op   []string
+}
+
+func TestConservationOfMoney(t *testing.T) {
+	steps := 600
+	if testing.Short() {
+		steps = 150
+	}
+
+	for _, seed := range []int64{1, 7, 42, 2026, 31337} {
+		t.Run("seed="+strconv.FormatInt(seed, 10), func(t *testing.T) {
+			r := new_invariant_run(t, seed)
+			for r.step = 0; r.step < steps; r.step++ {
+				r.random_op()
+				r.check()
+			}
+		})
+	}
+}
+
+func new_invariant_run(t *testing.T, seed int64) *invariant_run {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "usd ops", "1", "USD", "US", "OPR", "800", "root")
+	l.ok("create_account", "usd savings", "1", "USD", "US", "SAVINGS", "0", "root")
+
+	return &invariant_run{
+		test_ledger: l,
+		rnd:         rand.New(rand.NewSource(seed)),
+		seed:        seed,
+		m: model{
+			balances:  map[int64]float64{1: 1000, 2: 500, 3: 800, 4: 0},
+			currency:  map[int64]string{1: "CAD", 2: "CAD", 3: "USD", 4: "USD"},
+			transfers: make(map[int64]*model_transfer)}}
+}
+
+func (r *invariant_run) fatalf(format string, args ...interface{}) {
+	r.t.Helper()
+	r.t.Fatalf("seed %d step %d %v: %s", r.seed, r.step, r.op, fmt.Sprintf(format, args...))
+}
+
+// run invokes op and reports whether it succeeded, the payload is decoded into v on success
+func (r *invariant_run) run(v interface{}, op ...string) bool {
+	r.t.Helper()
+
+	r.op = op
+	resp := r.invoke(op...)
+	if resp.Status != shim.OK {
+		return false
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(resp.Payload, v); err != nil {
+			r.fatalf("could not decode %s: %s", resp.Payload, err)
+		}
+	}
+
+	return true
+}
+
+// ============================================================================================================================
+// Random operations
+// ============================================================================================================================
+
+func (r *invariant_run) account_ids() []int64 {
+	ids := make([]int64, 0, len(r.m.balances))
+	for id := range r.m.balances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (r *invariant_run) any_account() int64 {
+	ids := r.account_ids()
+	// now and then name an account that does not exist
+	if r.rnd.Intn(20) == 0 {
+		return ids[len(ids)-1] + 1
+	}
+	return ids[r.rnd.Intn(len(ids))]
+}
+
+// any_transfer picks a transfer the test created, preferring ones in status, or an unknown id
+func (r *invariant_run) any_transfer(status string) (int64, *model_transfer) {
+	ids := make([]int64, 0)
+	for id, mt := range r.m.transfers {
+		if mt.status == status || r.rnd.Intn(10) == 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if len(ids) == 0 || r.rnd.Intn(20) == 0 {
+		return int64(len(r.m.transfers) + 100), nil
+	}
+
+	id := ids[r.rnd.Intn(len(ids))]
+	return id, r.m.transfers[id]
+}
+
+func (r *invariant_run) amount(max float64) float64 {
+	if max < 1 {
+		max = 1
+	}
+	return float64(r.rnd.Int63n(int64(max*100))+1) / 100
+}
+
+func (r *invariant_run) fx(from int64, to int64) float64 {
+	if r.m.currency[from] == r.m.currency[to] {
+		return 1
+	}
+	return []float64{0.5, 0.8, 1.25, 2}[r.rnd.Intn(4)]
+}
+
+func (r *invariant_run) user() string {
+	return []string{"alice", "bob", "carol", "dave", "erin"}[r.rnd.Intn(5)]
+}
+
+func (r *invariant_run) random_op() {
+	switch n := r.rnd.Intn(100); {
+	case n < 2:
+		r.create_account()
+	case n < 10:
+		r.increment()
+	case n < 18:
+		r.decrement()
+	case n < 45:
+		r.create_transfer()
+	case n < 63:
+		r.accept()
+	case n < 68:
+		r.reject()
+	case n < 73:
+		r.cancel()
+	case n < 83:
+		r.reverse()
+	case n < 91:
+		r.create_batch()
+	case n < 96:
+		r.accept_batch()
+	default:
+		r.reject_batch()
+	}
+}
+
+func (r *invariant_run) create_account() {
+	currency := []string{"CAD", "USD"}[r.rnd.Intn(2)]
+	initial := r.amount(500)
+
+	var resp AccountResponse
+	if r.run(&resp, "create_account", "extra", "1", currency, "CA", "OPR", format_float(initial), "alice") {
+		r.m.balances[resp.Account_id] = initial
+		r.m.currency[resp.Account_id] = currency
+	}
+}
+
+func (r *invariant_run) increment() {
+	id, value := r.any_account(), r.amount(300)
+	if r.run(nil, "increment_value", format_int(id), format_float(value), "alice") {
+		r.m.balances[id] += value
+	}
+}
+
+func (r *invariant_run) decrement() {
+	id := r.any_account()
+	value := r.amount(r.m.balances[id] * 1.2)
+	if r.run(nil, "decrement_value", format_int(id), format_float(value), "alice") {
+		r.m.balances[id] -= value
+	}
+}
+
+func (r *invariant_run) create_transfer() {
+	from, to := r.any_account(), r.any_account()
+	rate := r.fx(from, to)
+	dec := r.amount(r.m.balances[from] * 0.8)
+	inc := dec * rate
+	trans_type, payee := "internal", ""
+	if r.rnd.Intn(2) == 0 {
+		trans_type, payee = "payment", beneficiary
+		// now and then a payment without a To account
+		if r.rnd.Intn(4) == 0 {
+			to = 0
+		}
+	}
+
+	var resp TransferResponse
+	if !r.run(&resp, "create_transfer", "random", format_float(rate), format_float(inc), format_float(dec), format_int(from), format_int(to), trans_type, "t", "bob", payee) {
+		return
+	}
+
+	mt := &model_transfer{from: from, to: to, dec: dec, inc: inc, status: "pending", payment: trans_type == "payment"}
+	r.m.transfers[resp.Transfer_id] = mt
+	if resp.Status == "approved" {
+		r.settle(mt)
+	}
+}
+
+func (r *invariant_run) accept() {
+	id, mt := r.any_transfer("pending")
+	from, to, dec, inc := r.any_account(), r.any_account(), r.amount(100), r.amount(100)
+	if mt != nil && r.rnd.Intn(10) != 0 {
+		from, to, dec, inc = mt.from, mt.to, mt.dec, mt.inc
+	}
+
+	var resp TransferResponse
+	if !r.run(&resp, "accept_transfer", format_int(to), format_int(from), format_int(id), format_float(dec), format_float(inc), r.user()) {
+		return
+	}
+
+	if mt == nil || resp.Status != "approved" {
+		r.fatalf("accepted a transfer the model does not know as pending, response %+v", resp)
+	}
+	r.settle(mt)
+}
+
+func (r *invariant_run) reject() {
+	id, mt := r.any_transfer("pending")
+	if r.run(nil, "reject_transfer", format_int(r.sender(mt)), format_int(id), r.user()) {
+		r.close(mt, "rejected")
+	}
+}
+
+func (r *invariant_run) cancel() {
+	id, mt := r.any_transfer("pending")
+	if r.run(nil, "cancel_transfer", format_int(r.sender(mt)), format_int(id), r.user(), "random") {
+		r.close(mt, "cancelled")
+	}
+}
+
+func (r *invariant_run) reverse() {
+	id, mt := r.any_transfer("approved")
+	amount := r.amount(100)
+	if mt != nil && r.rnd.Intn(2) == 0 {
+		amount = mt.inc - mt.reversed
+	}
+
+	var resp TransferResponse
+	if !r.run(&resp, "reverse_transfer", format_int(r.sender(mt)), format_int(id), format_float(amount), r.user(), "random") {
+		return
+	}
+
+	if mt == nil || !(mt.status == "approved" || mt.status == "reversal") || amount > mt.inc-mt.reversed+invariant_epsilon {
+		r.fatalf("reversed %v of a transfer the model has as %+v", amount, mt)
+	}
+
+	// a payment was paid out of the ledger, its reversal comes back from outside it
+	refund := amount * mt.dec / mt.inc
+	from := mt.to
+	if mt.payment {
+		from = 0
+	} else {
+		r.m.balances[mt.to] -= amount
+	}
+	r.m.balances[mt.from] += refund
+	mt.reversed += amount
+
+	r.m.transfers[resp.Transfer_id] = &model_transfer{from: from, to: mt.from, dec: amount, inc: refund, status: "reversal"}
+}
+
+func (r *invariant_run) create_batch() {
+	type item struct {
+		Message   string          `json:"message"`
+		Fx_rate   float64         `json:"fx_rate"`
+		Inc_value float64         `json:"inc_value"`
+		Dec_value float64         `json:"dec_value"`
+		From      int64           `json:"from"`
+		To        int64           `json:"to"`
+		T_Type    string          `json:"type"`
+		Payee     json.RawMessage `json:"beneficiary,omitempty"`
+	}
+
+	items := make([]item, 1+r.rnd.Intn(4))
+	for i := range items {
+		from, to := r.any_account(), r.any_account()
+		rate := r.fx(from, to)
+		dec := r.amount(r.m.balances[from] * 0.5)
+		items[i] = item{Message: "batch", Fx_rate: rate, Inc_value: dec * rate, Dec_value: dec, From: from, To: to, T_Type: "internal"}
+		if r.rnd.Intn(2) == 0 {
+			items[i].T_Type, items[i].Payee = "payment", json.RawMessage(beneficiary)
+		}
+	}
+	items_json, _ := json.Marshal(items)
+
+	var resp BatchResponse
+	if !r.run(&resp, "create_batch_transfer", "bob", "t", string(items_json)) {
+		return
+	}
+
+	if len(resp.Transfer_ids) != len(items) {
+		r.fatalf("batch of %d items returned %d transfer ids", len(items), len(resp.Transfer_ids))
+	}
+
+	r.m.batches = append(r.m.batches, resp.Batch_id)
+	for i, it := range items {
+		mt := &model_transfer{from: it.From, to: it.To, dec: it.Dec_value, inc: it.Inc_value, status: "pending", batch_id: resp.Batch_id, payment: it.T_Type == "payment"}
+		r.m.transfers[resp.Transfer_ids[i]] = mt
+		// internal transfers across currencies wait for approval
+		if it.T_Type == "internal" && r.m.currency[it.From] == r.m.currency[it.To] {
+			r.settle(mt)
+		}
+	}
+}
+
+func (r *invariant_run) any_batch() int64 {
+	if len(r.m.batches) == 0 || r.rnd.Intn(20) == 0 {
+		return int64(len(r.m.batches) + 100)
+	}
+	return r.m.batches[r.rnd.Intn(len(r.m.batches))]
+}
+
+func (r *invariant_run) accept_batch() {
+	batch_id := r.any_batch()
+	if !r.run(nil, "accept_batch", format_int(batch_id), r.user()) {
+		return
+	}
+
+	for _, mt := range r.m.transfers {
+		if mt.batch_id == batch_id && mt.status == "pending" {
+			r.settle(mt)
+		}
+	}
+}
+
+func (r *invariant_run) reject_batch() {
+	batch_id := r.any_batch()
+	if !r.run(nil, "reject_batch", format_int(batch_id), r.user()) {
+		return
+	}
+
+	for _, mt := range r.m.transfers {
+		if mt.batch_id == batch_id && mt.status == "pending" {
+			mt.status = "rejected"
+		}
+	}
+}
+
+func (r *invariant_run) sender(mt *model_transfer) int64 {
+	if mt == nil || r.rnd.Intn(10) == 0 {
+		return r.any_account()
+	}
+	return mt.from
+}
+
+func (r *invariant_run) settle(mt *model_transfer) {
+	if mt.status != "pending" {
+		r.fatalf("settled a transfer the model has as %+v", mt)
+	}
+
+	mt.status = "approved"
+	r.m.balances[mt.from] -= mt.dec
+	if !mt.payment {
+		r.m.balances[mt.to] += mt.inc
+	}
+}
+
+func (r *invariant_run) close(mt *model_transfer, status string) {
+	if mt == nil || mt.status != "pending" {
+		r.fatalf("%s a transfer the model has as %+v", status, mt)
+	}
+	mt.status = status
+}
+
+// ============================================================================================================================
+// Invariants
+// ============================================================================================================================
+
+func settled(status string) bool {
+	return status == "approved" || status == "partially_reversed" || status == "reversed"
+}
+
+func (r *invariant_run) check() {
+	r.t.Helper()
+
+	accounts := make(map[int64]*Account)
+	totals := make(map[string]float64)
+	expected := make(map[string]float64)
+
+	for _, id := range r.account_ids() {
+		acc := r.account(id)
+		accounts[id] = acc
+		totals[acc.Currency] += acc.Balance
+		expected[r.m.currency[id]] += r.m.balances[id]
+
+		if acc.Balance < -invariant_epsilon {
+			r.fatalf("account %d has a negative balance %v", id, acc.Balance)
+		}
+		if math.Abs(acc.Balance-r.m.balances[id]) > invariant_epsilon {
+			r.fatalf("account %d balance = %v, model has %v", id, acc.Balance, r.m.balances[id])
+		}
+	}
+
+	for currency, total := range expected {
+		if math.Abs(totals[currency]-total) > invariant_epsilon {
+			r.fatalf("total %s = %v, model has %v", currency, totals[currency], total)
+		}
+	}
+
+	// every settled transfer is held by both accounts, identically, and moved exactly its values
+	// payments are only held by the sender and the reversals of payments only by the account they refund
+	flows := make(map[int64]float64)
+	for id, acc := range accounts {
+		for _, out := range acc.OutgoingTransfer {
+			if !settled(out.Status) {
+				continue
+			}
+			flows[out.From] -= out.Dec_value
+			if out.T_Type == PaymentTransfer {
+				continue
+			}
+			in := find_transfer(accounts[out.To].IncomingTransfer, out.Transfer_id)
+			if in == nil || !reflect.DeepEqual(*in, out) {
+				r.fatalf("transfer %d of account %d differs from its incoming copy on %d: %+v vs %+v", out.Transfer_id, id, out.To, out, in)
+			}
+			flows[out.To] += out.Inc_value
+		}
+
+		for _, in := range acc.IncomingTransfer {
+			if !settled(in.Status) || in.To != id || in.T_Type == PaymentTransfer {
+				r.fatalf("account %d holds an incoming transfer that was not settled to it: %+v", id, in)
+			}
+			if in.From == 0 {
+				flows[in.To] += in.Inc_value
+				continue
+			}
+			if find_transfer(accounts[in.From].OutgoingTransfer, in.Transfer_id) == nil {
+				r.fatalf("incoming transfer %d of account %d has no outgoing copy on %d", in.Transfer_id, id, in.From)
+			}
+		}
+	}
+
+	for id, mt := range r.m.transfers {
+		held, holder := mt.from, accounts[mt.from]
+		if mt.from == 0 {
+			held, holder = mt.to, accounts[mt.to]
+		}
+		if holder == nil {
+			r.fatalf("transfer %d was sent from unknown account %d", id, held)
+		}
+		out := find_transfer(holder.OutgoingTransfer, id)
+		if mt.from == 0 {
+			out = find_transfer(holder.IncomingTransfer, id)
+		}
+		if out == nil || out.Dec_value != mt.dec || out.Inc_value != mt.inc || out.To != mt.to {
+			r.fatalf("transfer %d on the ledger %+v does not match the model %+v", id, out, mt)
+		}
+		if settled(out.Status) != (mt.status == "approved" || mt.status == "reversal") {
+			r.fatalf("transfer %d is %s on the ledger and %s in the model", id, out.Status, mt.status)
+		}
+	}
+
+	// balances are what was minted less what was burned plus the settled flows
+	mints := r.minted()
+	for id, acc := range accounts {
+		if math.Abs(mints[id]+flows[id]-acc.Balance) > invariant_epsilon {
+			r.fatalf("account %d balance %v is not explained by %v minted and %v of transfers", id, acc.Balance, mints[id], flows[id])
+		}
+	}
+}
+
+// minted is each account's balance in the model with every settled transfer undone
+func (r *invariant_run) minted() map[int64]float64 {
+	mints := make(map[int64]float64)
+	for id, balance := range r.m.balances {
+		mints[id] = balance
+	}
+
+	for _, mt := range r.m.transfers {
+		if mt.status == "approved" || mt.status == "reversal" {
+			mints[mt.from] += mt.dec
+			if !mt.payment {
+				mints[mt.to] -= mt.inc
+			}
+		}
+	}
+
+	return mints
+}
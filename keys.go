@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Every record is stored under a composite key whose object type names the kind of record, so ids of
+// different kinds can not collide and nothing shares a namespace with configuration values.
+
+const AccountKey = "account"                // <account_id> Account
+const TransferIndexKey = "transfer_index"   // <transfer_id> TransferIndex, the transfer itself is held by its accounts
+const GuavaKey = "guava"                    // <guava_id> Guava
+const UserKey = "user"                      // <guava_id, username> User
+const WhitelistKey = "whitelist"            // <guava_id> CounterpartyWhitelist
+const UserHistoryKey = "user_history"       // <guava_id, username> UserHistory, kept after the user is removed
+const BatchKey = "batch"                    // <batch_id> Batch
+const FxRatesKey = "fx_rates"               // <guava_id> FxRates
+const LimitsKey = "limits"                  // <guava_id, scope, subject> TransferLimits
+const ExportKey = "payment_export"          // <export_id> PaymentExport
+const PendingInboundKey = "pending_inbound" // <guava_id, transfer_id> TransferIndex of a pending transfer to an account of the guava
+const UsageKey = "limit_usage"              // <guava_id, scope, subject> LimitUsage
+const PolicyKey = "policy"                  // <guava_id> ApprovalPolicy
+const RoleKey = "role"                      // <guava_id, role> Role
+const StatementKey = "statement"            // <guava_id, statement_id> StatementImport
+const TTLKey = "ttl"                        // <guava_id> TransferTTLs
+const ConfigKey = "config"                  // <name> plain values, the next id counters, the init value and the chaincode admin
+
+// every kind of versioned record, in key order
+var RecordKinds = []string{AccountKey, BatchKey, FxRatesKey, GuavaKey, UsageKey, LimitsKey, ExportKey, PendingInboundKey, PolicyKey, RoleKey, StatementKey, TransferIndexKey, TTLKey, UserKey, UserHistoryKey, WhitelistKey}
+
+// the counter handing out the ids of the kinds keyed by an id, every other kind is keyed by its guava first
+var IdCounters = map[string]string{AccountKey: "account", BatchKey: "batch", ExportKey: "export", TransferIndexKey: "transfer"}
+
+type Guava struct {
+	Guava_id string  `json:"guava_id"` //unique identifier for guava
+	Accounts []int64 `json:"accounts"` //accounts created in the guava in creation order
+	Schema   int     `json:"schema"`   //layout version the guava was written with
+}
+
+type TransferIndex struct {
+	Transfer_id int64 `json:"transfer_id"` //unique identifier for transfer
+	From        int64 `json:"from"`        //account holding the transfer in its outgoing transfers
+	To          int64 `json:"to"`          //account the transfer is to
+	Schema      int   `json:"schema"`      //layout version the index was written with
+}
+
+// ============================================================================================================================
+// make_key - the composite key of a record
+// ============================================================================================================================
+
+func make_key(stub shim.ChaincodeStubInterface, kind string, attributes ...string) (string, error) {
+
+	key, err := stub.CreateCompositeKey(kind, attributes)
+	if err != nil {
+		return "", errors.New("Invalid " + kind + " key: " + err.Error())
+	}
+
+	return key, nil
+}
+
+// ============================================================================================================================
+// get_record / put_record - load and store the JSON record under a composite key, get_record reports whether it exists
+// ============================================================================================================================
+
+func get_record(stub shim.ChaincodeStubInterface, record interface{}, kind string, attributes ...string) (bool, error) {
+
+	key, err := make_key(stub, kind, attributes...)
+	if err != nil {
+		return false, err
+	}
+
+	recordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, errors.New("Failed to get " + kind + " " + strconv.Quote(key))
+	}
+	if recordAsBytes == nil {
+		return false, nil
+	}
+
+	err = json.Unmarshal(recordAsBytes, record)
+	if err != nil {
+		return false, errors.New("Could not decode " + kind + " " + strconv.Quote(key))
+	}
+
+	return true, nil
+}
+
+func put_record(stub shim.ChaincodeStubInterface, record interface{}, kind string, attributes ...string) error {
+
+	key, err := make_key(stub, kind, attributes...)
+	if err != nil {
+		return err
+	}
+
+	recordAsBytes, _ := json.Marshal(record)
+	return stub.PutState(key, recordAsBytes)
+}
+
+// ============================================================================================================================
+// next_ids - reserve count ids from a counter and return the first, ids start at 1
+// the peer does not show a transaction its own writes, so a function reserves all the ids it needs from a counter at once
+// ============================================================================================================================
+
+func next_ids(stub shim.ChaincodeStubInterface, counter string, count int64) (int64, error) {
+
+	next, err := get_counter(stub, counter)
+	if err != nil {
+		return 0, err
+	}
+
+	err = put_counter(stub, counter, next+count)
+	if err != nil {
+		return 0, err
+	}
+
+	return next, nil
+}
+
+// get_counter / put_counter - load and store the next id of a counter
+func get_counter(stub shim.ChaincodeStubInterface, counter string) (int64, error) {
+
+	key, err := make_key(stub, ConfigKey, "next_"+counter)
+	if err != nil {
+		return 0, err
+	}
+
+	nextAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return 0, errors.New("Failed to get the next " + counter + " id")
+	}
+	if nextAsBytes == nil {
+		return 1, nil
+	}
+
+	next, err := strconv.ParseInt(string(nextAsBytes), 10, 64)
+	if err != nil {
+		return 0, errors.New("Could not decode the next " + counter + " id")
+	}
+
+	return next, nil
+}
+
+func put_counter(stub shim.ChaincodeStubInterface, counter string, next int64) error {
+
+	key, err := make_key(stub, ConfigKey, "next_"+counter)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutState(key, []byte(strconv.FormatInt(next, 10)))
+}
+
+// ============================================================================================================================
+// raise_counter - make sure a counter does not hand out ids below next, for records moved in with the ids they already had
+// ============================================================================================================================
+
+func raise_counter(stub shim.ChaincodeStubInterface, counter string, next int64) error {
+
+	current, err := get_counter(stub, counter)
+	if err != nil {
+		return err
+	}
+	if current >= next {
+		return nil
+	}
+
+	return put_counter(stub, counter, next)
+}
+
+// ============================================================================================================================
+// get_guava / put_guava - load and store guava records
+// ============================================================================================================================
+
+func get_guava(stub shim.ChaincodeStubInterface, guava_id string) (*Guava, error) {
+
+	guava := Guava{}
+	found, err := get_record(stub, &guava, GuavaKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Could not find guava " + guava_id)
+	}
+	upgrade_guava(&guava)
+
+	return &guava, nil
+}
+
+func put_guava(stub shim.ChaincodeStubInterface, guava *Guava) error {
+	return put_record(stub, guava, GuavaKey, guava.Guava_id)
+}
+
+// ============================================================================================================================
+// get_user / put_user - load and store the users of a guava, get_user returns nil if the user is not there
+// ============================================================================================================================
+
+func get_user(stub shim.ChaincodeStubInterface, guava_id string, username string) (*User, error) {
+
+	user := User{}
+	found, err := get_record(stub, &user, UserKey, guava_id, username)
+	if err != nil || !found {
+		return nil, err
+	}
+	upgrade_user(&user)
+
+	return &user, nil
+}
+
+func put_user(stub shim.ChaincodeStubInterface, guava_id string, user *User) error {
+	return put_record(stub, user, UserKey, guava_id, user.Username)
+}
+
+// ============================================================================================================================
+// get_users - load every user of a guava in username order
+// ============================================================================================================================
+
+func get_users(stub shim.ChaincodeStubInterface, guava_id string) ([]User, error) {
+
+	iter, err := stub.GetStateByPartialCompositeKey(UserKey, []string{guava_id})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	users := make([]User, 0)
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		user := User{}
+		err = json.Unmarshal(kv.Value, &user)
+		if err != nil {
+			return nil, errors.New("Could not decode a user of guava " + guava_id)
+		}
+		upgrade_user(&user)
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// ============================================================================================================================
+// get_transfer_index / put_transfer_index - find the accounts holding a transfer by its id
+// ============================================================================================================================
+
+func get_transfer_index(stub shim.ChaincodeStubInterface, transfer_id string) (*TransferIndex, error) {
+
+	index := TransferIndex{}
+	found, err := get_record(stub, &index, TransferIndexKey, transfer_id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Could not find transfer " + transfer_id)
+	}
+	upgrade_transfer_index(&index)
+
+	return &index, nil
+}
+
+func put_transfer_index(stub shim.ChaincodeStubInterface, transl *Transfer) error {
+
+	index := TransferIndex{Transfer_id: transl.Transfer_id, From: transl.From, To: transl.To, Schema: TransferIndexSchema}
+	return put_record(stub, &index, TransferIndexKey, strconv.FormatInt(transl.Transfer_id, 10))
+}
+
+// ============================================================================================================================
+// put_pending_inbound / del_pending_inbound - index a pending transfer under the guava of the account it will credit, so
+// the guava finds what is on its way to it without reading every transfer. Payments credit no account and are not indexed
+// ============================================================================================================================
+
+func put_pending_inbound(stub shim.ChaincodeStubInterface, guava_id string, transl *Transfer) error {
+
+	if !credits_receiver(transl) {
+		return nil
+	}
+
+	index := TransferIndex{Transfer_id: transl.Transfer_id, From: transl.From, To: transl.To, Schema: TransferIndexSchema}
+	return put_record(stub, &index, PendingInboundKey, guava_id, strconv.FormatInt(transl.Transfer_id, 10))
+}
+
+// del_pending_inbound is called as a transfer leaves pending, accounts supplies the account it was to
+func del_pending_inbound(stub shim.ChaincodeStubInterface, accounts account_cache, transl *Transfer) error {
+
+	if !credits_receiver(transl) {
+		return nil
+	}
+
+	to_acc, err := accounts.get(stub, transl.To)
+	if err != nil {
+		return err
+	}
+
+	key, err := make_key(stub, PendingInboundKey, to_acc.Guava_id, strconv.FormatInt(transl.Transfer_id, 10))
+	if err != nil {
+		return err
+	}
+
+	return stub.DelState(key)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecordKeys(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.payment("10")
+
+	// every record lives under a composite key, none share the plain key space
+	for key := range l.stub.State {
+		if !strings.HasPrefix(key, "\x00") {
+			t.Fatalf("record stored under plain key %q", key)
+		}
+	}
+
+	// account 1 and guava 1 are separate records
+	var acc Account
+	l.decode(l.state(AccountKey, "1"), &acc)
+	var guava Guava
+	l.decode(l.state(GuavaKey, "1"), &guava)
+	if acc.AccountID != 1 || acc.Guava_id != "1" || guava.Guava_id != "1" || len(guava.Accounts) != 2 {
+		t.Fatalf("unexpected account %+v and guava %+v", acc, guava)
+	}
+
+	var index TransferIndex
+	l.decode(l.state(TransferIndexKey, "1"), &index)
+	if index.From != 1 || index.To != 2 || index.Schema != TransferIndexSchema {
+		t.Fatalf("unexpected transfer index %+v", index)
+	}
+}
+
+func TestNextIds(t *testing.T) {
+	l := new_ledger(t)
+
+	l.stub.MockTransactionStart("ids")
+	first, err := next_ids(l.stub, "transfer", 3)
+	if err != nil || first != 1 {
+		t.Fatalf("first reservation = %d, %v", first, err)
+	}
+	next, err := next_ids(l.stub, "transfer", 1)
+	if err != nil || next != 4 {
+		t.Fatalf("second reservation = %d, %v", next, err)
+	}
+	if other, _ := next_ids(l.stub, "batch", 1); other != 1 {
+		t.Fatalf("counters are not separate, batch got %d", other)
+	}
+
+	// raising never lowers a counter
+	if err := raise_counter(l.stub, "transfer", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := raise_counter(l.stub, "account", 7); err != nil {
+		t.Fatal(err)
+	}
+	l.stub.MockTransactionEnd("ids")
+
+	if got := string(l.state(ConfigKey, "next_transfer")); got != "5" {
+		t.Fatalf("next_transfer = %s, want 5", got)
+	}
+	if got := string(l.state(ConfigKey, "next_account")); got != "7" {
+		t.Fatalf("next_account = %s, want 7", got)
+	}
+}
+
+func TestGetters(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	id := l.payment("10")
+
+	var transl Transfer
+	l.decode(l.ok("get_transfer", format_int(id), "dave"), &transl)
+	if transl.Transfer_id != id || transl.From != 1 || transl.To != 2 || transl.Status != "pending" {
+		t.Fatalf("unexpected transfer %+v", transl)
+	}
+
+	var guava Guava
+	l.decode(l.ok("get_guava", "1", "dave"), &guava)
+	if guava.Guava_id != "1" || len(guava.Accounts) != 2 {
+		t.Fatalf("unexpected guava %+v", guava)
+	}
+
+	l.fail_with("Could not find the guava of account 9", "get_account", "9", "dave")
+	l.fail_with("Could not find transfer 9", "get_transfer", "9", "dave")
+	l.fail_with("Could not find user mallory in guava 1", "get_user", "1", "mallory", "alice")
+	l.fail_with("Received unknown function", "read", "1")
+}
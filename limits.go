@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Transfer limits are set per guava, per account and per user. A transfer is checked against the limits of its
+// sending guava, its sending account and its creator when it is created, and counted against them when it settles.
+// Amounts are dec_values in the sending account's currency and periods are calendar days and months of the
+// transaction timestamp in UTC. Usage is only kept for subjects that have limits set.
+
+var LimitScopes = []string{"guava", "account", "user"}
+
+type TransferLimits struct {
+	Guava_id      string  `json:"guava_id"`
+	Scope         string  `json:"scope"`         //what the limits apply to <guava, account, user>
+	Subject       string  `json:"subject"`       //the guava id, account id or username
+	Single        float64 `json:"single"`        //largest single transfer, 0 for no limit
+	Daily         float64 `json:"daily"`         //total transferred in a day, 0 for no limit
+	Monthly       float64 `json:"monthly"`       //total transferred in a month, 0 for no limit
+	Daily_count   int64   `json:"daily_count"`   //transfers in a day, 0 for no limit
+	Monthly_count int64   `json:"monthly_count"` //transfers in a month, 0 for no limit
+	Schema        int     `json:"schema"`        //layout version the limits were written with
+}
+
+type LimitUsage struct {
+	Guava_id     string  `json:"guava_id"`
+	Scope        string  `json:"scope"`
+	Subject      string  `json:"subject"`
+	Day          string  `json:"day"` //day the day totals are for, 2006-01-02
+	Day_amount   float64 `json:"day_amount"`
+	Day_count    int64   `json:"day_count"`
+	Month        string  `json:"month"` //month the month totals are for, 2006-01
+	Month_amount float64 `json:"month_amount"`
+	Month_count  int64   `json:"month_count"`
+	Schema       int     `json:"schema"` //layout version the usage was written with
+}
+
+type LimitView struct {
+	Limits TransferLimits `json:"limits"`
+	Usage  LimitUsage     `json:"usage"` //usage in the current day and month of the transaction timestamp
+}
+
+// ============================================================================================================================
+// set_transfer_limits - set the transfer limits of a guava, an account of it or one of its users, owners only
+// <guava_id, scope, subject, limits_json, owner>, limits_json is {"single", "daily", "monthly", "daily_count", "monthly_count"}
+// scope is guava, account or user, the subject of a guava scope is the guava id
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_transfer_limits(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5 arguments <guava_id, scope, subject, limits_json, owner>")
+	}
+
+	var limits TransferLimits
+
+	err := json.Unmarshal([]byte(args[3]), &limits)
+	if err != nil {
+		return nil, errors.New("Could not parse limits_json: " + err.Error())
+	}
+
+	if limits.Single < 0 || limits.Daily < 0 || limits.Monthly < 0 || limits.Daily_count < 0 || limits.Monthly_count < 0 {
+		return nil, errors.New("Transfer limits can not be negative")
+	}
+
+	limits.Guava_id = args[0]
+	limits.Scope = args[1]
+	limits.Subject = args[2]
+	limits.Schema = LimitsSchema
+
+	err = check_limit_subject(stub, limits.Guava_id, limits.Scope, limits.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_record(stub, &limits, LimitsKey, limits.Guava_id, limits.Scope, limits.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(LimitsResponse{Version: ApiVersion, Guava_id: limits.Guava_id, Limits: &limits})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_limit_usage - read the limits of a guava, account or user and what has been used of them <guava_id, scope, subject, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_limit_usage(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, scope, subject, caller>")
+	}
+
+	err := check_limit_subject(stub, args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	checker, err := new_limit_checker(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, usage, err := checker.load(stub, args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	view := LimitView{Limits: TransferLimits{Guava_id: args[0], Scope: args[1], Subject: args[2], Schema: LimitsSchema}, Usage: *usage}
+	if limits != nil {
+		view.Limits = *limits
+	}
+
+	viewAsBytes, _ := json.Marshal(view)
+	return viewAsBytes, nil
+}
+
+// ============================================================================================================================
+// check_limit_subject - check the subject of limits is the guava itself, one of its accounts or one of its users
+// ============================================================================================================================
+
+func check_limit_subject(stub shim.ChaincodeStubInterface, guava_id string, scope string, subject string) error {
+
+	switch scope {
+	case "guava":
+		if strings.Compare(subject, guava_id) != 0 {
+			return errors.New("The subject of guava limits must be the guava id " + guava_id)
+		}
+
+	case "account":
+		acc, err := get_account(stub, subject)
+		if err != nil || strings.Compare(acc.Guava_id, guava_id) != 0 {
+			return errors.New("Account " + subject + " is not in guava " + guava_id)
+		}
+
+	case "user":
+		_, err := find_guava_user(stub, guava_id, subject)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return errors.New("Unknown limit scope " + scope + ", expecting one of " + strings.Join(LimitScopes, ", "))
+	}
+
+	return nil
+}
+
+// limit_checker checks transfers against their limits and counts settled transfers within one transaction.
+// The peer does not show a transaction its own writes, so usage is held here and written once by put_all.
+type limit_checker struct {
+	now     time.Time
+	limits  map[string]*TransferLimits
+	usage   map[string]*LimitUsage
+	changed []string
+}
+
+func new_limit_checker(stub shim.ChaincodeStubInterface) (*limit_checker, error) {
+
+	now, err := tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limit_checker{now: now.UTC(), limits: make(map[string]*TransferLimits), usage: make(map[string]*LimitUsage)}, nil
+}
+
+// ============================================================================================================================
+// load - the limits of a subject, nil if it has none, and its usage in the current day and month
+// ============================================================================================================================
+
+func (c *limit_checker) load(stub shim.ChaincodeStubInterface, guava_id string, scope string, subject string) (*TransferLimits, *LimitUsage, error) {
+
+	key, err := make_key(stub, LimitsKey, guava_id, scope, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if usage, ok := c.usage[key]; ok {
+		return c.limits[key], usage, nil
+	}
+
+	limits := TransferLimits{}
+	found, err := get_record(stub, &limits, LimitsKey, guava_id, scope, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if found {
+		upgrade_limits(&limits)
+		c.limits[key] = &limits
+	}
+
+	usage := LimitUsage{Guava_id: guava_id, Scope: scope, Subject: subject, Schema: UsageSchema}
+	_, err = get_record(stub, &usage, UsageKey, guava_id, scope, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	upgrade_usage(&usage)
+
+	day := c.now.Format("2006-01-02")
+	if strings.Compare(usage.Day, day) != 0 {
+		usage.Day = day
+		usage.Day_amount = 0
+		usage.Day_count = 0
+	}
+	month := c.now.Format("2006-01")
+	if strings.Compare(usage.Month, month) != 0 {
+		usage.Month = month
+		usage.Month_amount = 0
+		usage.Month_count = 0
+	}
+	c.usage[key] = &usage
+
+	return c.limits[key], &usage, nil
+}
+
+// ============================================================================================================================
+// check - fail if a transfer from an account would go over any limit of the guava, the account or the creator
+// ============================================================================================================================
+
+func (c *limit_checker) check(stub shim.ChaincodeStubInterface, from_acc *Account, transl *Transfer) error {
+	return c.apply(stub, from_acc, transl, false)
+}
+
+// ============================================================================================================================
+// settle - check a transfer that is settling and count it against every limit it falls under
+// ============================================================================================================================
+
+func (c *limit_checker) settle(stub shim.ChaincodeStubInterface, from_acc *Account, transl *Transfer) error {
+	return c.apply(stub, from_acc, transl, true)
+}
+
+func (c *limit_checker) apply(stub shim.ChaincodeStubInterface, from_acc *Account, transl *Transfer, count bool) error {
+
+	guava_id := from_acc.Guava_id
+	scopes := [][2]string{{"guava", guava_id}, {"account", strconv.FormatInt(from_acc.AccountID, 10)}}
+	if transl.Creator != "" {
+		scopes = append(scopes, [2]string{"user", transl.Creator})
+	}
+
+	amount := transl.Dec_value
+	amount_str := format_float(amount)
+
+	usages := make([]*LimitUsage, 0, len(scopes))
+	for i := 0; i < len(scopes); i++ {
+		limits, usage, err := c.load(stub, guava_id, scopes[i][0], scopes[i][1])
+		if err != nil {
+			return err
+		}
+		if limits == nil {
+			continue
+		}
+
+		of := " of " + scopes[i][0] + " " + scopes[i][1]
+		if limits.Single > 0 && amount > limits.Single {
+			return errors.New("Transfer of " + amount_str + " exceeds the single transfer limit" + of + " of " + format_float(limits.Single))
+		}
+		if limits.Daily > 0 && usage.Day_amount+amount > limits.Daily {
+			return errors.New("Transfer of " + amount_str + " exceeds the daily limit" + of + ", " + format_float(usage.Day_amount) + " of " + format_float(limits.Daily) + " used")
+		}
+		if limits.Monthly > 0 && usage.Month_amount+amount > limits.Monthly {
+			return errors.New("Transfer of " + amount_str + " exceeds the monthly limit" + of + ", " + format_float(usage.Month_amount) + " of " + format_float(limits.Monthly) + " used")
+		}
+		if limits.Daily_count > 0 && usage.Day_count >= limits.Daily_count {
+			return errors.New("Transfer exceeds the daily count limit" + of + ", " + format_int(usage.Day_count) + " of " + format_int(limits.Daily_count) + " transfers used")
+		}
+		if limits.Monthly_count > 0 && usage.Month_count >= limits.Monthly_count {
+			return errors.New("Transfer exceeds the monthly count limit" + of + ", " + format_int(usage.Month_count) + " of " + format_int(limits.Monthly_count) + " transfers used")
+		}
+		usages = append(usages, usage)
+	}
+
+	// only count once every limit has passed
+	for i := 0; count && i < len(usages); i++ {
+		usage := usages[i]
+		usage.Day_amount = usage.Day_amount + amount
+		usage.Day_count = usage.Day_count + 1
+		usage.Month_amount = usage.Month_amount + amount
+		usage.Month_count = usage.Month_count + 1
+
+		key, _ := make_key(stub, LimitsKey, usage.Guava_id, usage.Scope, usage.Subject)
+		if !contains_string(c.changed, key) {
+			c.changed = append(c.changed, key)
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// put_all - write back the usage of every subject a settled transfer was counted against
+// ============================================================================================================================
+
+func (c *limit_checker) put_all(stub shim.ChaincodeStubInterface) error {
+
+	for i := 0; i < len(c.changed); i++ {
+		usage := c.usage[c.changed[i]]
+		err := put_record(stub, usage, UsageKey, usage.Guava_id, usage.Scope, usage.Subject)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func contains_string(list []string, value string) bool {
+
+	for i := 0; i < len(list); i++ {
+		if strings.Compare(list[i], value) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func (l *test_ledger) internal(amount string, creator string) {
+	l.t.Helper()
+	l.ok("create_transfer", "sweep", "1", amount, amount, "1", "2", "internal", "t", creator, "")
+}
+
+func TestSingleTransferLimit(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp LimitsResponse
+	l.decode(l.ok("set_transfer_limits", "1", "account", "1", `{"single":100}`, "alice"), &resp)
+	if resp.Limits.Scope != "account" || resp.Limits.Subject != "1" || resp.Limits.Single != 100 || resp.Limits.Schema != LimitsSchema {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.fail_with("Transfer of 150 exceeds the single transfer limit of account 1 of 100", "create_transfer", "m", "1", "150", "150", "1", "2", "payment", "t", "bob", beneficiary)
+	l.payment("100")
+
+	// the limit is on the sending account
+	l.ok("create_transfer", "back", "1", "150", "150", "2", "1", "internal", "t", "bob", "")
+}
+
+func TestDailyAndMonthlyLimits(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("increment_value", "1", "1000", "alice")
+
+	l.ok("set_transfer_limits", "1", "guava", "1", `{"daily":300, "monthly":500}`, "alice")
+
+	l.internal("200", "bob")
+	l.fail_with("Transfer of 150 exceeds the daily limit of guava 1, 200 of 300 used", "create_transfer", "m", "1", "150", "150", "1", "2", "payment", "t", "bob", beneficiary)
+
+	// a pending payment only counts once it settles, so two can be created but only one accepted
+	first := l.payment("100")
+	second := l.payment("100")
+	l.ok("accept_transfer", "2", "1", format_int(first), "100", "100", "carol")
+	l.fail_with("exceeds the daily limit of guava 1, 300 of 300 used", "accept_transfer", "2", "1", format_int(second), "100", "100", "carol")
+
+	// the next day the daily limit starts again but the monthly one carries on
+	l.now = l.now.Add(24 * time.Hour)
+	l.ok("accept_transfer", "2", "1", format_int(second), "100", "100", "carol")
+	l.fail_with("exceeds the monthly limit of guava 1, 400 of 500 used", "create_transfer", "m", "1", "150", "150", "1", "2", "internal", "t", "bob", "")
+
+	l.now = time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+	l.internal("150", "bob")
+}
+
+func TestCountLimits(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_transfer_limits", "1", "user", "bob", `{"daily_count":2}`, "alice")
+
+	l.internal("1", "bob")
+	l.internal("1", "bob")
+	l.fail_with("Transfer exceeds the daily count limit of user bob, 2 of 2 transfers used", "create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "bob", "")
+
+	// other users are not limited
+	l.internal("1", "alice")
+
+	// a batch counts every item
+	l.now = l.now.Add(24 * time.Hour)
+	l.fail_with("batch item 2: Transfer exceeds the daily count limit of user bob", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":1, "dec_value":1, "from":1, "to":2, "type":"internal"},
+		{"inc_value":1, "dec_value":1, "from":1, "to":2, "type":"internal"},
+		{"inc_value":1, "dec_value":1, "from":1, "to":2, "type":"internal"}]`)
+	l.balance(1, 997)
+}
+
+func TestLimitsInBatches(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_transfer_limits", "1", "account", "1", `{"daily":100}`, "alice")
+
+	var resp BatchResponse
+	l.decode(l.ok("create_batch_transfer", "bob", "t", `[
+		{"inc_value":60, "dec_value":60, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}},
+		{"inc_value":60, "dec_value":60, "from":1, "to":2, "type":"payment", "beneficiary":{"name":"Acme Supplies", "iban":"GB82 WEST 1234 5698 7654 32", "bic":"NWBKGB2L"}}]`), &resp)
+
+	// both fit on their own but not together
+	l.fail_with("transfer 2: Transfer of 60 exceeds the daily limit of account 1, 60 of 100 used", "accept_batch", format_int(resp.Batch_id), "carol")
+	l.balance(1, 1000)
+}
+
+func TestReadLimitUsage(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_transfer_limits", "1", "account", "1", `{"daily":500, "monthly_count":10}`, "alice")
+	l.internal("120", "bob")
+	l.internal("30", "bob")
+
+	var view LimitView
+	l.decode(l.ok("read_limit_usage", "1", "account", "1", "dave"), &view)
+	if view.Limits.Daily != 500 || view.Limits.Monthly_count != 10 {
+		t.Fatalf("unexpected limits %+v", view.Limits)
+	}
+	if view.Usage.Day != "2026-01-05" || view.Usage.Day_amount != 150 || view.Usage.Day_count != 2 || view.Usage.Month != "2026-01" || view.Usage.Month_count != 2 {
+		t.Fatalf("unexpected usage %+v", view.Usage)
+	}
+
+	// usage reads in the day and month of the transaction
+	l.now = l.now.Add(24 * time.Hour)
+	l.decode(l.ok("read_limit_usage", "1", "account", "1", "dave"), &view)
+	if view.Usage.Day_amount != 0 || view.Usage.Month_amount != 150 {
+		t.Fatalf("unexpected usage the next day %+v", view.Usage)
+	}
+
+	// subjects without limits are not tracked
+	l.decode(l.ok("read_limit_usage", "1", "user", "bob", "dave"), &view)
+	if view.Limits.Daily != 0 || view.Usage.Month_count != 0 {
+		t.Fatalf("unexpected untracked view %+v", view)
+	}
+
+	l.fail_with("Unknown limit scope team", "read_limit_usage", "1", "team", "x", "dave")
+	l.fail_with("does not have the read permission", "read_limit_usage", "1", "account", "1", "mallory")
+}
+
+func TestSetTransferLimitsErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	l.fail_with("Transfer limits can not be negative", "set_transfer_limits", "1", "account", "1", `{"daily":-1}`, "alice")
+	l.fail_with("Could not parse limits_json", "set_transfer_limits", "1", "account", "1", `{"daily":"lots"}`, "alice")
+	l.fail_with("The subject of guava limits must be the guava id 1", "set_transfer_limits", "1", "guava", "2", `{}`, "alice")
+	l.fail_with("Account 3 is not in guava 1", "set_transfer_limits", "1", "account", "3", `{}`, "alice")
+	l.fail_with("Could not find user mallory in guava 1", "set_transfer_limits", "1", "user", "mallory", `{}`, "alice")
+	l.fail_with("does not have the owner permission", "set_transfer_limits", "1", "account", "1", `{}`, "bob")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type Approval struct {
+	Approver string `json:"approver"` //the username of the user who approved
+	Time     string `json:"time"`     //transaction time of the approval
+	Elevated bool   `json:"elevated"` //the approver held an elevated role of the sending guava
+}
+
+type ApprovalBand struct {
+	Min_amount float64 `json:"min_amount"` //transfers of at least this dec_value fall in the band
+	Approvers  int     `json:"approvers"`  //number of distinct approvers required
+}
+
+type ApprovalPolicy struct {
+	Bands          []ApprovalBand `json:"bands"`          //approver count by amount band
+	Maker_checker  bool           `json:"maker_checker"`  //the creator of a transfer may not approve it
+	Approver_roles []string       `json:"approver_roles"` //approvers need one of these access rights <owner,create,approve,read> or guava defined roles
+	Schema         int            `json:"schema"`         //layout version the policy was written with
+}
+
+// ============================================================================================================================
+// required_approvals - number of approvals a transfer of this amount needs, the highest band that applies wins
+// ============================================================================================================================
+
+func (p *ApprovalPolicy) required_approvals(amount float64) int {
+
+	required := 1
+	min_amount := -1.0
+
+	for i := 0; i < len(p.Bands); i++ {
+		band := p.Bands[i]
+		if amount >= band.Min_amount && band.Min_amount > min_amount {
+			required = band.Approvers
+			min_amount = band.Min_amount
+		}
+	}
+
+	return required
+}
+
+// ============================================================================================================================
+// set_approval_policy - set the approval policy for a guava, owners only <guava_id, owner, policy_json>
+// policy_json is {"bands":[{"min_amount", "approvers"}], "maker_checker", "approver_roles":[]}
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_approval_policy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var guava_id string
+	var policy ApprovalPolicy
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, owner, policy_json>")
+	}
+
+	guava_id = args[0]
+
+	err := json.Unmarshal([]byte(args[2]), &policy)
+	if err != nil {
+		return nil, errors.New("Could not parse policy_json: " + err.Error())
+	}
+
+	for i := 0; i < len(policy.Bands); i++ {
+		if policy.Bands[i].Min_amount < 0 {
+			return nil, errors.New("Approval band " + strconv.Itoa(i) + " has a negative min_amount")
+		}
+		if policy.Bands[i].Approvers < 1 {
+			return nil, errors.New("Approval band " + strconv.Itoa(i) + " must require at least one approver")
+		}
+	}
+
+	for i := 0; i < len(policy.Approver_roles); i++ {
+		_, err = get_role(stub, guava_id, policy.Approver_roles[i])
+		if err != nil {
+			return nil, errors.New("Unknown approver role " + policy.Approver_roles[i])
+		}
+	}
+
+	policy.Schema = PolicySchema
+	err = put_record(stub, &policy, PolicyKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(PolicyResponse{Version: ApiVersion, Guava_id: guava_id, Policy: &policy})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_approval_policy - read the approval policy of a guava <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_approval_policy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	policy, err := get_approval_policy(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	policyAsBytes, _ := json.Marshal(policy)
+	return policyAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_approval_policy - load the approval policy of a guava, a guava without one needs a single approver
+// ============================================================================================================================
+
+func get_approval_policy(stub shim.ChaincodeStubInterface, guava_id string) (*ApprovalPolicy, error) {
+
+	policy := ApprovalPolicy{Bands: make([]ApprovalBand, 0), Approver_roles: make([]string, 0), Schema: PolicySchema}
+
+	_, err := get_record(stub, &policy, PolicyKey, guava_id)
+	if err != nil {
+		return nil, err
+	}
+	upgrade_policy(&policy)
+
+	return &policy, nil
+}
+
+func has_elevated_approval(transl *Transfer) bool {
+
+	for i := 0; i < len(transl.Approvals); i++ {
+		if transl.Approvals[i].Elevated {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ============================================================================================================================
+// approved_by - whether an approver already approved a transfer
+// ============================================================================================================================
+
+func approved_by(transl *Transfer, approver string) bool {
+	for i := 0; i < len(transl.Approvals); i++ {
+		if strings.Compare(transl.Approvals[i].Approver, approver) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// approve_transfer - record an approval on a pending transfer and settle it once the sending guava's policy is satisfied
+// returns true if the transfer was settled, counting it against its limits. receiving_acc is nil for a payment, which
+// only debits the sender. The caller is responsible for writing both accounts and the limit usage back.
+// ============================================================================================================================
+
+func approve_transfer(stub shim.ChaincodeStubInterface, limits *limit_checker, sending_acc *Account, receiving_acc *Account, transl *Transfer, dec_value float64, inc_value float64, approver string) (bool, error) {
+
+	tran_id_str := strconv.FormatInt(transl.Transfer_id, 10)
+
+	if strings.Compare(transl.Status, "pending") != 0 {
+		return false, errors.New("Transfer " + tran_id_str + " is not pending, status is " + transl.Status)
+	}
+
+	guava_id := sending_acc.Guava_id
+	policy, err := get_approval_policy(stub, guava_id)
+	if err != nil {
+		return false, err
+	}
+
+	if policy.Maker_checker && strings.Compare(approver, transl.Creator) == 0 {
+		return false, errors.New("Transfer " + tran_id_str + " can not be approved by its creator " + approver)
+	}
+
+	if len(policy.Approver_roles) > 0 {
+		user, err := get_actor(stub, guava_id, approver)
+		if err != nil {
+			return false, err
+		}
+		// an access right held directly or through a role, or a guava defined role held by name
+		allowed, err := user_allowed(stub, guava_id, user, sending_acc.AccountID, policy.Approver_roles...)
+		if err != nil {
+			return false, err
+		}
+		if !allowed && !user_holds_role(user, sending_acc.AccountID, policy.Approver_roles...) {
+			return false, errors.New("User " + approver + " does not have an approver role in guava " + guava_id)
+		}
+	}
+
+	if approved_by(transl, approver) {
+		return false, errors.New("Transfer " + tran_id_str + " was already approved by " + approver)
+	}
+
+	now, err := tx_time_string(stub)
+	if err != nil {
+		return false, err
+	}
+	elevated := false
+	if transl.Elevated {
+		elevated, err = elevated_approver(stub, sending_acc, approver)
+		if err != nil {
+			return false, err
+		}
+	}
+	transl.Approvals = append(transl.Approvals, Approval{Approver: approver, Time: now, Elevated: elevated})
+
+	if len(transl.Approvals) < policy.required_approvals(transl.Dec_value) {
+		return false, nil
+	}
+
+	// a transfer to a counterparty the guava has not whitelisted waits for an elevated approval
+	if transl.Elevated && !has_elevated_approval(transl) {
+		return false, nil
+	}
+
+	// decrement sending account
+	// increment receiving account unless the transfer is paid out of the ledger
+	if sending_acc.Balance < dec_value {
+		return false, errors.New("sending account does not have enough funds " + strconv.FormatInt(sending_acc.AccountID, 10))
+	}
+
+	err = limits.settle(stub, sending_acc, transl)
+	if err != nil {
+		return false, err
+	}
+
+	sending_acc.Balance = sending_acc.Balance - dec_value
+
+	transl.Status = "approved"
+	transl.Approver = approver
+	if credits_receiver(transl) {
+		receiving_acc.Balance = receiving_acc.Balance + inc_value
+		receiving_acc.IncomingTransfer = append(receiving_acc.IncomingTransfer, *transl)
+	}
+
+	err = del_pending_inbound(stub, account_cache{transl.To: receiving_acc}, transl)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestApprovalPolicyBands(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp PolicyResponse
+	l.decode(l.ok("set_approval_policy", "1", "alice", `{"bands":[{"min_amount":0,"approvers":1},{"min_amount":500,"approvers":2}]}`), &resp)
+	if resp.Guava_id != "1" || len(resp.Policy.Bands) != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	small := strconv.FormatInt(l.payment("100"), 10)
+	large := strconv.FormatInt(l.payment("600"), 10)
+
+	l.ok("accept_transfer", "2", "1", small, "100", "100", "carol")
+	l.balance(1, 900)
+
+	var accepted TransferResponse
+	l.decode(l.ok("accept_transfer", "2", "1", large, "600", "600", "carol"), &accepted)
+	if accepted.Status != "pending" || len(accepted.Transfer.Approvals) != 1 {
+		t.Fatalf("large transfer settled after one approval: %+v", accepted)
+	}
+	l.balance(1, 900)
+
+	l.fail_with("already approved by carol", "accept_transfer", "2", "1", large, "600", "600", "carol")
+
+	l.decode(l.ok("accept_transfer", "2", "1", large, "600", "600", "erin"), &accepted)
+	if accepted.Status != "approved" || accepted.Transfer.Approver != "erin" || len(accepted.Transfer.Approvals) != 2 {
+		t.Fatalf("unexpected response %+v", accepted)
+	}
+	l.balance(1, 300)
+	l.balance(2, 500)
+}
+
+func TestApprovalPolicyMakerChecker(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_approval_policy", "1", "alice", `{"maker_checker":true}`)
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "invoice", "1", "100", "100", "1", "2", "payment", "t", "alice", beneficiary), &resp)
+	id := strconv.FormatInt(resp.Transfer_id, 10)
+
+	l.fail_with("can not be approved by its creator", "accept_transfer", "2", "1", id, "100", "100", "alice")
+	l.ok("accept_transfer", "2", "1", id, "100", "100", "carol")
+}
+
+func TestApprovalPolicyRoles(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_approval_policy", "1", "alice", `{"approver_roles":["owner"]}`)
+	id := strconv.FormatInt(l.payment("100"), 10)
+
+	l.fail_with("does not have an approver role", "accept_transfer", "2", "1", id, "100", "100", "carol")
+	l.ok("accept_transfer", "2", "1", id, "100", "100", "alice")
+
+	// a guava defined role is held by name, for the accounts it was assigned for
+	l.ok("define_role", "1", "treasurer", `["read"]`, "alice")
+	l.ok("assign_role", "1", "carol", "treasurer", `[1]`, "alice")
+	l.ok("set_approval_policy", "1", "alice", `{"approver_roles":["treasurer"]}`)
+	l.ok("accept_transfer", "2", "1", strconv.FormatInt(l.payment("100"), 10), "100", "100", "carol")
+	l.fail_with("does not have an approver role", "accept_transfer", "2", "1", strconv.FormatInt(l.payment("100"), 10), "100", "100", "erin")
+}
+
+func TestSetApprovalPolicyErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("does not have the owner permission", "set_approval_policy", "1", "carol", `{}`)
+	l.fail_with("must require at least one approver", "set_approval_policy", "1", "alice", `{"bands":[{"min_amount":0,"approvers":0}]}`)
+	l.fail_with("negative min_amount", "set_approval_policy", "1", "alice", `{"bands":[{"min_amount":-1,"approvers":1}]}`)
+	l.fail_with("Unknown approver role", "set_approval_policy", "1", "alice", `{"approver_roles":["boss"]}`)
+	l.fail_with("Could not parse policy_json", "set_approval_policy", "1", "alice", `[`)
+
+	var policy ApprovalPolicy
+	l.decode(l.ok("read_approval_policy", "1", "dave"), &policy)
+	if len(policy.Bands) != 0 || policy.Maker_checker || len(policy.Approver_roles) != 0 {
+		t.Fatalf("a rejected policy was stored: %+v", policy)
+	}
+}
+
+func TestRequiredApprovals(t *testing.T) {
+	policy := ApprovalPolicy{Bands: []ApprovalBand{{Min_amount: 1000, Approvers: 3}, {Min_amount: 0, Approvers: 1}, {Min_amount: 100, Approvers: 2}}}
+
+	for amount, want := range map[float64]int{0: 1, 99: 1, 100: 2, 999: 2, 1000: 3, 50000: 3} {
+		if got := policy.required_approvals(amount); got != want {
+			t.Errorf("required_approvals(%v) = %d, want %d", amount, got, want)
+		}
+	}
+
+	if got := (&ApprovalPolicy{}).required_approvals(1e9); got != 1 {
+		t.Errorf("empty policy requires %d approvals, want 1", got)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// A guava reports its position in a base currency. Owners store the rates that convert each other currency into it,
+// read_guava_position sums balances and pending transfers per currency and converts them with those rates.
+// Currencies without a rate are listed as missing and left out of the base totals.
+
+type FxRates struct {
+	Guava_id      string             `json:"guava_id"`
+	Base_currency string             `json:"base_currency"` //currency the position is reported in
+	Rates         map[string]float64 `json:"rates"`         //units of the base currency one unit of each currency is worth
+	Updated       string             `json:"updated"`       //transaction time the rates were set
+	Updated_by    string             `json:"updated_by"`    //the username of the owner who set them
+	Schema        int                `json:"schema"`        //layout version the rates were written with
+}
+
+type CurrencyPosition struct {
+	Currency         string  `json:"currency"`
+	Accounts         int     `json:"accounts"`         //accounts of the guava held in the currency
+	Balance          float64 `json:"balance"`          //sum of their balances
+	Pending_in       float64 `json:"pending_in"`       //pending transfers to them, by inc_value
+	Pending_out      float64 `json:"pending_out"`      //pending transfers from them, by dec_value
+	Rate             float64 `json:"rate"`             //rate into the base currency, 0 if there is none
+	Base_balance     float64 `json:"base_balance"`     //balance in the base currency
+	Base_pending_in  float64 `json:"base_pending_in"`  //pending_in in the base currency
+	Base_pending_out float64 `json:"base_pending_out"` //pending_out in the base currency
+}
+
+type GuavaPosition struct {
+	Guava_id         string             `json:"guava_id"`
+	Base_currency    string             `json:"base_currency"`    //empty if the guava has no rates
+	Rates_updated    string             `json:"rates_updated"`    //when the rates used were set
+	Currencies       []CurrencyPosition `json:"currencies"`       //one entry per currency, in currency order
+	Base_balance     float64            `json:"base_balance"`     //total balance in the base currency
+	Base_pending_in  float64            `json:"base_pending_in"`  //total pending inbound in the base currency
+	Base_pending_out float64            `json:"base_pending_out"` //total pending outbound in the base currency
+	Missing_rates    []string           `json:"missing_rates"`    //currencies left out of the base totals
+	Accounts         int                `json:"accounts"`         //number of accounts in the guava
+	Account_types    map[string]int     `json:"account_types"`    //number of accounts of each type
+}
+
+// ============================================================================================================================
+// set_fx_rates - set the base currency of a guava and the rates into it, owners only <guava_id, base_currency, rates_json, owner>
+// rates_json is {"<currency>": rate}, the units of the base currency one unit of the currency is worth
+// ============================================================================================================================
+
+func (t *GuavaChaincode) set_fx_rates(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, base_currency, rates_json, owner>")
+	}
+
+	rates := FxRates{Guava_id: args[0], Base_currency: strings.ToUpper(args[1]), Updated_by: args[3], Schema: FxRatesSchema}
+
+	if !valid_currency(rates.Base_currency) {
+		return nil, errors.New("Invalid base_currency " + args[1] + ", expecting a three letter currency code")
+	}
+
+	var given map[string]float64
+	err := json.Unmarshal([]byte(args[2]), &given)
+	if err != nil {
+		return nil, errors.New("Could not parse rates_json: " + err.Error())
+	}
+
+	rates.Rates = make(map[string]float64)
+	for currency, rate := range given {
+		currency = strings.ToUpper(currency)
+		if !valid_currency(currency) {
+			return nil, errors.New("Invalid currency " + currency + " in rates_json, expecting a three letter currency code")
+		}
+		if rate <= 0 {
+			return nil, errors.New("The rate of " + currency + " must be positive")
+		}
+		rates.Rates[currency] = rate
+	}
+	rates.Rates[rates.Base_currency] = 1
+
+	rates.Updated, err = tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = get_guava(stub, rates.Guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_record(stub, &rates, FxRatesKey, rates.Guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(FxRatesResponse{Version: ApiVersion, Guava_id: rates.Guava_id, Rates: &rates})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_fx_rates - read the base currency and rates of a guava, null if it has none <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_fx_rates(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	rates, err := get_fx_rates(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ratesAsBytes, _ := json.Marshal(rates)
+	return ratesAsBytes, nil
+}
+
+func get_fx_rates(stub shim.ChaincodeStubInterface, guava_id string) (*FxRates, error) {
+
+	rates := FxRates{}
+
+	found, err := get_record(stub, &rates, FxRatesKey, guava_id)
+	if err != nil || !found {
+		return nil, err
+	}
+	upgrade_fx_rates(&rates)
+
+	return &rates, nil
+}
+
+// ============================================================================================================================
+// read_guava_position - the balances and pending transfers of a guava by currency, converted into its base currency
+// <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_guava_position(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	guava_id := args[0]
+
+	guava, err := get_guava(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	rates, err := get_fx_rates(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := account_cache{}
+	currencies := make(map[string]*CurrencyPosition)
+	position := GuavaPosition{Guava_id: guava_id, Currencies: make([]CurrencyPosition, 0), Missing_rates: make([]string, 0),
+		Accounts: len(guava.Accounts), Account_types: make(map[string]int)}
+
+	in_currency := func(currency string) *CurrencyPosition {
+		if currencies[currency] == nil {
+			currencies[currency] = &CurrencyPosition{Currency: currency}
+		}
+		return currencies[currency]
+	}
+
+	for i := 0; i < len(guava.Accounts); i++ {
+		acc, err := accounts.get(stub, guava.Accounts[i])
+		if err != nil {
+			return nil, err
+		}
+
+		cur := in_currency(acc.Currency)
+		cur.Accounts = cur.Accounts + 1
+		cur.Balance = cur.Balance + acc.Balance
+		position.Account_types[acc.Type] = position.Account_types[acc.Type] + 1
+
+		// pending transfers are only held by the sending account until they settle
+		for j := 0; j < len(acc.OutgoingTransfer); j++ {
+			transl := &acc.OutgoingTransfer[j]
+			if strings.Compare(transl.Status, "pending") == 0 {
+				cur.Pending_out = cur.Pending_out + transl.Dec_value
+			}
+		}
+	}
+
+	err = add_pending_inbound(stub, guava_id, accounts, in_currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if rates != nil {
+		position.Base_currency = rates.Base_currency
+		position.Rates_updated = rates.Updated
+	}
+
+	names := make([]string, 0, len(currencies))
+	for currency := range currencies {
+		names = append(names, currency)
+	}
+	sort.Strings(names)
+
+	for _, currency := range names {
+		cur := currencies[currency]
+
+		rate := 0.0
+		if rates != nil {
+			rate = rates.Rates[currency]
+		}
+		if rate == 0 {
+			position.Missing_rates = append(position.Missing_rates, currency)
+		} else {
+			cur.Rate = rate
+			cur.Base_balance = cur.Balance * rate
+			cur.Base_pending_in = cur.Pending_in * rate
+			cur.Base_pending_out = cur.Pending_out * rate
+			position.Base_balance = position.Base_balance + cur.Base_balance
+			position.Base_pending_in = position.Base_pending_in + cur.Base_pending_in
+			position.Base_pending_out = position.Base_pending_out + cur.Base_pending_out
+		}
+
+		position.Currencies = append(position.Currencies, *cur)
+	}
+
+	positionAsBytes, _ := json.Marshal(position)
+	return positionAsBytes, nil
+}
+
+// ============================================================================================================================
+// add_pending_inbound - add the pending transfers to the guava's accounts, from its own accounts and from other guavas
+// the receiving accounts do not hold them yet, so they are found through the pending inbound index of the guava
+// ============================================================================================================================
+
+func add_pending_inbound(stub shim.ChaincodeStubInterface, guava_id string, accounts account_cache, in_currency func(string) *CurrencyPosition) error {
+
+	iter, err := stub.GetStateByPartialCompositeKey(PendingInboundKey, []string{guava_id})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		index := TransferIndex{}
+		err = json.Unmarshal(kv.Value, &index)
+		if err != nil {
+			return errors.New("Could not decode pending inbound transfer " + strconv.Quote(kv.Key))
+		}
+
+		sending_acc, err := accounts.get(stub, index.From)
+		if err != nil {
+			return err
+		}
+		transl := find_transfer(sending_acc.OutgoingTransfer, index.Transfer_id)
+		if transl == nil || strings.Compare(transl.Status, "pending") != 0 {
+			continue
+		}
+
+		to_acc, err := accounts.get(stub, index.To)
+		if err != nil {
+			return err
+		}
+		in_currency(to_acc.Currency).Pending_in = in_currency(to_acc.Currency).Pending_in + transl.Inc_value
+	}
+
+	return nil
+}
+
+func valid_currency(currency string) bool {
+
+	return len(currency) == 3 && all_letters(currency)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFxRates(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	if string(l.ok("read_fx_rates", "1", "dave")) != "null" {
+		t.Fatalf("a guava without rates should read null")
+	}
+
+	var resp FxRatesResponse
+	l.decode(l.ok("set_fx_rates", "1", "cad", `{"usd":1.25, "EUR":1.5}`, "alice"), &resp)
+	if resp.Rates == nil || resp.Rates.Base_currency != "CAD" || resp.Rates.Rates["USD"] != 1.25 || resp.Rates.Rates["EUR"] != 1.5 ||
+		resp.Rates.Rates["CAD"] != 1 || resp.Rates.Updated != "2026-01-05T09:00:00Z" || resp.Rates.Updated_by != "alice" || resp.Rates.Schema != FxRatesSchema {
+		t.Fatalf("unexpected rates %+v", resp.Rates)
+	}
+
+	var rates FxRates
+	l.decode(l.ok("read_fx_rates", "1", "dave"), &rates)
+	if rates.Base_currency != "CAD" || len(rates.Rates) != 3 {
+		t.Fatalf("unexpected stored rates %+v", rates)
+	}
+
+	l.fail_with("User bob does not have the owner permission in guava 1", "set_fx_rates", "1", "CAD", `{}`, "bob")
+	l.fail_with("Invalid base_currency CA", "set_fx_rates", "1", "CA", `{}`, "alice")
+	l.fail_with("Invalid currency DOLLAR in rates_json", "set_fx_rates", "1", "CAD", `{"dollar":1}`, "alice")
+	l.fail_with("The rate of USD must be positive", "set_fx_rates", "1", "CAD", `{"USD":0}`, "alice")
+	l.fail_with("Could not parse rates_json", "set_fx_rates", "1", "CAD", `[]`, "alice")
+}
+
+func TestGuavaPosition(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "usd", "1", "USD", "US", "SAVINGS", "200", "root")
+	l.ok("create_account", "eur", "1", "EUR", "FR", "OPR", "50", "root")
+
+	// pending out of ops and pending into usd from ops, an approved payment does not count and pending payments
+	// never arrive, not even from another guava
+	l.payment("100")
+	l.ok("create_transfer", "fx", "0.8", "80", "100", "1", "3", "internal", "t", "bob", "")
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "500", "root")
+	l.ok("create_user", "frank", "false", "true", "true", "true", "2", identity("frank"), "root")
+	l.ok("create_transfer", "in", "0.75", "30", "40", "5", "3", "payment", "t", "frank", beneficiary)
+	settled := l.payment("10")
+	l.ok("accept_transfer", "2", "1", format_int(settled), "10", "10", "carol")
+
+	var position GuavaPosition
+	l.decode(l.ok("read_guava_position", "1", "dave"), &position)
+	if position.Base_currency != "" || position.Base_balance != 0 || len(position.Missing_rates) != 3 {
+		t.Fatalf("a guava without rates should only report native amounts %+v", position)
+	}
+
+	l.ok("set_fx_rates", "1", "CAD", `{"USD":1.25}`, "alice")
+	position = GuavaPosition{}
+	l.decode(l.ok("read_guava_position", "1", "dave"), &position)
+
+	if position.Guava_id != "1" || position.Base_currency != "CAD" || position.Rates_updated != "2026-01-05T09:00:00Z" || position.Accounts != 4 ||
+		position.Account_types["OPR"] != 2 || position.Account_types["SAVINGS"] != 2 {
+		t.Fatalf("unexpected position %+v", position)
+	}
+	if len(position.Currencies) != 3 || position.Currencies[0].Currency != "CAD" || position.Currencies[1].Currency != "EUR" || position.Currencies[2].Currency != "USD" {
+		t.Fatalf("unexpected currencies %+v", position.Currencies)
+	}
+
+	cad := position.Currencies[0]
+	if cad.Accounts != 2 || cad.Balance != 1490 || cad.Pending_out != 200 || cad.Pending_in != 0 || cad.Rate != 1 || cad.Base_balance != 1490 {
+		t.Fatalf("unexpected CAD position %+v", cad)
+	}
+	usd := position.Currencies[2]
+	if usd.Accounts != 1 || usd.Balance != 200 || usd.Pending_in != 80 || usd.Pending_out != 0 || usd.Rate != 1.25 || usd.Base_balance != 250 || usd.Base_pending_in != 100 {
+		t.Fatalf("unexpected USD position %+v", usd)
+	}
+
+	// EUR has no rate, it is reported natively and left out of the totals
+	eur := position.Currencies[1]
+	if eur.Balance != 50 || eur.Rate != 0 || eur.Base_balance != 0 || len(position.Missing_rates) != 1 || position.Missing_rates[0] != "EUR" {
+		t.Fatalf("unexpected EUR position %+v, missing %v", eur, position.Missing_rates)
+	}
+	if position.Base_balance != 1740 || position.Base_pending_in != 100 || position.Base_pending_out != 200 {
+		t.Fatalf("unexpected base totals %+v", position)
+	}
+
+	l.fail_with("User frank does not have the read permission in guava 1", "read_guava_position", "1", "frank")
+}
+
+func TestPendingInboundIndex(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "usd", "1", "USD", "US", "SAVINGS", "200", "root")
+
+	indexed := func(id int64) bool {
+		return l.state(PendingInboundKey, "1", format_int(id)) != nil
+	}
+	fx := func() int64 {
+		var resp TransferResponse
+		l.decode(l.ok("create_transfer", "fx", "0.8", "80", "100", "1", "3", "internal", "t", "bob", ""), &resp)
+		return resp.Transfer_id
+	}
+
+	// a payment credits no account of the guava and is never indexed
+	if payment := l.payment("10"); indexed(payment) {
+		t.Fatalf("payment %d was indexed as pending inbound", payment)
+	}
+
+	accepted, rejected, cancelled := fx(), fx(), fx()
+	if !indexed(accepted) || !indexed(rejected) || !indexed(cancelled) {
+		t.Fatalf("pending transfers were not indexed")
+	}
+	l.ok("accept_transfer", "3", "1", format_int(accepted), "100", "80", "carol")
+	l.ok("reject_transfer", "1", format_int(rejected), "carol")
+	l.ok("cancel_transfer", "1", format_int(cancelled), "bob", "typo")
+	if indexed(accepted) || indexed(rejected) || indexed(cancelled) {
+		t.Fatalf("transfers that left pending are still indexed")
+	}
+
+	l.ok("set_transfer_ttl", "1", "alice", "internal", "60")
+	expired := fx()
+	l.now = l.now.Add(time.Minute)
+	l.ok("expire_transfers", "1", "carol")
+	if indexed(expired) {
+		t.Fatalf("expired transfer %d is still indexed", expired)
+	}
+
+	var batch BatchResponse
+	l.decode(l.ok("create_batch_transfer", "bob", "t", `[{"fx_rate":0.8, "inc_value":80, "dec_value":100, "from":1, "to":3, "type":"internal"}]`), &batch)
+	if !indexed(batch.Transfer_ids[0]) {
+		t.Fatalf("batch transfer %d was not indexed", batch.Transfer_ids[0])
+	}
+	l.ok("reject_batch", format_int(batch.Batch_id), "carol")
+	if indexed(batch.Transfer_ids[0]) {
+		t.Fatalf("rejected batch transfer %d is still indexed", batch.Transfer_ids[0])
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Every function can be called either with its positional arguments or with a single JSON object
+// argument holding named fields and the schema version. JSON requests are converted to the
+// positional form before dispatch, so both call styles go through the same validation.
+
+var ApiVersion = 1
+
+type RequestHeader struct {
+	Version int `json:"version"` //schema version of the request, currently 1
+}
+
+func (h *RequestHeader) version() int {
+	return h.Version
+}
+
+type request interface {
+	version() int
+	args() []string
+}
+
+type InitRequest struct {
+	RequestHeader
+	Value string `json:"value"`
+	Admin string `json:"admin"`
+}
+
+type CreateAccountRequest struct {
+	RequestHeader
+	Account_name    string  `json:"account_name"`
+	Guava_id        string  `json:"guava_id"` //"-1" creates a new guava
+	Currency        string  `json:"currency"`
+	Country         string  `json:"country"`
+	Acctype         string  `json:"acctype"`
+	Initial_balance float64 `json:"initial_balance"`
+	Actor           string  `json:"actor"`
+}
+
+type CreateTransferRequest struct {
+	RequestHeader
+	Message     string          `json:"message"`
+	Fx_rate     float64         `json:"fx_rate"`
+	Inc_value   float64         `json:"inc_value"`
+	Dec_value   float64         `json:"dec_value"`
+	From        int64           `json:"from"`
+	To          int64           `json:"to"`
+	Trans_type  string          `json:"type"`
+	Time        string          `json:"time"`
+	Creator     string          `json:"creator"`
+	Beneficiary json.RawMessage `json:"beneficiary"`
+}
+
+type ValueRequest struct {
+	RequestHeader
+	Account_id int64   `json:"account_id"`
+	Value      float64 `json:"value"`
+	Actor      string  `json:"actor"`
+}
+
+type AcceptTransferRequest struct {
+	RequestHeader
+	To          int64   `json:"to"`
+	From        int64   `json:"from"`
+	Transfer_id int64   `json:"transfer_id"`
+	Dec_value   float64 `json:"dec_value"`
+	Inc_value   float64 `json:"inc_value"`
+	Approver    string  `json:"approver"`
+}
+
+type RejectTransferRequest struct {
+	RequestHeader
+	From        int64  `json:"from"`
+	Transfer_id int64  `json:"transfer_id"`
+	Approver    string `json:"approver"`
+}
+
+type CancelTransferRequest struct {
+	RequestHeader
+	From        int64  `json:"from"`
+	Transfer_id int64  `json:"transfer_id"`
+	Actor       string `json:"actor"`
+	Reason      string `json:"reason"`
+}
+
+type ReverseTransferRequest struct {
+	RequestHeader
+	From        int64   `json:"from"`
+	Transfer_id int64   `json:"transfer_id"`
+	Amount      float64 `json:"amount"`
+	Actor       string  `json:"actor"`
+	Reason      string  `json:"reason"`
+}
+
+type CreateUserRequest struct {
+	RequestHeader
+	Username string `json:"username"`
+	Owner    bool   `json:"owner"`
+	Create   bool   `json:"create"`
+	Approve  bool   `json:"approve"`
+	Read     bool   `json:"read"`
+	Guava_id string `json:"guava_id"`
+	Identity string `json:"identity"`
+	Actor    string `json:"actor"`
+}
+
+type UpdateUserRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Username string `json:"username"`
+	Owner    bool   `json:"owner"`
+	Create   bool   `json:"create"`
+	Approve  bool   `json:"approve"`
+	Read     bool   `json:"read"`
+	Actor    string `json:"actor"`
+}
+
+type ManageUserRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Username string `json:"username"`
+	Actor    string `json:"actor"`
+}
+
+type UserIdentityRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Username string `json:"username"`
+	Identity string `json:"identity"`
+	Actor    string `json:"actor"`
+}
+
+type GuavaActorRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Actor    string `json:"actor"`
+}
+
+type FxRatesRequest struct {
+	RequestHeader
+	Guava_id      string          `json:"guava_id"`
+	Base_currency string          `json:"base_currency"`
+	Rates         json.RawMessage `json:"rates"`
+	Owner         string          `json:"owner"`
+}
+
+type BalanceAtRequest struct {
+	RequestHeader
+	Account_id int64  `json:"account_id"`
+	As_of      string `json:"as_of"`
+	Caller     string `json:"caller"`
+}
+
+type GuavaBalanceAtRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	As_of    string `json:"as_of"`
+	Caller   string `json:"caller"`
+}
+
+type AccountStatementRequest struct {
+	RequestHeader
+	Account_id int64  `json:"account_id"`
+	From_date  string `json:"from_date"`
+	To_date    string `json:"to_date"`
+	Format     string `json:"format"`
+	Caller     string `json:"caller"`
+}
+
+type ImportStatementRequest struct {
+	RequestHeader
+	Guava_id  string `json:"guava_id"`
+	Format    string `json:"format"`
+	Statement string `json:"statement"`
+	Actor     string `json:"actor"`
+}
+
+type StatementImportRequest struct {
+	RequestHeader
+	Guava_id     string `json:"guava_id"`
+	Statement_id string `json:"statement_id"`
+	Caller       string `json:"caller"`
+}
+
+type PaymentExportRequest struct {
+	RequestHeader
+	Guava_id  string `json:"guava_id"`
+	Export_id int64  `json:"export_id"`
+	Caller    string `json:"caller"`
+}
+
+type CounterpartiesRequest struct {
+	RequestHeader
+	Guava_id  string          `json:"guava_id"`
+	Whitelist json.RawMessage `json:"whitelist"`
+	Owner     string          `json:"owner"`
+}
+
+type BankAccountRequest struct {
+	RequestHeader
+	Account_id int64           `json:"account_id"`
+	Bank       json.RawMessage `json:"bank"`
+	Owner      string          `json:"owner"`
+}
+
+type TransferLimitsRequest struct {
+	RequestHeader
+	Guava_id string          `json:"guava_id"`
+	Scope    string          `json:"scope"`
+	Subject  string          `json:"subject"`
+	Limits   json.RawMessage `json:"limits"`
+	Owner    string          `json:"owner"`
+}
+
+type LimitUsageRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Scope    string `json:"scope"`
+	Subject  string `json:"subject"`
+	Caller   string `json:"caller"`
+}
+
+type DefineRoleRequest struct {
+	RequestHeader
+	Guava_id    string          `json:"guava_id"`
+	Role        string          `json:"role"`
+	Permissions json.RawMessage `json:"permissions"`
+	Actor       string          `json:"actor"`
+}
+
+type AssignRoleRequest struct {
+	RequestHeader
+	Guava_id string          `json:"guava_id"`
+	Username string          `json:"username"`
+	Role     string          `json:"role"`
+	Accounts json.RawMessage `json:"accounts"`
+	Actor    string          `json:"actor"`
+}
+
+type RevokeRoleRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Actor    string `json:"actor"`
+}
+
+type CreateBatchTransferRequest struct {
+	RequestHeader
+	Creator   string          `json:"creator"`
+	Time      string          `json:"time"`
+	Transfers json.RawMessage `json:"transfers"` //array of {"message", "fx_rate", "inc_value", "dec_value", "from", "to", "type", "beneficiary"}
+}
+
+type BatchRequest struct {
+	RequestHeader
+	Batch_id int64  `json:"batch_id"`
+	Approver string `json:"approver"`
+}
+
+type ApprovalPolicyRequest struct {
+	RequestHeader
+	Guava_id string          `json:"guava_id"`
+	Owner    string          `json:"owner"`
+	Policy   json.RawMessage `json:"policy"`
+}
+
+type TransferTTLRequest struct {
+	RequestHeader
+	Guava_id    string `json:"guava_id"`
+	Owner       string `json:"owner"`
+	Trans_type  string `json:"type"`
+	Ttl_seconds int64  `json:"ttl_seconds"`
+}
+
+type AccountIdRequest struct {
+	RequestHeader
+	Account_id int64  `json:"account_id"`
+	Caller     string `json:"caller"`
+}
+
+type TransferIdRequest struct {
+	RequestHeader
+	Transfer_id int64  `json:"transfer_id"`
+	Caller      string `json:"caller"`
+}
+
+type UserRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Username string `json:"username"`
+	Caller   string `json:"caller"`
+}
+
+type GuavaCallerRequest struct {
+	RequestHeader
+	Guava_id string `json:"guava_id"`
+	Caller   string `json:"caller"`
+}
+
+type BatchIdRequest struct {
+	RequestHeader
+	Batch_id int64  `json:"batch_id"`
+	Caller   string `json:"caller"`
+}
+
+type MigrateRequest struct {
+	RequestHeader
+	Start_key string `json:"start_key"`
+	Page_size int64  `json:"page_size"`
+	Actor     string `json:"actor"`
+}
+
+type MigrationStatusRequest struct {
+	RequestHeader
+	Start_key string `json:"start_key"`
+	Page_size int64  `json:"page_size"`
+}
+
+func (r *InitRequest) args() []string {
+	return []string{r.Value, r.Admin}
+}
+
+func (r *CreateAccountRequest) args() []string {
+	return []string{r.Account_name, r.Guava_id, r.Currency, r.Country, r.Acctype, format_float(r.Initial_balance), r.Actor}
+}
+
+func (r *CreateTransferRequest) args() []string {
+	return []string{r.Message, format_float(r.Fx_rate), format_float(r.Inc_value), format_float(r.Dec_value),
+		format_int(r.From), format_int(r.To), r.Trans_type, r.Time, r.Creator, string(r.Beneficiary)}
+}
+
+func (r *ValueRequest) args() []string {
+	return []string{format_int(r.Account_id), format_float(r.Value), r.Actor}
+}
+
+func (r *AcceptTransferRequest) args() []string {
+	return []string{format_int(r.To), format_int(r.From), format_int(r.Transfer_id), format_float(r.Dec_value), format_float(r.Inc_value), r.Approver}
+}
+
+func (r *RejectTransferRequest) args() []string {
+	return []string{format_int(r.From), format_int(r.Transfer_id), r.Approver}
+}
+
+func (r *CancelTransferRequest) args() []string {
+	return []string{format_int(r.From), format_int(r.Transfer_id), r.Actor, r.Reason}
+}
+
+func (r *ReverseTransferRequest) args() []string {
+	return []string{format_int(r.From), format_int(r.Transfer_id), format_float(r.Amount), r.Actor, r.Reason}
+}
+
+func (r *CreateUserRequest) args() []string {
+	return []string{r.Username, strconv.FormatBool(r.Owner), strconv.FormatBool(r.Create), strconv.FormatBool(r.Approve), strconv.FormatBool(r.Read), r.Guava_id, r.Identity, r.Actor}
+}
+
+func (r *UpdateUserRequest) args() []string {
+	return []string{r.Guava_id, r.Username, strconv.FormatBool(r.Owner), strconv.FormatBool(r.Create), strconv.FormatBool(r.Approve), strconv.FormatBool(r.Read), r.Actor}
+}
+
+func (r *ManageUserRequest) args() []string {
+	return []string{r.Guava_id, r.Username, r.Actor}
+}
+
+func (r *UserIdentityRequest) args() []string {
+	return []string{r.Guava_id, r.Username, r.Identity, r.Actor}
+}
+
+func (r *GuavaActorRequest) args() []string {
+	return []string{r.Guava_id, r.Actor}
+}
+
+func (r *FxRatesRequest) args() []string {
+	return []string{r.Guava_id, r.Base_currency, string(r.Rates), r.Owner}
+}
+
+func (r *BalanceAtRequest) args() []string {
+	return []string{format_int(r.Account_id), r.As_of, r.Caller}
+}
+
+func (r *GuavaBalanceAtRequest) args() []string {
+	return []string{r.Guava_id, r.As_of, r.Caller}
+}
+
+func (r *AccountStatementRequest) args() []string {
+	return []string{format_int(r.Account_id), r.From_date, r.To_date, r.Format, r.Caller}
+}
+
+func (r *ImportStatementRequest) args() []string {
+	return []string{r.Guava_id, r.Format, r.Statement, r.Actor}
+}
+
+func (r *StatementImportRequest) args() []string {
+	return []string{r.Guava_id, r.Statement_id, r.Caller}
+}
+
+func (r *PaymentExportRequest) args() []string {
+	return []string{r.Guava_id, format_int(r.Export_id), r.Caller}
+}
+
+func (r *CounterpartiesRequest) args() []string {
+	return []string{r.Guava_id, string(r.Whitelist), r.Owner}
+}
+
+func (r *BankAccountRequest) args() []string {
+	return []string{format_int(r.Account_id), string(r.Bank), r.Owner}
+}
+
+func (r *TransferLimitsRequest) args() []string {
+	return []string{r.Guava_id, r.Scope, r.Subject, string(r.Limits), r.Owner}
+}
+
+func (r *LimitUsageRequest) args() []string {
+	return []string{r.Guava_id, r.Scope, r.Subject, r.Caller}
+}
+
+func (r *DefineRoleRequest) args() []string {
+	return []string{r.Guava_id, r.Role, string(r.Permissions), r.Actor}
+}
+
+func (r *AssignRoleRequest) args() []string {
+	return []string{r.Guava_id, r.Username, r.Role, string(r.Accounts), r.Actor}
+}
+
+func (r *RevokeRoleRequest) args() []string {
+	return []string{r.Guava_id, r.Username, r.Role, r.Actor}
+}
+
+func (r *CreateBatchTransferRequest) args() []string {
+	return []string{r.Creator, r.Time, string(r.Transfers)}
+}
+
+func (r *BatchRequest) args() []string {
+	return []string{format_int(r.Batch_id), r.Approver}
+}
+
+func (r *ApprovalPolicyRequest) args() []string {
+	return []string{r.Guava_id, r.Owner, string(r.Policy)}
+}
+
+func (r *TransferTTLRequest) args() []string {
+	return []string{r.Guava_id, r.Owner, r.Trans_type, format_int(r.Ttl_seconds)}
+}
+
+func (r *AccountIdRequest) args() []string {
+	return []string{format_int(r.Account_id), r.Caller}
+}
+
+func (r *TransferIdRequest) args() []string {
+	return []string{format_int(r.Transfer_id), r.Caller}
+}
+
+func (r *UserRequest) args() []string {
+	return []string{r.Guava_id, r.Username, r.Caller}
+}
+
+func (r *GuavaCallerRequest) args() []string {
+	return []string{r.Guava_id, r.Caller}
+}
+
+func (r *BatchIdRequest) args() []string {
+	return []string{format_int(r.Batch_id), r.Caller}
+}
+
+func (r *MigrateRequest) args() []string {
+	return []string{r.Start_key, format_int(r.Page_size), r.Actor}
+}
+
+func (r *MigrationStatusRequest) args() []string {
+	return []string{r.Start_key, format_int(r.Page_size)}
+}
+
+// ============================================================================================================================
+// decode_request - turn a single JSON object argument into the positional arguments of function
+// any other argument list is passed through unchanged
+// ============================================================================================================================
+
+func decode_request(function string, new_request func() request, args []string) ([]string, error) {
+
+	if len(args) != 1 || !strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		return args, nil
+	}
+
+	req := new_request()
+	decoder := json.NewDecoder(bytes.NewReader([]byte(args[0])))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(req)
+	if err != nil {
+		return nil, errors.New("Could not parse " + function + " request: " + err.Error())
+	}
+	if req.version() != ApiVersion {
+		return nil, errors.New("Unsupported " + function + " request version " + strconv.Itoa(req.version()) + ", expecting " + strconv.Itoa(ApiVersion))
+	}
+
+	return req.args(), nil
+}
+
+func format_float(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func format_int(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
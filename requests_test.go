@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestJSONRequests(t *testing.T) {
+	l := new_ledger(t)
+	l.init()
+
+	var account AccountResponse
+	l.decode(l.ok("create_account", `{"version":1, "account_name":"ops", "guava_id":"-1", "currency":"CAD", "country":"CA", "acctype":"OPR", "initial_balance":1000, "actor":"root"}`), &account)
+	if account.Version != 1 || account.Account_id != 1 || account.Account.AccountName != "ops" || account.Account.Balance != 1000 {
+		t.Fatalf("unexpected response %+v", account)
+	}
+
+	l.ok("create_account", "savings", "1", "CAD", "CA", "SAVINGS", "0", "root")
+	l.ok("create_user", `{"version":1, "username":"bob", "create":true, "guava_id":"1", "identity":"`+identity("bob")+`", "actor":"root"}`)
+
+	var transfer TransferResponse
+	l.decode(l.ok("create_transfer", `{"version":1, "message":"sweep", "fx_rate":1, "inc_value":25.5, "dec_value":25.5, "from":1, "to":2, "type":"internal", "time":"t", "creator":"bob"}`), &transfer)
+	if transfer.Version != 1 || transfer.Transfer_id != 1 || transfer.Status != "approved" || len(transfer.Balances) != 2 || transfer.Balances[1].Balance != 25.5 {
+		t.Fatalf("unexpected response %+v", transfer)
+	}
+
+	var batch BatchResponse
+	l.decode(l.ok("create_batch_transfer", `{"version":1, "creator":"bob", "time":"t", "transfers":[{"inc_value":1, "dec_value":1, "from":1, "to":2, "type":"internal"}]}`), &batch)
+	if batch.Version != 1 || len(batch.Transfer_ids) != 1 {
+		t.Fatalf("unexpected response %+v", batch)
+	}
+}
+
+func TestJSONRequestErrors(t *testing.T) {
+	l := new_ledger(t)
+
+	l.fail_with("Unsupported create_account request version 2", "create_account", `{"version":2, "account_name":"ops", "guava_id":"-1"}`)
+	l.fail_with("Unsupported create_account request version 0", "create_account", `{"account_name":"ops", "guava_id":"-1"}`)
+	l.fail_with("unknown field", "create_account", `{"version":1, "acount_name":"ops"}`)
+	l.fail_with("Could not parse create_account request", "create_account", `{"version":1, "initial_balance":"lots"}`)
+}
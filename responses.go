@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// Invocations answer with one of these typed responses so callers get the ids that were created
+// and the state that resulted without a follow-up query. Queries keep returning the records themselves.
+
+type AccountBalance struct {
+	Account_id int64   `json:"account_id"`
+	Balance    float64 `json:"balance"`
+	Currency   string  `json:"currency"`
+}
+
+type AccountResponse struct {
+	Version    int      `json:"version"`
+	Guava_id   string   `json:"guava_id"`
+	Account_id int64    `json:"account_id"`
+	Account    *Account `json:"account"`
+}
+
+type TransferResponse struct {
+	Version     int              `json:"version"`
+	Transfer_id int64            `json:"transfer_id"`
+	Status      string           `json:"status"`
+	Transfer    *Transfer        `json:"transfer"`
+	Balances    []AccountBalance `json:"balances"` //resulting balances of the accounts the transfer touched
+}
+
+type FxRatesResponse struct {
+	Version  int      `json:"version"`
+	Guava_id string   `json:"guava_id"`
+	Rates    *FxRates `json:"rates"`
+}
+
+type StatementResponse struct {
+	Version int              `json:"version"`
+	Import  *StatementImport `json:"import"`
+}
+
+type ExportResponse struct {
+	Version  int            `json:"version"`
+	Export   *PaymentExport `json:"export"`
+	Document string         `json:"document"` //the pain.001 XML document
+}
+
+type WhitelistResponse struct {
+	Version   int                    `json:"version"`
+	Guava_id  string                 `json:"guava_id"`
+	Whitelist *CounterpartyWhitelist `json:"whitelist"`
+}
+
+type LimitsResponse struct {
+	Version  int             `json:"version"`
+	Guava_id string          `json:"guava_id"`
+	Limits   *TransferLimits `json:"limits"`
+}
+
+type RoleResponse struct {
+	Version  int    `json:"version"`
+	Guava_id string `json:"guava_id"`
+	Role     *Role  `json:"role"`
+}
+
+type UserResponse struct {
+	Version  int    `json:"version"`
+	Guava_id string `json:"guava_id"`
+	User     *User  `json:"user"`
+}
+
+type BatchResponse struct {
+	Version      int     `json:"version"`
+	Batch_id     int64   `json:"batch_id"`
+	Status       string  `json:"status"`
+	Transfer_ids []int64 `json:"transfer_ids"`
+}
+
+type PolicyResponse struct {
+	Version  int             `json:"version"`
+	Guava_id string          `json:"guava_id"`
+	Policy   *ApprovalPolicy `json:"policy"`
+}
+
+type TTLResponse struct {
+	Version  int              `json:"version"`
+	Guava_id string           `json:"guava_id"`
+	Ttls     map[string]int64 `json:"ttls"`
+}
+
+type ExpireResponse struct {
+	Version      int     `json:"version"`
+	Guava_id     string  `json:"guava_id"`
+	Transfer_ids []int64 `json:"transfer_ids"`
+}
+
+type MigrateResponse struct {
+	Version   int            `json:"version"`
+	Start_key string         `json:"start_key"`
+	Next_key  string         `json:"next_key"` //where the next page starts, empty once done
+	Scanned   int            `json:"scanned"`  //keys looked at in this page
+	Migrated  map[string]int `json:"migrated"` //records rewritten in this page by kind
+	Done      bool           `json:"done"`
+}
+
+type SchemaResponse struct {
+	Version   int                    `json:"version"`
+	Current   map[string]int         `json:"current"`   //schema written for each kind of record
+	Records   map[string]map[int]int `json:"records"`   //stored records of each kind by schema version in this page
+	Pending   map[string]int         `json:"pending"`   //records of each kind in this page that migrate would rewrite or move
+	Legacy    int                    `json:"legacy"`    //records in this page still stored under legacy keys
+	Remaining int                    `json:"remaining"` //records in this page that migrate would rewrite or move
+	Start_key string                 `json:"start_key"`
+	Next_key  string                 `json:"next_key"` //where the next page starts, empty once done
+	Scanned   int                    `json:"scanned"`  //keys looked at in this page
+	Done      bool                   `json:"done"`
+}
+
+func balance_of(acc *Account) AccountBalance {
+	return AccountBalance{Account_id: acc.AccountID, Balance: acc.Balance, Currency: acc.Currency}
+}
+
+func account_response(guava_id string, acc *Account) ([]byte, error) {
+
+	respAsBytes, _ := json.Marshal(AccountResponse{Version: ApiVersion, Guava_id: guava_id, Account_id: acc.AccountID, Account: acc})
+	return respAsBytes, nil
+}
+
+func transfer_response(transl *Transfer, accounts ...*Account) ([]byte, error) {
+
+	// a payment has no receiving account to report
+	balances := make([]AccountBalance, 0, len(accounts))
+	for i := 0; i < len(accounts); i++ {
+		if accounts[i] != nil {
+			balances = append(balances, balance_of(accounts[i]))
+		}
+	}
+
+	respAsBytes, _ := json.Marshal(TransferResponse{Version: ApiVersion, Transfer_id: transl.Transfer_id, Status: transl.Status, Transfer: transl, Balances: balances})
+	return respAsBytes, nil
+}
+
+func batch_response(batch *Batch) ([]byte, error) {
+
+	transfer_ids := make([]int64, 0, len(batch.Items))
+	for i := 0; i < len(batch.Items); i++ {
+		transfer_ids = append(transfer_ids, batch.Items[i].Transfer_id)
+	}
+
+	respAsBytes, _ := json.Marshal(BatchResponse{Version: ApiVersion, Batch_id: batch.Batch_id, Status: batch.Status, Transfer_ids: transfer_ids})
+	return respAsBytes, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// ============================================================================================================================
+// reverse_transfer - refund part or all of an approved transfer with a linked compensating transfer
+// <from_id, trans_id, amount, actor, reason>, amount is in the currency of the account that received the original.
+// A payment was paid out of the ledger, so its reversal returns funds from outside it with a From of 0
+// ============================================================================================================================
+
+func (t *GuavaChaincode) reverse_transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var sending_id, transfer_id, actor, reason string
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5 arguments <from_id, trans_id, amount, actor, reason>")
+	}
+
+	sending_id = args[0]
+	transfer_id = args[1]
+	actor = args[3]
+	reason = args[4]
+
+	tran_id_int, err := strconv.ParseInt(transfer_id, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid transfer id " + transfer_id)
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Reversal amount must be a positive number, got " + args[2])
+	}
+
+	sending_acc, err := get_account(stub, sending_id)
+	if err != nil {
+		return nil, errors.New("Could not find the account that sent the original transfer " + sending_id)
+	}
+
+	original := find_transfer(sending_acc.OutgoingTransfer, tran_id_int)
+	if original == nil {
+		return nil, errors.New("The transfer id was not found: " + transfer_id)
+	}
+	// once a payment is sent to the bank the ledger can no longer take it back
+	if original.Export_reference != "" {
+		return nil, errors.New("Transfer " + transfer_id + " was exported in " + original.Export_reference + " and can not be reversed")
+	}
+	if original.Settled_time != "" {
+		return nil, errors.New("Transfer " + transfer_id + " was settled by statement " + original.Statement_id + " and can not be reversed")
+	}
+	if strings.Compare(original.Status, "approved") != 0 && strings.Compare(original.Status, "partially_reversed") != 0 {
+		return nil, errors.New("Transfer " + transfer_id + " has not been approved, status is " + original.Status)
+	}
+
+	guava_id := sending_acc.Guava_id
+	user, err := get_actor(stub, guava_id, actor)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := user_allowed(stub, guava_id, user, sending_acc.AccountID, "owner", "approve", "reverse_transfer")
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errors.New("User " + actor + " may not reverse transfers of guava " + guava_id)
+	}
+
+	remaining := original.Inc_value - original.Reversed_value
+	if amount > remaining {
+		return nil, errors.New("Reversal amount exceeds the " + strconv.FormatFloat(remaining, 'f', -1, 64) + " left to reverse on transfer " + transfer_id)
+	}
+
+	receiving_id := strconv.FormatInt(original.To, 10)
+	var receiving_acc *Account
+	if !credits_receiver(original) {
+		receiving_id = "0"
+	} else if original.To == original.From {
+		receiving_acc = sending_acc
+	} else {
+		receiving_acc, err = get_account(stub, receiving_id)
+		if err != nil {
+			return nil, errors.New("Could not find the account that received the original transfer " + receiving_id)
+		}
+	}
+
+	// the receiving account returns amount, the sender gets back the same share of what it paid
+	if receiving_acc != nil && receiving_acc.Balance < amount {
+		return nil, errors.New("receiving account does not have enough funds to cover the reversal " + receiving_id)
+	}
+	refund := amount * original.Dec_value / original.Inc_value
+
+	created, err := tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	trans_id, err := next_ids(stub, "transfer", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	from_id := int64(0)
+	if receiving_acc != nil {
+		from_id = receiving_acc.AccountID
+	}
+
+	reversal := Transfer{
+		From:        from_id,
+		To:          original.From,
+		Dec_value:   amount,
+		Inc_value:   refund,
+		Fx_rate:     refund / amount,
+		Message:     reason,
+		Status:      "approved",
+		T_Type:      ReversalTransfer,
+		Creator:     actor,
+		Approver:    actor,
+		Time:        created,
+		Transfer_id: trans_id,
+		Created:     created,
+		Reversal_of: original.Transfer_id,
+		Schema:      TransferSchema}
+
+	events := []GuavaEvent{transfer_event("transfer_reversed", sending_acc, original)}
+
+	// the original is held by both accounts, keep the two copies in step
+	mark_reversed(original, amount, trans_id)
+	if receiving_acc != nil {
+		receiving_acc.Balance = receiving_acc.Balance - amount
+		incoming := find_transfer(receiving_acc.IncomingTransfer, original.Transfer_id)
+		if incoming != nil {
+			mark_reversed(incoming, amount, trans_id)
+		}
+		receiving_acc.OutgoingTransfer = append(receiving_acc.OutgoingTransfer, reversal)
+
+		err = put_account(stub, receiving_acc)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, transfer_event("transfer_created", receiving_acc, &reversal))
+		events = append(events, account_event("balance_changed", receiving_acc, -amount))
+	} else {
+		events = append(events, transfer_event("transfer_created", sending_acc, &reversal))
+	}
+
+	sending_acc.Balance = sending_acc.Balance + refund
+	sending_acc.IncomingTransfer = append(sending_acc.IncomingTransfer, reversal)
+
+	err = put_account(stub, sending_acc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_transfer_index(stub, &reversal)
+	if err != nil {
+		return nil, err
+	}
+
+	events = append(events, account_event("balance_changed", sending_acc, refund))
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer_response(&reversal, receiving_acc, sending_acc)
+}
+
+// ============================================================================================================================
+// mark_reversed - record a reversal of amount against a transfer
+// ============================================================================================================================
+
+func mark_reversed(transl *Transfer, amount float64, reversal_id int64) {
+
+	transl.Reversed_value = transl.Reversed_value + amount
+	transl.Reversals = append(transl.Reversals, reversal_id)
+
+	if transl.Reversed_value >= transl.Inc_value {
+		transl.Status = "reversed"
+	} else {
+		transl.Status = "partially_reversed"
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestReverseTransfer(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("create_transfer", "sweep", "1", "400", "400", "1", "2", "internal", "t", "bob", "")
+
+	var resp TransferResponse
+	l.decode(l.ok("reverse_transfer", "1", "1", "150", "carol", "overpaid"), &resp)
+	if resp.Transfer_id != 2 || resp.Transfer.Reversal_of != 1 || resp.Transfer.From != 2 || resp.Transfer.To != 1 || resp.Transfer.T_Type != "reversal" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	l.balance(1, 750)
+	l.balance(2, 750)
+
+	original := l.transfer(1, 1)
+	if original.Status != "partially_reversed" || original.Reversed_value != 150 || len(original.Reversals) != 1 || original.Reversals[0] != 2 {
+		t.Fatalf("unexpected original %+v", original)
+	}
+	if incoming := find_transfer(l.account(2).IncomingTransfer, 1); incoming == nil || incoming.Status != "partially_reversed" {
+		t.Fatalf("receiving copy of the original was not updated: %+v", incoming)
+	}
+
+	l.fail_with("exceeds the 250 left to reverse", "reverse_transfer", "1", "1", "251", "carol", "too much")
+
+	l.ok("reverse_transfer", "1", "1", "250", "alice", "rest")
+	l.balance(1, 1000)
+	l.balance(2, 500)
+	if status := l.transfer(1, 1).Status; status != "reversed" {
+		t.Fatalf("status = %s, want reversed", status)
+	}
+	l.fail_with("has not been approved", "reverse_transfer", "1", "1", "1", "carol", "again")
+}
+
+func TestReverseTransferFx(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("create_account", "usd", "1", "USD", "US", "OPR", "0", "root")
+
+	// account 1 paid 100 for 50 in the receiving currency, refunding 25 returns 50
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "fx", "0.5", "50", "100", "1", "3", "internal", "t", "bob", ""), &resp)
+	l.ok("accept_transfer", "3", "1", format_int(resp.Transfer_id), "100", "50", "erin")
+	l.ok("reverse_transfer", "1", format_int(resp.Transfer_id), "25", "carol", "half")
+
+	l.balance(1, 950)
+	l.balance(3, 25)
+}
+
+func TestReverseTransferErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	pending := strconv.FormatInt(l.payment("100"), 10)
+	l.fail_with("has not been approved", "reverse_transfer", "1", pending, "100", "carol", "r")
+
+	l.ok("create_transfer", "sweep", "1", "400", "400", "1", "2", "internal", "t", "bob", "")
+	l.fail_with("may not reverse", "reverse_transfer", "1", "2", "100", "bob", "r")
+	l.fail_with("positive number", "reverse_transfer", "1", "2", "0", "carol", "r")
+	l.fail_with("The transfer id was not found", "reverse_transfer", "1", "9", "100", "carol", "r")
+
+	// the receiving account has already spent the money
+	l.ok("decrement_value", "2", "800", "alice")
+	l.fail_with("does not have enough funds to cover the reversal", "reverse_transfer", "1", "2", "400", "carol", "r")
+	l.balance(1, 600)
+	l.balance(2, 100)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// A user holds roles in a guava. The built-in roles owner, create, approve and read each grant the access right of the
+// same name and are what the four user flags stand for. Owners define further roles per guava, each a list of access
+// rights and function names, and assign them to users either across the guava or for particular accounts only.
+
+var BuiltinRoles = []string{"owner", "create", "approve", "read"}
+
+type Role struct {
+	Guava_id    string   `json:"guava_id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"` //access rights <owner,create,approve,read> and function names the role grants
+	Schema      int      `json:"schema"`      //layout version the role was written with
+}
+
+type RoleGrant struct {
+	Role     string  `json:"role"`     //built-in or guava defined role
+	Accounts []int64 `json:"accounts"` //accounts the role applies to, empty for every account of the guava
+}
+
+// ============================================================================================================================
+// define_role - create or replace a role of a guava, owners only <guava_id, role, permissions_json, actor>
+// permissions_json is an array of access rights and function names
+// ============================================================================================================================
+
+func (t *GuavaChaincode) define_role(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, role, permissions_json, actor>")
+	}
+
+	role := Role{Guava_id: args[0], Name: args[1], Schema: RoleSchema}
+
+	if role.Name == "" || is_user_role(role.Name) {
+		return nil, errors.New("Role name " + strconv.Quote(role.Name) + " is empty or built in")
+	}
+
+	err := json.Unmarshal([]byte(args[2]), &role.Permissions)
+	if err != nil {
+		return nil, errors.New("Could not parse permissions_json: " + err.Error())
+	}
+	if len(role.Permissions) == 0 {
+		return nil, errors.New("Role " + role.Name + " must grant at least one permission")
+	}
+
+	for i := 0; i < len(role.Permissions); i++ {
+		if _, ok := routes[role.Permissions[i]]; !ok && !is_user_role(role.Permissions[i]) {
+			return nil, errors.New("Unknown permission " + role.Permissions[i] + ", expecting an access right or a function name")
+		}
+	}
+
+	err = put_record(stub, &role, RoleKey, role.Guava_id, role.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(RoleResponse{Version: ApiVersion, Guava_id: role.Guava_id, Role: &role})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// list_roles - list the built-in roles and the roles defined in a guava <guava_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) list_roles(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, caller>")
+	}
+
+	guava_id := args[0]
+
+	roles := make([]Role, 0)
+	for i := 0; i < len(BuiltinRoles); i++ {
+		roles = append(roles, Role{Guava_id: guava_id, Name: BuiltinRoles[i], Permissions: []string{BuiltinRoles[i]}, Schema: RoleSchema})
+	}
+
+	iter, err := stub.GetStateByPartialCompositeKey(RoleKey, []string{guava_id})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		role := Role{}
+		err = json.Unmarshal(kv.Value, &role)
+		if err != nil {
+			return nil, errors.New("Could not decode a role of guava " + guava_id)
+		}
+		upgrade_role(&role)
+		roles = append(roles, role)
+	}
+
+	rolesAsBytes, _ := json.Marshal(roles)
+	return rolesAsBytes, nil
+}
+
+// ============================================================================================================================
+// assign_role - give a user a role, replacing any earlier grant of it, owners only <guava_id, username, role, accounts_json, actor>
+// accounts_json is an array of account ids of the guava the role is limited to, [] for the whole guava
+// ============================================================================================================================
+
+func (t *GuavaChaincode) assign_role(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5 arguments <guava_id, username, role, accounts_json, actor>")
+	}
+
+	guava_id := args[0]
+	grant := RoleGrant{Role: args[2]}
+
+	_, err := get_role(stub, guava_id, grant.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(args[3]), &grant.Accounts)
+	if err != nil {
+		return nil, errors.New("Could not parse accounts_json: " + err.Error())
+	}
+	if grant.Accounts == nil {
+		grant.Accounts = make([]int64, 0)
+	}
+
+	for i := 0; i < len(grant.Accounts); i++ {
+		account_id := strconv.FormatInt(grant.Accounts[i], 10)
+		acc, err := get_account(stub, account_id)
+		if err != nil || strings.Compare(acc.Guava_id, guava_id) != 0 {
+			return nil, errors.New("Account " + account_id + " is not in guava " + guava_id)
+		}
+	}
+
+	user, err := find_guava_user(stub, guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// limiting a grant to some accounts can take away an owner right the user held across the guava
+	user.Roles = append(remove_grant(user.Roles, grant.Role), grant)
+	err = check_owner_kept(stub, guava_id, user, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return change_user(stub, guava_id, user, "role_assigned", args[4])
+}
+
+// ============================================================================================================================
+// revoke_role - take a role away from a user, owners only <guava_id, username, role, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) revoke_role(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, username, role, actor>")
+	}
+
+	guava_id := args[0]
+	role := args[2]
+
+	user, err := find_guava_user(stub, guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if grant_index(user, role) < 0 {
+		return nil, errors.New("User " + user.Username + " has not been assigned the role " + role + " in guava " + guava_id)
+	}
+
+	user.Roles = remove_grant(user.Roles, role)
+	err = check_owner_kept(stub, guava_id, user, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return change_user(stub, guava_id, user, "role_revoked", args[3])
+}
+
+// ============================================================================================================================
+// user_allowed - check a user holds any of the permissions, access rights or function names, for an account of the guava
+// account_id 0 means the guava as a whole, where only roles granted across the guava count
+// ============================================================================================================================
+
+func user_allowed(stub shim.ChaincodeStubInterface, guava_id string, user *User, account_id int64, permissions ...string) (bool, error) {
+
+	if user == nil || user.Disabled {
+		return false, nil
+	}
+
+	for i := 0; i < len(permissions); i++ {
+		if user_has_role(user, permissions[i]) {
+			return true, nil
+		}
+	}
+
+	for i := 0; i < len(user.Roles); i++ {
+		grant := user.Roles[i]
+		if !grant_covers(grant, account_id) {
+			continue
+		}
+
+		role, err := get_role(stub, guava_id, grant.Role)
+		if err != nil {
+			// a role that was assigned and is no longer defined grants nothing
+			continue
+		}
+
+		for j := 0; j < len(role.Permissions); j++ {
+			for k := 0; k < len(permissions); k++ {
+				if strings.Compare(role.Permissions[j], permissions[k]) == 0 {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// ============================================================================================================================
+// get_role - load a built-in role or a role defined in a guava
+// ============================================================================================================================
+
+func get_role(stub shim.ChaincodeStubInterface, guava_id string, name string) (*Role, error) {
+
+	if is_user_role(name) {
+		return &Role{Guava_id: guava_id, Name: name, Permissions: []string{name}, Schema: RoleSchema}, nil
+	}
+
+	role := Role{}
+	found, err := get_record(stub, &role, RoleKey, guava_id, name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Could not find role " + name + " in guava " + guava_id)
+	}
+	upgrade_role(&role)
+
+	return &role, nil
+}
+
+// user_holds_role - check a user holds any of the roles for an account, built-in roles by their access right and
+// guava defined roles by a grant of that name covering the account
+func user_holds_role(user *User, account_id int64, roles ...string) bool {
+
+	if user == nil || user.Disabled {
+		return false
+	}
+
+	for i := 0; i < len(roles); i++ {
+		if is_user_role(roles[i]) {
+			if user_has_role(user, roles[i]) {
+				return true
+			}
+			continue
+		}
+
+		for j := 0; j < len(user.Roles); j++ {
+			if strings.Compare(user.Roles[j].Role, roles[i]) == 0 && grant_covers(user.Roles[j], account_id) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func grant_covers(grant RoleGrant, account_id int64) bool {
+
+	if len(grant.Accounts) == 0 {
+		return true
+	}
+
+	for i := 0; i < len(grant.Accounts); i++ {
+		if grant.Accounts[i] == account_id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func grant_index(user *User, role string) int {
+
+	for i := 0; i < len(user.Roles); i++ {
+		if strings.Compare(user.Roles[i].Role, role) == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func remove_grant(grants []RoleGrant, role string) []RoleGrant {
+
+	kept := make([]RoleGrant, 0, len(grants))
+	for i := 0; i < len(grants); i++ {
+		if strings.Compare(grants[i].Role, role) != 0 {
+			kept = append(kept, grants[i])
+		}
+	}
+
+	return kept
+}
+
+// check_owner_kept fails if the change to user takes away the last owner of the guava
+func check_owner_kept(stub shim.ChaincodeStubInterface, guava_id string, user *User, username string) error {
+
+	if user_has_role(user, "owner") {
+		return nil
+	}
+
+	before, err := find_guava_user(stub, guava_id, username)
+	if err != nil {
+		return err
+	}
+
+	return check_last_owner(stub, guava_id, before)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDefineAndListRoles(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp RoleResponse
+	l.decode(l.ok("define_role", "1", "treasurer", `["approve", "reverse_transfer"]`, "alice"), &resp)
+	if resp.Role.Name != "treasurer" || len(resp.Role.Permissions) != 2 || resp.Role.Schema != RoleSchema {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	l.ok("define_role", "1", "auditor", `["read"]`, "alice")
+
+	var roles []Role
+	l.decode(l.ok("list_roles", "1", "dave"), &roles)
+	if len(roles) != 6 || roles[0].Name != "owner" || roles[4].Name != "auditor" || roles[5].Name != "treasurer" {
+		t.Fatalf("unexpected roles %+v", roles)
+	}
+
+	l.fail_with("Unknown permission transfer_everything", "define_role", "1", "fx-desk", `["transfer_everything"]`, "alice")
+	l.fail_with("is empty or built in", "define_role", "1", "approve", `["read"]`, "alice")
+	l.fail_with("must grant at least one permission", "define_role", "1", "fx-desk", `[]`, "alice")
+	l.fail_with("does not have the owner permission", "define_role", "1", "fx-desk", `["read"]`, "bob")
+}
+
+func TestRoleScopedToAccount(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("define_role", "1", "treasurer", `["approve"]`, "alice")
+	l.ok("assign_role", "1", "dave", "treasurer", `[1]`, "alice")
+
+	// dave approves transfers sent from account 1 only
+	l.ok("accept_transfer", "2", "1", format_int(l.payment("10")), "10", "10", "dave")
+	l.balance(1, 990)
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "back", "1", "5", "5", "2", "1", "internal", "t", "bob", ""), &resp)
+	l.fail_with("User dave does not have the approve permission in guava 1", "accept_transfer", "1", "2", format_int(resp.Transfer_id), "5", "5", "dave")
+
+	// the approval policy sees the scoped right as well
+	l.ok("set_approval_policy", "1", "alice", `{"approver_roles":["approve"]}`)
+	l.ok("accept_transfer", "2", "1", format_int(l.payment("10")), "10", "10", "dave")
+
+	// a role can grant a single function, and redefining a role changes what its holders may do
+	l.ok("define_role", "1", "payment-initiator", `["create_transfer"]`, "alice")
+	l.ok("assign_role", "1", "dave", "payment-initiator", `[]`, "alice")
+	l.ok("create_transfer", "m", "1", "1", "1", "2", "1", "internal", "t", "dave", "")
+	l.ok("define_role", "1", "payment-initiator", `["get_account"]`, "alice")
+	l.fail_with("does not have the create permission", "create_transfer", "m", "1", "1", "1", "2", "1", "internal", "t", "dave", "")
+
+	// disabling a user takes its roles away too
+	l.ok("disable_user", "1", "dave", "alice")
+	l.fail_with("does not have the approve permission", "accept_transfer", "2", "1", format_int(l.payment("10")), "10", "10", "dave")
+}
+
+func TestAssignAndRevokeRoles(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "remote", "-1", "CAD", "CA", "OPR", "0", "root")
+
+	l.fail_with("Could not find role fx-desk in guava 1", "assign_role", "1", "dave", "fx-desk", `[]`, "alice")
+	l.fail_with("Account 3 is not in guava 1", "assign_role", "1", "dave", "approve", `[3]`, "alice")
+	l.fail_with("Could not find user mallory in guava 1", "assign_role", "1", "mallory", "approve", `[]`, "alice")
+	l.fail_with("does not have the owner permission", "assign_role", "1", "dave", "approve", `[]`, "bob")
+
+	// a built-in role can be assigned for some accounts, assigning it again replaces the grant
+	var resp UserResponse
+	l.ok("assign_role", "1", "dave", "approve", `[1]`, "alice")
+	l.decode(l.ok("assign_role", "1", "dave", "approve", `[1, 2]`, "alice"), &resp)
+	if len(resp.User.Roles) != 1 || len(resp.User.Roles[0].Accounts) != 2 {
+		t.Fatalf("unexpected roles %+v", resp.User.Roles)
+	}
+
+	l.decode(l.ok("revoke_role", "1", "dave", "approve", "alice"), &resp)
+	if len(resp.User.Roles) != 0 {
+		t.Fatalf("role was not revoked %+v", resp.User.Roles)
+	}
+	l.fail_with("User dave has not been assigned the role approve in guava 1", "revoke_role", "1", "dave", "approve", "alice")
+
+	// the owner role across the guava makes dave an owner, so alice can step down but dave then can not
+	l.ok("assign_role", "1", "dave", "owner", `[]`, "alice")
+	l.ok("update_user", "1", "alice", "false", "true", "true", "true", "dave")
+	l.fail_with("User dave is the last owner of guava 1", "revoke_role", "1", "dave", "owner", "dave")
+	l.fail_with("User dave is the last owner of guava 1", "assign_role", "1", "dave", "owner", `[1]`, "dave")
+
+	var history UserHistory
+	l.decode(l.ok("read_user_history", "1", "dave", "dave"), &history)
+	last := history.Changes[len(history.Changes)-1]
+	if last.Action != "role_assigned" || last.By != "alice" || len(last.Roles) != 1 || last.Roles[0].Role != "owner" {
+		t.Fatalf("unexpected last change %+v", last)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Every function is registered here once with its arguments, the access right the acting user needs
+// and whether it writes to the ledger. Invoke dispatches through route, which decodes JSON requests,
+// checks the argument count, authorizes the acting user and logs the outcome. The acting user must be
+// bound to the client identity that signed the transaction (identity.go).
+
+type handler func(t *GuavaChaincode, stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+type Route struct {
+	Name       string   `json:"name"`
+	Args       []string `json:"args"`       //positional argument names
+	Permission string   `json:"permission"` //access right the actor needs <owner,create,approve,read>, admin for the chaincode admin, empty if the function checks for itself
+	Or         string   `json:"or"`         //another access right that also allows the call, the function then checks which one applies
+	Actor      string   `json:"actor"`      //argument holding the username of the acting user
+	Guava      string   `json:"guava"`      //argument holding the guava the permission applies to
+	Account    string   `json:"account"`    //argument holding an account whose guava the permission applies to
+	Transfer   string   `json:"transfer"`   //argument holding a transfer, the permission applies in its sending or receiving guava
+	Items      string   `json:"items"`      //argument holding transfers_json, the permission applies on the from account of every item
+	Batch      string   `json:"batch"`      //argument holding a batch, the permission applies on the sending account of every transfer in it
+	Writes     bool     `json:"writes"`     //false for queries
+	Admin      bool     `json:"admin"`      //the chaincode admin may call it without holding the permission
+	Legacy     bool     `json:"legacy"`     //it writes while records are still under legacy keys, other writes wait for migrate
+
+	handler     handler
+	new_request func() request
+}
+
+var routes = make(map[string]*Route)
+
+func register(route *Route) {
+
+	for _, name := range []string{route.Actor, route.Guava, route.Account, route.Transfer, route.Items, route.Batch} {
+		if name != "" && route.arg_index(name) < 0 {
+			panic("route " + route.Name + " has no argument " + name)
+		}
+	}
+
+	routes[route.Name] = route
+}
+
+func init() {
+
+	// init_state decodes its own request since Init also calls it directly on instantiate
+	register(&Route{Name: "init", Args: []string{"value", "admin"}, Writes: true,
+		Permission: "admin", Actor: "admin",
+		handler: (*GuavaChaincode).init_state})
+
+	register(&Route{Name: "create_account", Args: []string{"account_name", "guava_id", "currency", "country", "acctype", "initial_balance", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id", Admin: true,
+		handler: (*GuavaChaincode).create_account, new_request: func() request { return &CreateAccountRequest{} }})
+
+	register(&Route{Name: "create_transfer", Args: []string{"message", "fx_rate", "value_inc", "value_dec", "from_id", "to_id", "trans_type", "time", "creator", "beneficiary_json"}, Writes: true,
+		Permission: "create", Actor: "creator", Account: "from_id",
+		handler: (*GuavaChaincode).create_transfer, new_request: func() request { return &CreateTransferRequest{} }})
+
+	register(&Route{Name: "increment_value", Args: []string{"account_id", "value", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Account: "account_id",
+		handler: (*GuavaChaincode).increment_value, new_request: func() request { return &ValueRequest{} }})
+
+	register(&Route{Name: "decrement_value", Args: []string{"account_id", "value", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Account: "account_id",
+		handler: (*GuavaChaincode).decrement_value, new_request: func() request { return &ValueRequest{} }})
+
+	register(&Route{Name: "accept_transfer", Args: []string{"to_id", "from_id", "transfer_id", "dec_value", "inc_value", "approver"}, Writes: true,
+		Permission: "approve", Actor: "approver", Account: "from_id",
+		handler: (*GuavaChaincode).accept_transfer, new_request: func() request { return &AcceptTransferRequest{} }})
+
+	register(&Route{Name: "reject_transfer", Args: []string{"from_id", "trans_id", "approver"}, Writes: true,
+		Permission: "approve", Actor: "approver", Account: "from_id",
+		handler: (*GuavaChaincode).reject_transfer, new_request: func() request { return &RejectTransferRequest{} }})
+
+	register(&Route{Name: "cancel_transfer", Args: []string{"from_id", "trans_id", "actor", "reason"}, Writes: true,
+		Permission: "create", Or: "owner", Actor: "actor", Account: "from_id",
+		handler: (*GuavaChaincode).cancel_transfer, new_request: func() request { return &CancelTransferRequest{} }})
+
+	register(&Route{Name: "reverse_transfer", Args: []string{"from_id", "trans_id", "amount", "actor", "reason"}, Writes: true,
+		Actor: "actor", Account: "from_id",
+		handler: (*GuavaChaincode).reverse_transfer, new_request: func() request { return &ReverseTransferRequest{} }})
+
+	register(&Route{Name: "create_user", Args: []string{"username", "owner", "create", "approve", "read", "guava_id", "identity", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id", Admin: true,
+		handler: (*GuavaChaincode).create_user, new_request: func() request { return &CreateUserRequest{} }})
+
+	register(&Route{Name: "update_user", Args: []string{"guava_id", "username", "owner", "create", "approve", "read", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).update_user, new_request: func() request { return &UpdateUserRequest{} }})
+
+	register(&Route{Name: "set_user_identity", Args: []string{"guava_id", "username", "identity", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id", Admin: true,
+		handler: (*GuavaChaincode).set_user_identity, new_request: func() request { return &UserIdentityRequest{} }})
+
+	register(&Route{Name: "disable_user", Args: []string{"guava_id", "username", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).disable_user, new_request: func() request { return &ManageUserRequest{} }})
+
+	register(&Route{Name: "remove_user", Args: []string{"guava_id", "username", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).remove_user, new_request: func() request { return &ManageUserRequest{} }})
+
+	register(&Route{Name: "define_role", Args: []string{"guava_id", "role", "permissions_json", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).define_role, new_request: func() request { return &DefineRoleRequest{} }})
+
+	register(&Route{Name: "assign_role", Args: []string{"guava_id", "username", "role", "accounts_json", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).assign_role, new_request: func() request { return &AssignRoleRequest{} }})
+
+	register(&Route{Name: "revoke_role", Args: []string{"guava_id", "username", "role", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).revoke_role, new_request: func() request { return &RevokeRoleRequest{} }})
+
+	register(&Route{Name: "create_batch_transfer", Args: []string{"creator", "time", "transfers_json"}, Writes: true,
+		Permission: "create", Actor: "creator", Items: "transfers_json",
+		handler: (*GuavaChaincode).create_batch_transfer, new_request: func() request { return &CreateBatchTransferRequest{} }})
+
+	register(&Route{Name: "accept_batch", Args: []string{"batch_id", "approver"}, Writes: true,
+		Permission: "approve", Actor: "approver", Batch: "batch_id",
+		handler: (*GuavaChaincode).accept_batch, new_request: func() request { return &BatchRequest{} }})
+
+	register(&Route{Name: "reject_batch", Args: []string{"batch_id", "approver"}, Writes: true,
+		Permission: "approve", Actor: "approver", Batch: "batch_id",
+		handler: (*GuavaChaincode).reject_batch, new_request: func() request { return &BatchRequest{} }})
+
+	register(&Route{Name: "set_approval_policy", Args: []string{"guava_id", "owner", "policy_json"}, Writes: true,
+		Permission: "owner", Actor: "owner", Guava: "guava_id",
+		handler: (*GuavaChaincode).set_approval_policy, new_request: func() request { return &ApprovalPolicyRequest{} }})
+
+	register(&Route{Name: "set_transfer_ttl", Args: []string{"guava_id", "owner", "trans_type", "ttl_seconds"}, Writes: true,
+		Permission: "owner", Actor: "owner", Guava: "guava_id",
+		handler: (*GuavaChaincode).set_transfer_ttl, new_request: func() request { return &TransferTTLRequest{} }})
+
+	register(&Route{Name: "export_payments", Args: []string{"guava_id", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).export_payments, new_request: func() request { return &GuavaActorRequest{} }})
+
+	register(&Route{Name: "set_bank_account", Args: []string{"account_id", "bank_json", "owner"}, Writes: true,
+		Permission: "owner", Actor: "owner", Account: "account_id",
+		handler: (*GuavaChaincode).set_bank_account, new_request: func() request { return &BankAccountRequest{} }})
+
+	register(&Route{Name: "read_payment_export", Args: []string{"guava_id", "export_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_payment_export, new_request: func() request { return &PaymentExportRequest{} }})
+
+	register(&Route{Name: "set_fx_rates", Args: []string{"guava_id", "base_currency", "rates_json", "owner"}, Writes: true,
+		Permission: "owner", Actor: "owner", Guava: "guava_id",
+		handler: (*GuavaChaincode).set_fx_rates, new_request: func() request { return &FxRatesRequest{} }})
+
+	register(&Route{Name: "read_fx_rates", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_fx_rates, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_guava_position", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_guava_position, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_balance_at", Args: []string{"account_id", "as_of", "caller"},
+		Permission: "read", Actor: "caller", Account: "account_id",
+		handler: (*GuavaChaincode).read_balance_at, new_request: func() request { return &BalanceAtRequest{} }})
+
+	register(&Route{Name: "read_guava_balance_at", Args: []string{"guava_id", "as_of", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_guava_balance_at, new_request: func() request { return &GuavaBalanceAtRequest{} }})
+
+	register(&Route{Name: "read_account_statement", Args: []string{"account_id", "from_date", "to_date", "format", "caller"},
+		Permission: "read", Actor: "caller", Account: "account_id",
+		handler: (*GuavaChaincode).read_account_statement, new_request: func() request { return &AccountStatementRequest{} }})
+
+	register(&Route{Name: "import_statement", Args: []string{"guava_id", "format", "statement", "actor"}, Writes: true,
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).import_statement, new_request: func() request { return &ImportStatementRequest{} }})
+
+	register(&Route{Name: "read_statement_import", Args: []string{"guava_id", "statement_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_statement_import, new_request: func() request { return &StatementImportRequest{} }})
+
+	register(&Route{Name: "set_counterparties", Args: []string{"guava_id", "whitelist_json", "owner"}, Writes: true,
+		Permission: "owner", Actor: "owner", Guava: "guava_id",
+		handler: (*GuavaChaincode).set_counterparties, new_request: func() request { return &CounterpartiesRequest{} }})
+
+	register(&Route{Name: "set_transfer_limits", Args: []string{"guava_id", "scope", "subject", "limits_json", "owner"}, Writes: true,
+		Permission: "owner", Actor: "owner", Guava: "guava_id",
+		handler: (*GuavaChaincode).set_transfer_limits, new_request: func() request { return &TransferLimitsRequest{} }})
+
+	register(&Route{Name: "expire_transfers", Args: []string{"guava_id", "actor"}, Writes: true,
+		Permission: "approve", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).expire_transfers, new_request: func() request { return &GuavaActorRequest{} }})
+
+	register(&Route{Name: "migrate", Args: []string{"start_key", "page_size", "actor"}, Writes: true,
+		Permission: "admin", Actor: "actor", Legacy: true,
+		handler: (*GuavaChaincode).migrate, new_request: func() request { return &MigrateRequest{} }})
+
+	register(&Route{Name: "get_account", Args: []string{"account_id", "caller"},
+		Permission: "read", Actor: "caller", Account: "account_id",
+		handler: (*GuavaChaincode).get_account, new_request: func() request { return &AccountIdRequest{} }})
+
+	register(&Route{Name: "get_transfer", Args: []string{"transfer_id", "caller"},
+		Permission: "read", Actor: "caller", Transfer: "transfer_id",
+		handler: (*GuavaChaincode).get_transfer, new_request: func() request { return &TransferIdRequest{} }})
+
+	register(&Route{Name: "get_user", Args: []string{"guava_id", "username", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).get_user, new_request: func() request { return &UserRequest{} }})
+
+	register(&Route{Name: "get_guava", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).get_guava, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "list_users", Args: []string{"guava_id", "actor"},
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).list_users, new_request: func() request { return &GuavaActorRequest{} }})
+
+	register(&Route{Name: "read_user_history", Args: []string{"guava_id", "username", "actor"},
+		Permission: "owner", Actor: "actor", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_user_history, new_request: func() request { return &ManageUserRequest{} }})
+
+	register(&Route{Name: "list_roles", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).list_roles, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_guava", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_guava, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_batch", Args: []string{"batch_id", "caller"},
+		Permission: "read", Actor: "caller", Batch: "batch_id",
+		handler: (*GuavaChaincode).read_batch, new_request: func() request { return &BatchIdRequest{} }})
+
+	register(&Route{Name: "read_approval_policy", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_approval_policy, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_transfer_ttl", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_transfer_ttl, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_counterparties", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_counterparties, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "read_limit_usage", Args: []string{"guava_id", "scope", "subject", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_limit_usage, new_request: func() request { return &LimitUsageRequest{} }})
+
+	register(&Route{Name: "read_expired_transfers", Args: []string{"guava_id", "caller"},
+		Permission: "read", Actor: "caller", Guava: "guava_id",
+		handler: (*GuavaChaincode).read_expired_transfers, new_request: func() request { return &GuavaCallerRequest{} }})
+
+	register(&Route{Name: "migration_status", Args: []string{"start_key", "page_size"},
+		handler: (*GuavaChaincode).migration_status, new_request: func() request { return &MigrationStatusRequest{} }})
+
+	register(&Route{Name: "read_identity", Args: []string{},
+		handler: (*GuavaChaincode).read_identity})
+
+	register(&Route{Name: "list_functions", Args: []string{},
+		handler: (*GuavaChaincode).list_functions})
+}
+
+// ============================================================================================================================
+// route - find, validate, authorize and run a registered function
+// ============================================================================================================================
+
+func (t *GuavaChaincode) route(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	fmt.Println("invoke is running " + function)
+
+	route, ok := routes[function]
+	if !ok {
+		fmt.Println("invoke did not find func: " + function) //error invoke function not found
+		return nil, errors.New("Received unknown function invocation")
+	}
+
+	args, err := route.decode(args)
+	if err == nil {
+		err = route.authorize(stub, args)
+	}
+
+	// a record written beside its legacy key would be overwritten when migrate moves the legacy record
+	if err == nil && route.Writes && !route.Legacy {
+		var legacy bool
+		legacy, err = has_legacy_keys(stub)
+		if err == nil && legacy {
+			err = errors.New("The ledger still holds records under legacy keys, the chaincode admin needs to run migrate first")
+		}
+	}
+
+	var result []byte
+	if err == nil {
+		result, err = route.handler(t, stub, args)
+	}
+
+	if err != nil {
+		fmt.Println("invoke " + function + " failed: " + err.Error())
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ============================================================================================================================
+// decode - turn a JSON request into positional arguments and check the argument count
+// ============================================================================================================================
+
+func (r *Route) decode(args []string) ([]string, error) {
+
+	if r.new_request != nil {
+		var err error
+		args, err = decode_request(r.Name, r.new_request, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) != len(r.Args) {
+		return nil, errors.New("Incorrect number of arguments. Expecting " + strconv.Itoa(len(r.Args)) + " arguments <" + strings.Join(r.Args, ", ") + ">")
+	}
+
+	return args, nil
+}
+
+// ============================================================================================================================
+// authorize - check the acting user is bound to the caller and holds the route permission in the guava the call applies to
+// ============================================================================================================================
+
+func (r *Route) authorize(stub shim.ChaincodeStubInterface, args []string) error {
+
+	if r.Permission == "" {
+		return nil
+	}
+
+	actor := args[r.arg_index(r.Actor)]
+
+	// the chaincode admin runs the functions outside any guava and may call those marked Admin
+	if r.Permission == "admin" || r.Admin {
+		admin, err := is_admin(stub, actor)
+		if err != nil {
+			return err
+		}
+		if admin {
+			return nil
+		}
+		if r.Permission == "admin" {
+			return errors.New("User " + actor + " is not the chaincode admin")
+		}
+	}
+
+	groups, err := r.scopes(stub, args)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(groups); i++ {
+		err = r.allowed_in(stub, actor, groups[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allowed_in - the route permission or a role granting the function itself will do in any one of the scopes
+func (r *Route) allowed_in(stub shim.ChaincodeStubInterface, actor string, scopes []scope) error {
+
+	for i := 0; i < len(scopes); i++ {
+		user, err := get_actor(stub, scopes[i].guava_id, actor)
+		if err != nil {
+			return err
+		}
+		allowed, err := user_allowed(stub, scopes[i].guava_id, user, scopes[i].account_id, r.permissions()...)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			return nil
+		}
+	}
+
+	return errors.New("User " + actor + " does not have the " + r.Permission + " permission in guava " + scopes[0].guava_id)
+}
+
+// permissions - the access rights that allow the call and the function name a role can grant it by
+func (r *Route) permissions() []string {
+
+	if r.Or != "" {
+		return []string{r.Permission, r.Or, r.Name}
+	}
+
+	return []string{r.Permission, r.Name}
+}
+
+// a guava the call applies to, and the account within it, 0 if the call applies to the guava as a whole
+type scope struct {
+	guava_id   string
+	account_id int64
+}
+
+// scopes - where the call applies, the permission is needed in one scope of every group
+// a transfer is one group of its accounts on the ledger, the items of a batch are a group each
+func (r *Route) scopes(stub shim.ChaincodeStubInterface, args []string) ([][]scope, error) {
+
+	if r.Guava != "" {
+		return [][]scope{{{guava_id: args[r.arg_index(r.Guava)]}}}, nil
+	}
+
+	account_groups := make([][]int64, 0, 1)
+	switch {
+	case r.Account != "":
+		account_id, err := strconv.ParseInt(args[r.arg_index(r.Account)], 10, 64)
+		if err != nil {
+			return nil, errors.New("Could not find the guava of account " + args[r.arg_index(r.Account)])
+		}
+		account_groups = append(account_groups, []int64{account_id})
+	case r.Transfer != "":
+		index, err := get_transfer_index(stub, args[r.arg_index(r.Transfer)])
+		if err != nil {
+			return nil, err
+		}
+		// a payment may have no To and the reversal of one no From
+		group := make([]int64, 0, 2)
+		for _, account_id := range []int64{index.From, index.To} {
+			if account_id != 0 {
+				group = append(group, account_id)
+			}
+		}
+		account_groups = append(account_groups, group)
+	case r.Items != "":
+		inputs, err := decode_batch_transfers(args[r.arg_index(r.Items)])
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(inputs); i++ {
+			account_groups = append_account(account_groups, inputs[i].From)
+		}
+	default:
+		batch, err := get_batch(stub, args[r.arg_index(r.Batch)])
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(batch.Items); i++ {
+			account_groups = append_account(account_groups, batch.Items[i].From)
+		}
+	}
+
+	accounts := account_cache{}
+	groups := make([][]scope, 0, len(account_groups))
+	for i := 0; i < len(account_groups); i++ {
+		group := make([]scope, 0, len(account_groups[i]))
+		for _, account_id := range account_groups[i] {
+			acc, err := accounts.get(stub, account_id)
+			if err != nil {
+				return nil, errors.New("Could not find the guava of account " + strconv.FormatInt(account_id, 10))
+			}
+			group = append(group, scope{guava_id: acc.Guava_id, account_id: acc.AccountID})
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// append_account - add a group of one account unless an earlier item already sent from it
+func append_account(account_groups [][]int64, account_id int64) [][]int64 {
+
+	for i := 0; i < len(account_groups); i++ {
+		if account_groups[i][0] == account_id {
+			return account_groups
+		}
+	}
+
+	return append(account_groups, []int64{account_id})
+}
+
+func (r *Route) arg_index(name string) int {
+
+	for i := 0; i < len(r.Args); i++ {
+		if r.Args[i] == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ============================================================================================================================
+// list_functions - describe every registered function
+// ============================================================================================================================
+
+func (t *GuavaChaincode) list_functions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	names := make([]string, 0, len(routes))
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*Route, 0, len(names))
+	for i := 0; i < len(names); i++ {
+		list = append(list, routes[names[i]])
+	}
+
+	listAsBytes, _ := json.Marshal(list)
+	return listAsBytes, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestListFunctions(t *testing.T) {
+	l := new_ledger(t)
+
+	var list []Route
+	l.decode(l.ok("list_functions"), &list)
+	if len(list) != len(routes) {
+		t.Fatalf("list_functions returned %d functions, want %d", len(list), len(routes))
+	}
+
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Name >= list[i].Name {
+			t.Fatalf("functions are not sorted: %s before %s", list[i-1].Name, list[i].Name)
+		}
+	}
+
+	for _, route := range list {
+		if route.Name == "create_transfer" && (route.Permission != "create" || route.Actor != "creator" || !route.Writes || len(route.Args) != 10) {
+			t.Fatalf("unexpected create_transfer route %+v", route)
+		}
+		if route.Name == "read_guava" && route.Writes {
+			t.Fatalf("read_guava is listed as writing")
+		}
+	}
+}
+
+func TestRouteArgumentCount(t *testing.T) {
+	l := new_ledger(t)
+
+	l.fail_with("Expecting 3 arguments <account_id, value, actor>", "increment_value", "1")
+	l.fail_with("Expecting 2 arguments <guava_id, caller>", "read_guava", "1")
+}
+
+func TestRoutesNameTheirArguments(t *testing.T) {
+	for name, route := range routes {
+		if route.Name != name {
+			t.Errorf("route %s is registered as %s", route.Name, name)
+		}
+		if route.Permission != "" && route.Actor == "" {
+			t.Errorf("route %s needs %s but names no actor", name, route.Permission)
+		}
+		if route.Permission != "" && route.Permission != "admin" && route.Guava == "" && route.Account == "" && route.Transfer == "" && route.Items == "" && route.Batch == "" {
+			t.Errorf("route %s needs %s but names no guava, account, transfer or batch", name, route.Permission)
+		}
+	}
+}
@@ -0,0 +1,969 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// schema versions written by this chaincode, records stored before versioning have no schema field and read as 0
+// account schema 2 added guava_id, migrate fills it in as it moves accounts off their legacy key so it has no upgrade step
+const AccountSchema = 2
+const TransferSchema = 1
+const BatchSchema = 1
+const PolicySchema = 1
+const TTLSchema = 1
+const GuavaSchema = 1
+const UserSchema = 2
+const TransferIndexSchema = 1
+const UserHistorySchema = 1
+const RoleSchema = 1
+const LimitsSchema = 1
+const UsageSchema = 1
+const WhitelistSchema = 1
+const ExportSchema = 1
+const StatementSchema = 1
+const FxRatesSchema = 1
+
+// keys records were stored under before they moved to composite keys, migrate moves them
+const LegacyGuavaMapKey = "_guavamapkey" // {guava_id: [account_id]}
+const LegacyUserMapKey = "_usermapkey"   // {guava_id: [User]}
+const LegacyInitKey = "hello"
+
+// account_upgrades[n] takes an account from schema n to n+1, transfer_upgrades and user_upgrades likewise,
+// nil where only the schema changes
+var account_upgrades = []func(acc *Account){upgrade_account_v0, nil}
+var transfer_upgrades = []func(transl *Transfer){upgrade_transfer_v0}
+var user_upgrades = []func(user *User){nil, upgrade_user_v1}
+
+// ============================================================================================================================
+// upgrade_account - bring an account and every transfer it holds up to the current schema, true if anything changed
+// ============================================================================================================================
+
+func upgrade_account(acc *Account) bool {
+
+	upgraded := false
+
+	for acc.Schema < AccountSchema {
+		if account_upgrades[acc.Schema] != nil {
+			account_upgrades[acc.Schema](acc)
+		}
+		acc.Schema = acc.Schema + 1
+		upgraded = true
+	}
+
+	for i := 0; i < len(acc.IncomingTransfer); i++ {
+		if upgrade_transfer(&acc.IncomingTransfer[i]) {
+			upgraded = true
+		}
+	}
+
+	for i := 0; i < len(acc.OutgoingTransfer); i++ {
+		if upgrade_transfer(&acc.OutgoingTransfer[i]) {
+			upgraded = true
+		}
+	}
+
+	return upgraded
+}
+
+func upgrade_transfer(transl *Transfer) bool {
+
+	upgraded := false
+
+	for transl.Schema < TransferSchema {
+		transfer_upgrades[transl.Schema](transl)
+		transl.Schema = transl.Schema + 1
+		upgraded = true
+	}
+
+	return upgraded
+}
+
+// batches, policies, ttls, guavas, roles, limits, whitelists, payment exports, statement imports, fx rates, user histories
+// and transfer indexes have not changed layout since versioning began, upgrading them only stamps the schema
+
+func upgrade_batch(batch *Batch) bool {
+
+	upgraded := batch.Schema < BatchSchema
+	batch.Schema = BatchSchema
+	return upgraded
+}
+
+func upgrade_policy(policy *ApprovalPolicy) bool {
+
+	upgraded := policy.Schema < PolicySchema
+	policy.Schema = PolicySchema
+	return upgraded
+}
+
+func upgrade_ttls(ttls *TransferTTLs) bool {
+
+	upgraded := ttls.Schema < TTLSchema
+	ttls.Schema = TTLSchema
+	return upgraded
+}
+
+func upgrade_guava(guava *Guava) bool {
+
+	upgraded := guava.Schema < GuavaSchema
+	guava.Schema = GuavaSchema
+	return upgraded
+}
+
+func upgrade_user(user *User) bool {
+
+	upgraded := false
+
+	for user.Schema < UserSchema {
+		if user_upgrades[user.Schema] != nil {
+			user_upgrades[user.Schema](user)
+		}
+		user.Schema = user.Schema + 1
+		upgraded = true
+	}
+
+	return upgraded
+}
+
+func upgrade_fx_rates(rates *FxRates) bool {
+
+	upgraded := rates.Schema < FxRatesSchema
+	rates.Schema = FxRatesSchema
+	return upgraded
+}
+
+func upgrade_statement(record *StatementImport) bool {
+
+	upgraded := record.Schema < StatementSchema
+	record.Schema = StatementSchema
+	return upgraded
+}
+
+func upgrade_export(export *PaymentExport) bool {
+
+	upgraded := export.Schema < ExportSchema
+	export.Schema = ExportSchema
+	return upgraded
+}
+
+func upgrade_limits(limits *TransferLimits) bool {
+
+	upgraded := limits.Schema < LimitsSchema
+	limits.Schema = LimitsSchema
+	return upgraded
+}
+
+func upgrade_usage(usage *LimitUsage) bool {
+
+	upgraded := usage.Schema < UsageSchema
+	usage.Schema = UsageSchema
+	return upgraded
+}
+
+func upgrade_whitelist(whitelist *CounterpartyWhitelist) bool {
+
+	upgraded := whitelist.Schema < WhitelistSchema
+	whitelist.Schema = WhitelistSchema
+	return upgraded
+}
+
+func upgrade_role(role *Role) bool {
+
+	upgraded := role.Schema < RoleSchema
+	role.Schema = RoleSchema
+	return upgraded
+}
+
+func upgrade_user_history(history *UserHistory) bool {
+
+	upgraded := history.Schema < UserHistorySchema
+	history.Schema = UserHistorySchema
+	return upgraded
+}
+
+func upgrade_transfer_index(index *TransferIndex) bool {
+
+	upgraded := index.Schema < TransferIndexSchema
+	index.Schema = TransferIndexSchema
+	return upgraded
+}
+
+// accounts written by create_account before versioning could be stored with null transfer lists
+func upgrade_account_v0(acc *Account) {
+
+	if acc.IncomingTransfer == nil {
+		acc.IncomingTransfer = make([]Transfer, 0)
+	}
+	if acc.OutgoingTransfer == nil {
+		acc.OutgoingTransfer = make([]Transfer, 0)
+	}
+}
+
+// users from before roles hold only the built-in roles their flags stand for
+func upgrade_user_v1(user *User) {
+
+	if user.Roles == nil {
+		user.Roles = make([]RoleGrant, 0)
+	}
+}
+
+// transfers from before approval policies were settled by their single approver, and from before
+// the transaction time was recorded only carry the client supplied time, which expiry can use if it parses
+func upgrade_transfer_v0(transl *Transfer) {
+
+	if strings.Compare(transl.Status, "approved") == 0 && len(transl.Approvals) == 0 && transl.Approver != "" {
+		transl.Approvals = []Approval{{Approver: transl.Approver, Time: transl.Time}}
+	}
+
+	if transl.Created == "" {
+		if _, err := time.Parse(time.RFC3339, transl.Time); err == nil {
+			transl.Created = transl.Time
+		}
+	}
+}
+
+// ============================================================================================================================
+// upgrade_record - decode a stored record of a kind and upgrade it, returning the schema it was stored at
+// and the upgraded record, nil if it was already current
+// ============================================================================================================================
+
+func upgrade_record(kind string, key string, value []byte) (int, []byte, error) {
+
+	var schema int
+	var upgraded interface{}
+
+	switch kind {
+	case AccountKey:
+		acc := Account{}
+		if err := json.Unmarshal(value, &acc); err != nil {
+			return 0, nil, errors.New("Could not decode account " + key)
+		}
+		schema = acc.Schema
+		if upgrade_account(&acc) {
+			upgraded = acc
+		}
+
+	case BatchKey:
+		batch := Batch{}
+		if err := json.Unmarshal(value, &batch); err != nil {
+			return 0, nil, errors.New("Could not decode batch " + key)
+		}
+		schema = batch.Schema
+		if upgrade_batch(&batch) {
+			upgraded = batch
+		}
+
+	case PolicyKey:
+		policy := ApprovalPolicy{}
+		if err := json.Unmarshal(value, &policy); err != nil {
+			return 0, nil, errors.New("Could not decode approval policy " + key)
+		}
+		schema = policy.Schema
+		if upgrade_policy(&policy) {
+			upgraded = policy
+		}
+
+	case TTLKey:
+		ttls := TransferTTLs{}
+		if err := json.Unmarshal(value, &ttls); err != nil {
+			return 0, nil, errors.New("Could not decode transfer ttls " + key)
+		}
+		schema = ttls.Schema
+		if upgrade_ttls(&ttls) {
+			upgraded = ttls
+		}
+
+	case GuavaKey:
+		guava := Guava{}
+		if err := json.Unmarshal(value, &guava); err != nil {
+			return 0, nil, errors.New("Could not decode guava " + key)
+		}
+		schema = guava.Schema
+		if upgrade_guava(&guava) {
+			upgraded = guava
+		}
+
+	case UserKey:
+		user := User{}
+		if err := json.Unmarshal(value, &user); err != nil {
+			return 0, nil, errors.New("Could not decode user " + key)
+		}
+		schema = user.Schema
+		if upgrade_user(&user) {
+			upgraded = user
+		}
+
+	case UserHistoryKey:
+		history := UserHistory{}
+		if err := json.Unmarshal(value, &history); err != nil {
+			return 0, nil, errors.New("Could not decode user history " + key)
+		}
+		schema = history.Schema
+		if upgrade_user_history(&history) {
+			upgraded = history
+		}
+
+	case FxRatesKey:
+		rates := FxRates{}
+		if err := json.Unmarshal(value, &rates); err != nil {
+			return 0, nil, errors.New("Could not decode fx rates " + key)
+		}
+		schema = rates.Schema
+		if upgrade_fx_rates(&rates) {
+			upgraded = rates
+		}
+
+	case StatementKey:
+		record := StatementImport{}
+		if err := json.Unmarshal(value, &record); err != nil {
+			return 0, nil, errors.New("Could not decode statement import " + key)
+		}
+		schema = record.Schema
+		if upgrade_statement(&record) {
+			upgraded = record
+		}
+
+	case ExportKey:
+		export := PaymentExport{}
+		if err := json.Unmarshal(value, &export); err != nil {
+			return 0, nil, errors.New("Could not decode payment export " + key)
+		}
+		schema = export.Schema
+		if upgrade_export(&export) {
+			upgraded = export
+		}
+
+	case LimitsKey:
+		limits := TransferLimits{}
+		if err := json.Unmarshal(value, &limits); err != nil {
+			return 0, nil, errors.New("Could not decode transfer limits " + key)
+		}
+		schema = limits.Schema
+		if upgrade_limits(&limits) {
+			upgraded = limits
+		}
+
+	case UsageKey:
+		usage := LimitUsage{}
+		if err := json.Unmarshal(value, &usage); err != nil {
+			return 0, nil, errors.New("Could not decode limit usage " + key)
+		}
+		schema = usage.Schema
+		if upgrade_usage(&usage) {
+			upgraded = usage
+		}
+
+	case WhitelistKey:
+		whitelist := CounterpartyWhitelist{}
+		if err := json.Unmarshal(value, &whitelist); err != nil {
+			return 0, nil, errors.New("Could not decode counterparty whitelist " + key)
+		}
+		schema = whitelist.Schema
+		if upgrade_whitelist(&whitelist) {
+			upgraded = whitelist
+		}
+
+	case RoleKey:
+		role := Role{}
+		if err := json.Unmarshal(value, &role); err != nil {
+			return 0, nil, errors.New("Could not decode role " + key)
+		}
+		schema = role.Schema
+		if upgrade_role(&role) {
+			upgraded = role
+		}
+
+	case TransferIndexKey, PendingInboundKey:
+		index := TransferIndex{}
+		if err := json.Unmarshal(value, &index); err != nil {
+			return 0, nil, errors.New("Could not decode transfer index " + key)
+		}
+		schema = index.Schema
+		if upgrade_transfer_index(&index) {
+			upgraded = index
+		}
+
+	default:
+		return 0, nil, errors.New("Key " + key + " does not hold a versioned record")
+	}
+
+	if upgraded == nil {
+		return schema, nil, nil
+	}
+
+	upgradedAsBytes, _ := json.Marshal(upgraded)
+	return schema, upgradedAsBytes, nil
+}
+
+// ============================================================================================================================
+// walk_records - visit every stored key from start_key on, composite keys by kind and then the legacy keys, until visit
+// returns false. Composite keys sort before every legacy key so one start_key can resume a walk in either part.
+// A kind is walked in groups under the first attribute of its keys, the ids its counter handed out or the guava ids the
+// guava counter handed out, so a walk resumes at the group of start_key without reading the keys before it. A group without records is visited with a
+// nil value under its partial key, so a page ends after page_size empty groups as well
+// ============================================================================================================================
+
+func walk_records(stub shim.ChaincodeStubInterface, start_key string, visit func(key string, value []byte) (bool, error)) error {
+
+	if start_key == "" || strings.HasPrefix(start_key, "\x00") {
+		more, err := walk_composite(stub, start_key, visit)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	legacy_start := start_key
+	if strings.HasPrefix(legacy_start, "\x00") {
+		legacy_start = ""
+	}
+
+	// an empty end key is only unbounded together with an empty start key, so the walk ends at the last valid rune
+	iter, err := stub.GetStateByRange(legacy_start, string(utf8.MaxRune))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		// the peer starts an empty range after the composite keys, the mock stub does not
+		if strings.HasPrefix(kv.Key, "\x00") {
+			continue
+		}
+
+		more, err := visit(kv.Key, kv.Value)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walk_composite - the composite part of walk_records, false once visit stopped the walk
+func walk_composite(stub shim.ChaincodeStubInterface, start_key string, visit func(key string, value []byte) (bool, error)) (bool, error) {
+
+	first_kind := 0
+	start_group := ""
+	if start_key != "" {
+		kind, attributes, err := stub.SplitCompositeKey(start_key)
+		if err != nil || len(attributes) == 0 {
+			return false, errors.New("Invalid start_key " + strconv.Quote(start_key))
+		}
+		for first_kind < len(RecordKinds) && RecordKinds[first_kind] != kind {
+			first_kind = first_kind + 1
+		}
+		start_group = attributes[0]
+	}
+
+	guavas, err := get_counter(stub, "guava")
+	if err != nil {
+		return false, err
+	}
+
+	for i := first_kind; i < len(RecordKinds); i++ {
+		kind := RecordKinds[i]
+
+		last := guavas
+		if counter, ok := IdCounters[kind]; ok {
+			last, err = get_counter(stub, counter)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		first := int64(1)
+		if i == first_kind && start_group != "" {
+			first, err = strconv.ParseInt(start_group, 10, 64)
+			if err != nil {
+				return false, errors.New("Invalid start_key " + strconv.Quote(start_key))
+			}
+		}
+
+		for id := first; id < last; id++ {
+			// only the group start_key is in has keys before it
+			skip_to := ""
+			if i == first_kind && id == first {
+				skip_to = start_key
+			}
+
+			more, err := walk_group(stub, kind, strconv.FormatInt(id, 10), skip_to, visit)
+			if err != nil || !more {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// walk_group - visit the keys of a kind under one first attribute from skip_to on, or its partial key if it has none
+func walk_group(stub shim.ChaincodeStubInterface, kind string, group string, skip_to string, visit func(key string, value []byte) (bool, error)) (bool, error) {
+
+	iter, err := stub.GetStateByPartialCompositeKey(kind, []string{group})
+	if err != nil {
+		return false, err
+	}
+	defer iter.Close()
+
+	found := false
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return false, err
+		}
+		found = true
+		if kv.Key < skip_to {
+			continue
+		}
+
+		more, err := visit(kv.Key, kv.Value)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+
+	if found {
+		return true, nil
+	}
+
+	key, err := make_key(stub, kind, group)
+	if err != nil || key < skip_to {
+		return err == nil, err
+	}
+	return visit(key, nil)
+}
+
+// ============================================================================================================================
+// legacy_kind - the kind of record stored under a legacy key, "" for keys that do not hold one
+// ============================================================================================================================
+
+func legacy_kind(key string) string {
+
+	switch {
+	case key == LegacyGuavaMapKey:
+		return GuavaKey
+	case key == LegacyUserMapKey:
+		return UserKey
+	case key == LegacyInitKey:
+		return ConfigKey
+	}
+
+	if _, err := strconv.ParseInt(key, 10, 64); err == nil {
+		return AccountKey
+	}
+
+	return ""
+}
+
+// ============================================================================================================================
+// has_legacy_keys - whether any record is still stored under a plain key, the chaincode itself only writes composite keys
+// ============================================================================================================================
+
+func has_legacy_keys(stub shim.ChaincodeStubInterface) (bool, error) {
+
+	// composite keys start with 0x00, so the plain keys start from 0x01
+	iter, err := stub.GetStateByRange("\x01", string(utf8.MaxRune))
+	if err != nil {
+		return false, err
+	}
+	defer iter.Close()
+
+	return iter.HasNext(), nil
+}
+
+// ============================================================================================================================
+// composite_kind - the kind and attributes of a composite key, "" for keys that do not hold a versioned record
+// ============================================================================================================================
+
+func composite_kind(stub shim.ChaincodeStubInterface, key string) (string, string, error) {
+
+	kind, attributes, err := stub.SplitCompositeKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	for i := 0; i < len(RecordKinds); i++ {
+		if RecordKinds[i] == kind {
+			return kind, strings.Join(attributes, " "), nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// legacy_move moves legacy records to their composite keys during one page of migrate. The peer does not show a
+// transaction its own writes, so the guava map is read once and the counters are raised once at the end of the page
+type legacy_move struct {
+	stub     shim.ChaincodeStubInterface
+	guavas   map[string][]int64
+	counters map[string]int64
+}
+
+func (m *legacy_move) raise(counter string, id int64) {
+
+	if id+1 > m.counters[counter] {
+		m.counters[counter] = id + 1
+	}
+}
+
+func (m *legacy_move) load_guavas() error {
+
+	if m.guavas != nil {
+		return nil
+	}
+
+	m.guavas = make(map[string][]int64)
+	guavasAsBytes, err := m.stub.GetState(LegacyGuavaMapKey)
+	if err != nil || guavasAsBytes == nil {
+		return err
+	}
+	if err := json.Unmarshal(guavasAsBytes, &m.guavas); err != nil {
+		return errors.New("Could not decode the legacy guava map")
+	}
+
+	return nil
+}
+
+func (m *legacy_move) guava_of(account_id int64) (string, error) {
+
+	err := m.load_guavas()
+	if err != nil {
+		return "", err
+	}
+
+	for guava_id, accounts := range m.guavas {
+		for i := 0; i < len(accounts); i++ {
+			if accounts[i] == account_id {
+				return guava_id, nil
+			}
+		}
+	}
+
+	return "", errors.New("Could not find the guava of account " + strconv.FormatInt(account_id, 10))
+}
+
+// ============================================================================================================================
+// move - write the record under a legacy key to its composite key at the current schema and delete the legacy key
+// ============================================================================================================================
+
+func (m *legacy_move) move(kind string, key string, value []byte) error {
+
+	var err error
+	stub := m.stub
+
+	switch kind {
+	case AccountKey:
+		acc := Account{}
+		if err = json.Unmarshal(value, &acc); err != nil {
+			return errors.New("Could not decode account " + key)
+		}
+		acc.Guava_id, err = m.guava_of(acc.AccountID)
+		if err != nil {
+			return err
+		}
+		upgrade_account(&acc)
+		m.raise("account", acc.AccountID)
+
+		for i := 0; i < len(acc.OutgoingTransfer) && err == nil; i++ {
+			transl := &acc.OutgoingTransfer[i]
+			m.raise("transfer", transl.Transfer_id)
+			err = put_transfer_index(stub, transl)
+			if err == nil && strings.Compare(transl.Status, "pending") == 0 && credits_receiver(transl) {
+				var guava_id string
+				guava_id, err = m.guava_of(transl.To)
+				if err == nil {
+					err = put_pending_inbound(stub, guava_id, transl)
+				}
+			}
+		}
+		for i := 0; i < len(acc.IncomingTransfer); i++ {
+			m.raise("transfer", acc.IncomingTransfer[i].Transfer_id)
+		}
+		if err == nil {
+			err = put_account(stub, &acc)
+		}
+
+	case GuavaKey:
+		guavas := make(map[string][]int64)
+		if err = json.Unmarshal(value, &guavas); err != nil {
+			return errors.New("Could not decode the legacy guava map")
+		}
+		guava_ids := make([]string, 0, len(guavas))
+		for guava_id := range guavas {
+			guava_ids = append(guava_ids, guava_id)
+		}
+		sort.Strings(guava_ids)
+
+		for i := 0; i < len(guava_ids) && err == nil; i++ {
+			if id, perr := strconv.ParseInt(guava_ids[i], 10, 64); perr == nil {
+				m.raise("guava", id)
+			}
+			err = put_guava(stub, &Guava{Guava_id: guava_ids[i], Accounts: guavas[guava_ids[i]], Schema: GuavaSchema})
+		}
+
+	case UserKey:
+		users := make(map[string][]User)
+		if err = json.Unmarshal(value, &users); err != nil {
+			return errors.New("Could not decode the legacy user map")
+		}
+		guava_ids := make([]string, 0, len(users))
+		for guava_id := range users {
+			guava_ids = append(guava_ids, guava_id)
+		}
+		sort.Strings(guava_ids)
+
+		// the legacy map could hold a username twice, find_user always used the first
+		for i := 0; i < len(guava_ids) && err == nil; i++ {
+			seen := make(map[string]bool)
+			for j := 0; j < len(users[guava_ids[i]]) && err == nil; j++ {
+				user := users[guava_ids[i]][j]
+				if seen[user.Username] {
+					continue
+				}
+				seen[user.Username] = true
+				upgrade_user(&user)
+				err = put_user(stub, guava_ids[i], &user)
+			}
+		}
+
+	case ConfigKey:
+		var config_key string
+		config_key, err = make_key(stub, ConfigKey, key)
+		if err == nil {
+			err = stub.PutState(config_key, value)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return stub.DelState(key)
+}
+
+// ============================================================================================================================
+// raise_legacy_counters - raise the counters past the ids of the accounts, guavas and transfers still under legacy keys
+// ============================================================================================================================
+
+func raise_legacy_counters(stub shim.ChaincodeStubInterface) error {
+
+	m := legacy_move{stub: stub, counters: make(map[string]int64)}
+
+	// every legacy account is listed in the guava map
+	err := m.load_guavas()
+	if err != nil {
+		return err
+	}
+
+	for guava_id, accounts := range m.guavas {
+		if id, perr := strconv.ParseInt(guava_id, 10, 64); perr == nil {
+			m.raise("guava", id)
+		}
+
+		for i := 0; i < len(accounts); i++ {
+			m.raise("account", accounts[i])
+
+			accAsBytes, err := stub.GetState(strconv.FormatInt(accounts[i], 10))
+			if err != nil {
+				return err
+			}
+			if accAsBytes == nil {
+				continue
+			}
+			acc := Account{}
+			if err = json.Unmarshal(accAsBytes, &acc); err != nil {
+				return errors.New("Could not decode account " + strconv.FormatInt(accounts[i], 10))
+			}
+
+			for j := 0; j < len(acc.OutgoingTransfer); j++ {
+				m.raise("transfer", acc.OutgoingTransfer[j].Transfer_id)
+			}
+			for j := 0; j < len(acc.IncomingTransfer); j++ {
+				m.raise("transfer", acc.IncomingTransfer[j].Transfer_id)
+			}
+		}
+	}
+
+	return m.finish()
+}
+
+func (m *legacy_move) finish() error {
+
+	counters := make([]string, 0, len(m.counters))
+	for counter := range m.counters {
+		counters = append(counters, counter)
+	}
+	sort.Strings(counters)
+
+	for i := 0; i < len(counters); i++ {
+		err := raise_counter(m.stub, counters[i], m.counters[counters[i]])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// migrate - move records off their legacy keys and rewrite stored records at the current schema, page_size keys at a time
+// starting at start_key, chaincode admin only <start_key, page_size, actor>, call again with next_key until done, records are also upgraded whenever they are read
+// ============================================================================================================================
+
+func (t *GuavaChaincode) migrate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <start_key, page_size, actor>")
+	}
+
+	start_key := args[0]
+	page_size, err := strconv.Atoi(args[1])
+	if err != nil || page_size <= 0 {
+		return nil, errors.New("page_size must be a positive number, got " + args[1])
+	}
+
+	resp := MigrateResponse{Version: ApiVersion, Start_key: start_key, Migrated: make(map[string]int), Done: true}
+	legacy := legacy_move{stub: stub, counters: make(map[string]int64)}
+
+	err = walk_records(stub, start_key, func(key string, value []byte) (bool, error) {
+
+		if resp.Scanned == page_size {
+			resp.Next_key = key
+			resp.Done = false
+			return false, nil
+		}
+		resp.Scanned = resp.Scanned + 1
+		if value == nil {
+			return true, nil
+		}
+
+		if !strings.HasPrefix(key, "\x00") {
+			kind := legacy_kind(key)
+			if kind == "" {
+				return true, nil
+			}
+			err := legacy.move(kind, key, value)
+			if err != nil {
+				return false, err
+			}
+			resp.Migrated[kind] = resp.Migrated[kind] + 1
+			return true, nil
+		}
+
+		kind, name, err := composite_kind(stub, key)
+		if err != nil || kind == "" {
+			return err == nil, err
+		}
+
+		_, upgraded, err := upgrade_record(kind, name, value)
+		if err != nil || upgraded == nil {
+			return err == nil, err
+		}
+
+		err = stub.PutState(key, upgraded)
+		if err != nil {
+			return false, err
+		}
+		resp.Migrated[kind] = resp.Migrated[kind] + 1
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = legacy.finish()
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(resp)
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// migration_status - count the stored records of each kind by schema version and how many still need migrating
+// page_size keys at a time starting at start_key <start_key, page_size>, call again with next_key until done and add up the pages
+// ============================================================================================================================
+
+func (t *GuavaChaincode) migration_status(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <start_key, page_size>")
+	}
+
+	start_key := args[0]
+	page_size, err := strconv.Atoi(args[1])
+	if err != nil || page_size <= 0 {
+		return nil, errors.New("page_size must be a positive number, got " + args[1])
+	}
+
+	resp := SchemaResponse{
+		Version: ApiVersion,
+		Current: map[string]int{AccountKey: AccountSchema, "transfer": TransferSchema, BatchKey: BatchSchema, PolicyKey: PolicySchema, TTLKey: TTLSchema,
+			GuavaKey: GuavaSchema, UserKey: UserSchema, UserHistoryKey: UserHistorySchema, RoleKey: RoleSchema, LimitsKey: LimitsSchema, UsageKey: UsageSchema, WhitelistKey: WhitelistSchema, ExportKey: ExportSchema, StatementKey: StatementSchema, FxRatesKey: FxRatesSchema,
+			TransferIndexKey: TransferIndexSchema, PendingInboundKey: TransferIndexSchema},
+		Records:   make(map[string]map[int]int),
+		Pending:   make(map[string]int),
+		Start_key: start_key,
+		Done:      true}
+
+	err = walk_records(stub, start_key, func(key string, value []byte) (bool, error) {
+
+		if resp.Scanned == page_size {
+			resp.Next_key = key
+			resp.Done = false
+			return false, nil
+		}
+		resp.Scanned = resp.Scanned + 1
+		if value == nil {
+			return true, nil
+		}
+
+		if !strings.HasPrefix(key, "\x00") {
+			kind := legacy_kind(key)
+			if kind != "" {
+				resp.Pending[kind] = resp.Pending[kind] + 1
+				resp.Legacy = resp.Legacy + 1
+				resp.Remaining = resp.Remaining + 1
+			}
+			return true, nil
+		}
+
+		kind, name, err := composite_kind(stub, key)
+		if err != nil || kind == "" {
+			return err == nil, err
+		}
+
+		schema, upgraded, err := upgrade_record(kind, name, value)
+		if err != nil {
+			return false, err
+		}
+
+		if resp.Records[kind] == nil {
+			resp.Records[kind] = make(map[int]int)
+		}
+		resp.Records[kind][schema] = resp.Records[kind][schema] + 1
+		if upgraded != nil {
+			resp.Pending[kind] = resp.Pending[kind] + 1
+			resp.Remaining = resp.Remaining + 1
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(resp)
+	return respAsBytes, nil
+}
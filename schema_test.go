@@ -0,0 +1,365 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// records as they were stored before schema versioning
+const legacy_ops = `{"name":"ops","id":1,"currency":"CAD","country":"CA","balance":900,"type":"OPR","incoming_transfer":null,
+	"outgoing_transfer":[
+		{"from":1,"to":2,"dec_value":100,"inc_value":100,"fx_rate":1,"message":"old sweep","status":"approved","type":"internal",
+			"creator":"bob","approver":"bob","time":"2025-12-01T10:00:00Z","transfer_id":1},
+		{"from":1,"to":2,"dec_value":50,"inc_value":50,"fx_rate":1,"message":"old invoice","status":"pending","type":"payment",
+			"creator":"bob","approver":"pending","time":"December","transfer_id":2}]}`
+
+const legacy_savings = `{"name":"savings","id":2,"currency":"CAD","country":"CA","balance":600,"type":"SAVINGS","outgoing_transfer":[],
+	"incoming_transfer":[
+		{"from":1,"to":2,"dec_value":100,"inc_value":100,"fx_rate":1,"message":"old sweep","status":"approved","type":"internal",
+			"creator":"bob","approver":"bob","time":"2025-12-01T10:00:00Z","transfer_id":1}]}`
+
+const legacy_guavas = `{"1":[1,2]}`
+
+// carol is listed twice, the first entry is the one that was always used
+const legacy_users = `{"1":[
+	{"username":"bob","owner":false,"create":true,"approve":false,"read":true},
+	{"username":"carol","owner":false,"create":false,"approve":true,"read":true},
+	{"username":"carol","owner":true,"create":true,"approve":true,"read":true}]}`
+
+// put_raw writes a value straight to the world state, bypassing the chaincode
+func (l *test_ledger) put_raw(key string, value string) {
+	l.t.Helper()
+
+	l.stub.MockTransactionStart("raw")
+	defer l.stub.MockTransactionEnd("raw")
+
+	if err := l.stub.PutState(key, []byte(value)); err != nil {
+		l.t.Fatalf("put %s: %s", key, err)
+	}
+}
+
+func (l *test_ledger) put_raw_record(value string, kind string, attributes ...string) {
+	l.t.Helper()
+
+	key, err := make_key(l.stub, kind, attributes...)
+	if err != nil {
+		l.t.Fatalf("%s key: %s", kind, err)
+	}
+	l.put_raw(key, value)
+}
+
+// unversioned_setup replaces the setup accounts with their pre-versioning records and adds an unversioned batch, policy and ttl
+func (l *test_ledger) unversioned_setup() {
+	l.setup()
+
+	// accounts under composite keys always know their guava
+	l.put_raw_record(strings.Replace(legacy_ops, "{", `{"guava_id":"1",`, 1), AccountKey, "1")
+	l.put_raw_record(strings.Replace(legacy_savings, "{", `{"guava_id":"1",`, 1), AccountKey, "2")
+	l.put_raw_record(`{"batch_id":1,"creator":"bob","time":"t","status":"approved","items":[]}`, BatchKey, "1")
+	l.put_raw_record("2", ConfigKey, "next_batch")
+	l.put_raw_record(`{"bands":[],"maker_checker":false,"approver_roles":[]}`, PolicyKey, "1")
+	l.put_raw_record(`{"ttls":{"payment":3600}}`, TTLKey, "1")
+}
+
+// legacy_setup stores a guava the way it was before records moved to composite keys
+func (l *test_ledger) legacy_setup() {
+	l.put_raw("1", legacy_ops)
+	l.put_raw("2", legacy_savings)
+	l.put_raw(LegacyGuavaMapKey, legacy_guavas)
+	l.put_raw(LegacyUserMapKey, legacy_users)
+	l.put_raw(LegacyInitKey, "world")
+
+	// the upgraded chaincode is instantiated with root as its admin, who runs migrate
+	l.init()
+}
+
+func (l *test_ledger) stored_schema(kind string, attributes ...string) int {
+	l.t.Helper()
+
+	var record struct {
+		Schema int `json:"schema"`
+	}
+	l.decode(l.state(kind, attributes...), &record)
+	return record.Schema
+}
+
+// migrate_all runs migrate page by page until it is done and returns the records migrated and the pages it took
+func (l *test_ledger) migrate_all(page_size string) (int, int) {
+	l.t.Helper()
+
+	migrated := 0
+	pages := 0
+	start := ""
+	for {
+		var resp MigrateResponse
+		l.decode(l.ok("migrate", start, page_size, "root"), &resp)
+		pages = pages + 1
+		for _, count := range resp.Migrated {
+			migrated = migrated + count
+		}
+		if resp.Done {
+			if resp.Next_key != "" {
+				l.t.Fatalf("finished with next_key %q", resp.Next_key)
+			}
+			return migrated, pages
+		}
+		if resp.Next_key == start {
+			l.t.Fatalf("unexpected page %+v", resp)
+		}
+		start = resp.Next_key
+	}
+}
+
+// status_all runs migration_status page by page and adds up the pages
+func (l *test_ledger) status_all(page_size string) SchemaResponse {
+	l.t.Helper()
+
+	total := SchemaResponse{Records: make(map[string]map[int]int), Pending: make(map[string]int)}
+	start := ""
+	for {
+		var resp SchemaResponse
+		l.decode(l.ok("migration_status", start, page_size), &resp)
+		for kind, schemas := range resp.Records {
+			if total.Records[kind] == nil {
+				total.Records[kind] = make(map[int]int)
+			}
+			for schema, count := range schemas {
+				total.Records[kind][schema] = total.Records[kind][schema] + count
+			}
+		}
+		for kind, count := range resp.Pending {
+			total.Pending[kind] = total.Pending[kind] + count
+		}
+		total.Legacy = total.Legacy + resp.Legacy
+		total.Remaining = total.Remaining + resp.Remaining
+		total.Scanned = total.Scanned + resp.Scanned
+		if resp.Done {
+			return total
+		}
+		if resp.Next_key == start {
+			l.t.Fatalf("unexpected page %+v", resp)
+		}
+		start = resp.Next_key
+	}
+}
+
+func TestUpgradeOnRead(t *testing.T) {
+	l := new_ledger(t)
+	l.unversioned_setup()
+
+	acc := l.account(1)
+	if acc.Schema != AccountSchema || acc.IncomingTransfer == nil {
+		t.Fatalf("account was not upgraded: %+v", acc)
+	}
+
+	settled := l.transfer(1, 1)
+	if settled.Schema != TransferSchema || len(settled.Approvals) != 1 || settled.Approvals[0].Approver != "bob" || settled.Created != "2025-12-01T10:00:00Z" {
+		t.Fatalf("settled transfer was not upgraded: %+v", settled)
+	}
+	if pending := l.transfer(1, 2); len(pending.Approvals) != 0 || pending.Created != "" {
+		t.Fatalf("pending transfer was upgraded with made up data: %+v", pending)
+	}
+
+	var ttls map[string]int64
+	l.decode(l.ok("read_transfer_ttl", "1", "dave"), &ttls)
+	if len(ttls) != 1 || ttls["payment"] != 3600 {
+		t.Fatalf("unversioned ttls read as %v", ttls)
+	}
+
+	status := l.status_all("3")
+	if status.Remaining != 5 || status.Legacy != 0 || status.Pending[AccountKey] != 2 || status.Records[AccountKey][0] != 2 || status.Records[TTLKey][0] != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// a pending payment can still be accepted and the sending account is written back at the current schema
+	l.ok("accept_transfer", "2", "1", "2", "50", "50", "carol")
+	l.balance(1, 850)
+	l.balance(2, 600)
+	if l.stored_schema(AccountKey, "1") != AccountSchema || l.stored_schema(AccountKey, "2") == AccountSchema {
+		t.Fatalf("only the sending account should be written back at the current schema")
+	}
+
+	migrated, _ := l.migrate_all("100")
+	if migrated != 4 {
+		t.Fatalf("migrated %d records, want account 2, the batch, policy and ttl", migrated)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	l := new_ledger(t)
+	l.legacy_setup()
+
+	status := l.status_all("3")
+	if status.Remaining != 5 || status.Legacy != 5 || status.Pending[AccountKey] != 2 || status.Pending[ConfigKey] != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// init raised the counters past the legacy ids and nothing else is written until migrate has moved the legacy records
+	for counter, next := range map[string]int64{"account": 3, "guava": 2, "transfer": 3} {
+		if got, err := get_counter(l.stub, counter); err != nil || got != next {
+			t.Fatalf("%s counter is %d, want %d", counter, got, next)
+		}
+	}
+	l.fail_with("run migrate first", "create_account", "other", "-1", "CAD", "CA", "OPR", "0", "root")
+	l.fail_with("run migrate first", "create_account", "other", "1", "CAD", "CA", "OPR", "0", "root")
+	if len(l.stub.State["1"]) == 0 || l.state(AccountKey, "3") != nil || l.state(GuavaKey, "1") != nil {
+		t.Fatalf("a write before migrate touched the legacy records")
+	}
+
+	// the sixteen empty groups below the raised counters and the five legacy keys, two to a page
+	if migrated, pages := l.migrate_all("2"); migrated != 5 || pages != 11 {
+		t.Fatalf("migrated %d records in %d pages, want 5 in 11", migrated, pages)
+	}
+
+	for _, key := range []string{"1", "2", LegacyGuavaMapKey, LegacyUserMapKey, LegacyInitKey} {
+		if _, ok := l.stub.State[key]; ok {
+			t.Fatalf("legacy key %s was not removed", key)
+		}
+	}
+
+	status = l.status_all("3")
+	if status.Remaining != 0 || status.Records[AccountKey][AccountSchema] != 2 || status.Records[UserKey][UserSchema] != 2 ||
+		status.Records[TransferIndexKey][TransferIndexSchema] != 2 {
+		t.Fatalf("unexpected status after migrating %+v", status)
+	}
+
+	if acc := l.account(1); acc.Guava_id != "1" || acc.Schema != AccountSchema {
+		t.Fatalf("account migrated to %+v", acc)
+	}
+	if string(l.state(ConfigKey, "hello")) != "world" {
+		t.Fatalf("init value was not moved")
+	}
+
+	// users moved from the legacy map have no identity until the chaincode admin or an owner binds them to one
+	l.fail_with("User carol does not have the read permission", "get_user", "1", "carol", "carol")
+	l.fail_with("does not have the owner permission", "set_user_identity", "1", "carol", identity("carol"), "alice")
+	l.ok("set_user_identity", "1", "carol", identity("carol"), "root")
+	l.ok("set_user_identity", "1", "bob", identity("bob"), "root")
+
+	var user User
+	l.decode(l.ok("get_user", "1", "carol", "carol"), &user)
+	if user.Owner || !user.Approve {
+		t.Fatalf("the first carol was not kept: %+v", user)
+	}
+
+	var transl Transfer
+	l.decode(l.ok("get_transfer", "2", "bob"), &transl)
+	if transl.From != 1 || transl.Status != "pending" {
+		t.Fatalf("transfer 2 migrated to %+v", transl)
+	}
+
+	// migrating again rewrites nothing, there are seven records, the histories of the two users bound since and
+	// the nine kinds guava 1 holds nothing of
+	var resp MigrateResponse
+	l.decode(l.ok("migrate", "", "100", "root"), &resp)
+	if len(resp.Migrated) != 0 || !resp.Done || resp.Scanned != 18 {
+		t.Fatalf("unexpected second run %+v", resp)
+	}
+
+	// the counters continue after the moved records
+	var acc_resp AccountResponse
+	l.decode(l.ok("create_account", "other", "-1", "CAD", "CA", "OPR", "0", "root"), &acc_resp)
+	if acc_resp.Guava_id != "2" || acc_resp.Account_id != 3 {
+		t.Fatalf("unexpected account after migrating %+v", acc_resp)
+	}
+	if id := l.payment("5"); id != 3 {
+		t.Fatalf("new transfer got id %d, want 3", id)
+	}
+
+	// a pending legacy payment can be accepted once migrated
+	l.ok("accept_transfer", "2", "1", "2", "50", "50", "carol")
+	l.balance(1, 850)
+	l.balance(2, 600)
+
+	l.fail_with("page_size must be a positive number", "migrate", "", "0", "root")
+	l.fail_with("User alice is not the chaincode admin", "migrate", "", "100", "alice")
+}
+
+func TestMigrateAccountWithoutGuava(t *testing.T) {
+	l := new_ledger(t)
+	l.legacy_setup()
+	l.put_raw("5", `{"name":"stray","id":5,"currency":"CAD","balance":0,"outgoing_transfer":[],"incoming_transfer":[]}`)
+
+	l.fail_with("Could not find the guava of account 5", "migrate", "", "100", "root")
+}
+
+func TestNewRecordsCarrySchema(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("set_approval_policy", "1", "alice", `{"maker_checker":true,"schema":0}`)
+	l.ok("set_transfer_ttl", "1", "alice", "payment", "60")
+	l.ok("create_batch_transfer", "bob", "t", `[{"inc_value":1, "dec_value":1, "from":1, "to":2, "type":"internal"}]`)
+
+	status := l.status_all("3")
+	if status.Remaining != 0 || status.Records[GuavaKey][GuavaSchema] != 1 || status.Records[UserKey][UserSchema] != 5 {
+		t.Fatalf("new records need migrating: %+v", status)
+	}
+
+	var view BatchView
+	l.decode(l.ok("read_batch", "1", "dave"), &view)
+	if view.Schema != BatchSchema || view.Transfers[0].Schema != TransferSchema {
+		t.Fatalf("unexpected batch %+v", view)
+	}
+
+	if incoming := l.account(2).IncomingTransfer; incoming[0].Schema != TransferSchema {
+		t.Fatalf("unexpected incoming transfers %+v", incoming)
+	}
+}
+
+// counting_stub counts the keys the chaincode reads through range queries
+type counting_stub struct {
+	*shimtest.MockStub
+	reads int
+}
+
+type counting_iterator struct {
+	shim.StateQueryIteratorInterface
+	reads *int
+}
+
+func (it *counting_iterator) Next() (*queryresult.KV, error) {
+	*it.reads = *it.reads + 1
+	return it.StateQueryIteratorInterface.Next()
+}
+
+func (s *counting_stub) GetStateByPartialCompositeKey(kind string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	iter, err := s.MockStub.GetStateByPartialCompositeKey(kind, attributes)
+	return &counting_iterator{StateQueryIteratorInterface: iter, reads: &s.reads}, err
+}
+
+func (s *counting_stub) GetStateByRange(start_key string, end_key string) (shim.StateQueryIteratorInterface, error) {
+	iter, err := s.MockStub.GetStateByRange(start_key, end_key)
+	return &counting_iterator{StateQueryIteratorInterface: iter, reads: &s.reads}, err
+}
+
+func TestWalkResumesAtItsGroup(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	for i := 0; i < 20; i++ {
+		l.ok("create_account", "more", "1", "CAD", "CA", "OPR", "0", "root")
+	}
+
+	whole := l.status_all("1000")
+	paged := l.status_all("4")
+	if !reflect.DeepEqual(whole.Records, paged.Records) || whole.Scanned != paged.Scanned || whole.Records[AccountKey][AccountSchema] != 22 {
+		t.Fatalf("paged status %+v differs from %+v", paged, whole)
+	}
+
+	// a page starting at account 15 reads accounts 15 to 17 and nothing before them
+	stub := &counting_stub{MockStub: l.stub}
+	start, _ := make_key(l.stub, AccountKey, "15")
+	visited := make([]string, 0)
+	err := walk_records(stub, start, func(key string, value []byte) (bool, error) {
+		visited = append(visited, key)
+		return len(visited) < 3, nil
+	})
+	if err != nil || len(visited) != 3 || visited[0] != start || stub.reads != 3 {
+		t.Fatalf("walk from account 15 visited %q with %d reads: %v", visited, stub.reads, err)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Bank statements close the loop on exported payments. import_statement reads a camt.053 or MT940 statement,
+// matches each debit line to an approved payment of the guava whose transfer id is the line's reference (the
+// end to end id of the pain.001 export) and whose amount agrees, and marks the payment settled. Every other line
+// is kept with the import for manual review. A statement can only be imported into a guava once.
+
+const Camt053Format = "camt053"
+const MT940Format = "mt940"
+
+type StatementLine struct {
+	Line         int     `json:"line"`         //position of the entry in the statement, from 1
+	Reference    string  `json:"reference"`    //end to end id or customer reference of the entry
+	Amount       float64 `json:"amount"`       //amount of the entry
+	Currency     string  `json:"currency"`     //currency of the entry
+	Direction    string  `json:"direction"`    //<debit,credit,reversal>
+	Booking_date string  `json:"booking_date"` //date the bank booked the entry, YYYY-MM-DD
+	Info         string  `json:"info"`         //remittance or additional entry information
+	Reason       string  `json:"reason"`       //why the line was not matched, empty once matched
+}
+
+type StatementMatch struct {
+	Line        int   `json:"line"`        //statement line that settled the transfer
+	Transfer_id int64 `json:"transfer_id"` //payment marked settled
+}
+
+type StatementImport struct {
+	Guava_id     string           `json:"guava_id"`     //guava the statement was imported into
+	Statement_id string           `json:"statement_id"` //message id of a camt.053 or transaction reference of an MT940 statement
+	Format       string           `json:"format"`       //<camt053,mt940>
+	Creator      string           `json:"creator"`      //the username of the user who imported the statement
+	Imported     string           `json:"imported"`     //transaction time of the import
+	Matched      []StatementMatch `json:"matched"`      //lines that settled a payment
+	Unmatched    []StatementLine  `json:"unmatched"`    //lines left for manual review
+	Schema       int              `json:"schema"`       //layout version the import was written with
+}
+
+type camt053Document struct {
+	MsgId      string             `xml:"BkToCstmrStmt>GrpHdr>MsgId"`
+	Statements []camt053Statement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camt053Statement struct {
+	Id      string         `xml:"Id"`
+	Entries []camt053Entry `xml:"Ntry"`
+}
+
+type camt053Entry struct {
+	NtryRef      string             `xml:"NtryRef"`
+	Amt          camt053Amount      `xml:"Amt"`
+	CdtDbtInd    string             `xml:"CdtDbtInd"`
+	RvslInd      bool               `xml:"RvslInd"`
+	BookgDt      string             `xml:"BookgDt>Dt"`
+	AcctSvcrRef  string             `xml:"AcctSvcrRef"`
+	AddtlNtryInf string             `xml:"AddtlNtryInf"`
+	Details      []camt053TxDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camt053TxDetails struct {
+	EndToEndId string         `xml:"Refs>EndToEndId"`
+	Amt        *camt053Amount `xml:"Amt"`
+	TxAmt      *camt053Amount `xml:"AmtDtls>TxAmt>Amt"`
+	Ustrd      string         `xml:"RmtInf>Ustrd"`
+}
+
+type camt053Amount struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+// ============================================================================================================================
+// import_statement - settle the approved payments of a guava a bank statement reports and keep the other lines for review
+// <guava_id, format(camt053, mt940), statement, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) import_statement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 arguments <guava_id, format, statement, actor>")
+	}
+
+	guava_id := args[0]
+	format := args[1]
+
+	var statement_id string
+	var lines []StatementLine
+	var err error
+
+	switch format {
+	case Camt053Format:
+		statement_id, lines, err = parse_camt053(args[2])
+	case MT940Format:
+		statement_id, lines, err = parse_mt940(args[2])
+	default:
+		return nil, errors.New("Unknown statement format " + format + ", expecting " + Camt053Format + " or " + MT940Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if statement_id == "" {
+		return nil, errors.New("Statement has no id")
+	}
+
+	found, err := get_record(stub, &StatementImport{}, StatementKey, guava_id, statement_id)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, errors.New("Statement " + statement_id + " was already imported into guava " + guava_id)
+	}
+
+	guava, err := get_guava(stub, guava_id)
+	if err != nil {
+		return nil, err
+	}
+
+	imported, err := tx_time_string(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the guava's approved payments that have not settled, by transfer id
+	accounts := account_cache{}
+	payments := make(map[string]*Transfer)
+	senders := make(map[string]*Account)
+
+	for i := 0; i < len(guava.Accounts); i++ {
+		acc, err := accounts.get(stub, guava.Accounts[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < len(acc.OutgoingTransfer); j++ {
+			transl := &acc.OutgoingTransfer[j]
+			if payable(transl) {
+				payments[strconv.FormatInt(transl.Transfer_id, 10)] = transl
+				senders[strconv.FormatInt(transl.Transfer_id, 10)] = acc
+			}
+		}
+	}
+
+	record := StatementImport{
+		Guava_id:     guava_id,
+		Statement_id: statement_id,
+		Format:       format,
+		Creator:      args[3],
+		Imported:     imported,
+		Matched:      make([]StatementMatch, 0),
+		Unmatched:    make([]StatementLine, 0),
+		Schema:       StatementSchema}
+	events := make([]GuavaEvent, 0)
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		transl, acc := payments[line.Reference], senders[line.Reference]
+		switch {
+		case strings.Compare(line.Direction, "debit") != 0:
+			line.Reason = "Only debits settle payments"
+		case transl == nil:
+			line.Reason = "No approved payment with reference " + line.Reference
+		case strings.Compare(format_amount(line.Amount), format_amount(payment_amount(transl))) != 0:
+			line.Reason = "Amount does not match the " + format_amount(payment_amount(transl)) + " of transfer " + line.Reference
+		case line.Currency != "" && strings.Compare(line.Currency, acc.Currency) != 0:
+			line.Reason = "Currency does not match the " + acc.Currency + " of transfer " + line.Reference
+		}
+		if line.Reason != "" {
+			record.Unmatched = append(record.Unmatched, line)
+			continue
+		}
+
+		// payments are only held by the sending account
+		mark_settled(transl, imported, statement_id)
+
+		delete(payments, line.Reference)
+		record.Matched = append(record.Matched, StatementMatch{Line: line.Line, Transfer_id: transl.Transfer_id})
+		events = append(events, transfer_event("transfer_settled", acc, transl))
+	}
+
+	err = accounts.put_all(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_record(stub, &record, StatementKey, guava_id, statement_id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_events(stub, events)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(StatementResponse{Version: ApiVersion, Import: &record})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_statement_import - read an imported statement with its matched and unmatched lines <guava_id, statement_id, caller>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_statement_import(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, statement_id, caller>")
+	}
+
+	record := StatementImport{}
+	found, err := get_record(stub, &record, StatementKey, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Could not find statement " + args[1] + " in guava " + args[0])
+	}
+	upgrade_statement(&record)
+
+	recordAsBytes, _ := json.Marshal(record)
+	return recordAsBytes, nil
+}
+
+func mark_settled(transl *Transfer, now string, statement_id string) {
+
+	transl.Status = "settled"
+	transl.Settled_time = now
+	transl.Statement_id = statement_id
+}
+
+// ============================================================================================================================
+// parse_camt053 - read the entries of a camt.053 bank to customer statement, one line per transaction of an entry
+// ============================================================================================================================
+
+func parse_camt053(statement string) (string, []StatementLine, error) {
+
+	doc := camt053Document{}
+	err := xml.Unmarshal([]byte(statement), &doc)
+	if err != nil {
+		return "", nil, errors.New("Could not parse camt.053 statement: " + err.Error())
+	}
+	if len(doc.Statements) == 0 {
+		return "", nil, errors.New("Could not parse camt.053 statement: no Stmt found")
+	}
+
+	lines := make([]StatementLine, 0)
+	for _, stmt := range doc.Statements {
+		for _, entry := range stmt.Entries {
+			direction := "credit"
+			if strings.Compare(entry.CdtDbtInd, "DBIT") == 0 {
+				direction = "debit"
+			}
+			if entry.RvslInd {
+				direction = "reversal"
+			}
+
+			base := StatementLine{Direction: direction, Booking_date: entry.BookgDt, Info: entry.AddtlNtryInf}
+
+			if len(entry.Details) == 0 {
+				line := base
+				line.Reference = entry.NtryRef
+				if line.Reference == "" {
+					line.Reference = entry.AcctSvcrRef
+				}
+				line.Amount, line.Currency, err = camt053_amount(&entry.Amt)
+				if err != nil {
+					return "", nil, err
+				}
+				line.Line = len(lines) + 1
+				lines = append(lines, line)
+				continue
+			}
+
+			// a batched entry lists its transactions, each with its own reference and amount
+			for _, details := range entry.Details {
+				amount := details.Amt
+				if amount == nil {
+					amount = details.TxAmt
+				}
+				if amount == nil {
+					if len(entry.Details) > 1 {
+						return "", nil, errors.New("Could not parse camt.053 statement: transaction " + details.EndToEndId + " has no amount")
+					}
+					amount = &entry.Amt
+				}
+
+				line := base
+				line.Reference = details.EndToEndId
+				if details.Ustrd != "" {
+					line.Info = details.Ustrd
+				}
+				line.Amount, line.Currency, err = camt053_amount(amount)
+				if err != nil {
+					return "", nil, err
+				}
+				line.Line = len(lines) + 1
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	statement_id := doc.MsgId
+	if statement_id == "" {
+		statement_id = doc.Statements[0].Id
+	}
+
+	return strings.TrimSpace(statement_id), lines, nil
+}
+
+func camt053_amount(amount *camt053Amount) (float64, string, error) {
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(amount.Value), 64)
+	if err != nil {
+		return 0, "", errors.New("Could not parse camt.053 statement: invalid amount " + amount.Value)
+	}
+
+	return value, amount.Ccy, nil
+}
+
+// ============================================================================================================================
+// parse_mt940 - read the :61: statement lines of an MT940 statement, each followed by its optional :86: information
+// ============================================================================================================================
+
+func parse_mt940(statement string) (string, []StatementLine, error) {
+
+	statement_id := ""
+	currency := ""
+	lines := make([]StatementLine, 0)
+
+	// join continuation lines onto the field they belong to
+	fields := make([][2]string, 0)
+	for _, raw := range strings.Split(strings.Replace(statement, "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(raw, ":") && strings.Index(raw[1:], ":") > 0 {
+			end := strings.Index(raw[1:], ":") + 1
+			fields = append(fields, [2]string{raw[1:end], raw[end+1:]})
+		} else if len(fields) > 0 && raw != "-" && raw != "" {
+			fields[len(fields)-1][1] = fields[len(fields)-1][1] + "\n" + raw
+		}
+	}
+
+	for _, field := range fields {
+		tag, value := field[0], field[1]
+
+		switch tag {
+		case "20":
+			statement_id = strings.TrimSpace(value)
+
+		case "60F", "60M":
+			if len(value) < 10 {
+				return "", nil, errors.New("Could not parse MT940 statement: invalid opening balance " + value)
+			}
+			currency = value[7:10]
+
+		case "61":
+			line, err := parse_mt940_line(value)
+			if err != nil {
+				return "", nil, err
+			}
+			line.Line = len(lines) + 1
+			line.Currency = currency
+			lines = append(lines, line)
+
+		case "86":
+			if len(lines) > 0 {
+				lines[len(lines)-1].Info = strings.Replace(value, "\n", " ", -1)
+			}
+		}
+	}
+
+	return statement_id, lines, nil
+}
+
+// parse_mt940_line reads YYMMDD[MMDD]<C|D|RC|RD>[funds code]amount<type><reference>[//bank reference]
+func parse_mt940_line(value string) (StatementLine, error) {
+
+	line := StatementLine{}
+	bad := errors.New("Could not parse MT940 statement: invalid statement line " + value)
+
+	first := strings.SplitN(value, "\n", 2)[0]
+	if len(first) < 6 || !all_digits(first[0:6]) {
+		return line, bad
+	}
+	line.Booking_date = "20" + first[0:2] + "-" + first[2:4] + "-" + first[4:6]
+	pos := 6
+	if len(first) >= pos+4 && all_digits(first[pos:pos+4]) {
+		pos = pos + 4
+	}
+
+	switch {
+	case strings.HasPrefix(first[pos:], "RC"), strings.HasPrefix(first[pos:], "RD"):
+		line.Direction = "reversal"
+		pos = pos + 2
+	case strings.HasPrefix(first[pos:], "C"):
+		line.Direction = "credit"
+		pos = pos + 1
+	case strings.HasPrefix(first[pos:], "D"):
+		line.Direction = "debit"
+		pos = pos + 1
+	default:
+		return line, bad
+	}
+
+	if pos < len(first) && all_letters(first[pos:pos+1]) {
+		pos = pos + 1
+	}
+
+	start := pos
+	for pos < len(first) && (all_digits(first[pos:pos+1]) || first[pos] == ',') {
+		pos = pos + 1
+	}
+	amount, err := strconv.ParseFloat(strings.Replace(first[start:pos], ",", ".", 1), 64)
+	if err != nil || pos+4 > len(first) {
+		return line, bad
+	}
+	line.Amount = amount
+
+	// skip the transaction type, the customer reference runs to the bank reference
+	reference := first[pos+4:]
+	if i := strings.Index(reference, "//"); i >= 0 {
+		reference = reference[:i]
+	}
+	line.Reference = strings.TrimSpace(reference)
+
+	return line, nil
+}
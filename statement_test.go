@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// approved_payments settles two payments from account 1 and leaves one pending
+func (l *test_ledger) approved_payments() (int64, int64, int64) {
+	l.t.Helper()
+
+	first := l.payment("100")
+	second := l.payment("50.5")
+	pending := l.payment("10")
+	l.ok("accept_transfer", "2", "1", format_int(first), "100", "100", "carol")
+	l.ok("accept_transfer", "2", "1", format_int(second), "50.5", "50.5", "carol")
+	return first, second, pending
+}
+
+func camt053(msg_id string, entries string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <GrpHdr><MsgId>` + msg_id + `</MsgId><CreDtTm>2026-01-06T06:00:00</CreDtTm></GrpHdr>
+    <Stmt>
+      <Id>STMT-1</Id>
+      <Acct><Id><Othr><Id>1</Id></Othr></Id></Acct>` + entries + `
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+}
+
+func TestImportCamt053(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	first, second, pending := l.approved_payments()
+
+	statement := camt053("CAMT-1", `
+      <Ntry>
+        <Amt Ccy="CAD">150.50</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2026-01-06</Dt></BookgDt>
+        <NtryDtls>
+          <TxDtls><Refs><EndToEndId>`+format_int(first)+`</EndToEndId></Refs><AmtDtls><TxAmt><Amt Ccy="CAD">100.00</Amt></TxAmt></AmtDtls></TxDtls>
+          <TxDtls><Refs><EndToEndId>`+format_int(second)+`</EndToEndId></Refs><AmtDtls><TxAmt><Amt Ccy="CAD">50.00</Amt></TxAmt></AmtDtls></TxDtls>
+        </NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="CAD">10.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2026-01-06</Dt></BookgDt>
+        <NtryDtls><TxDtls><Refs><EndToEndId>`+format_int(pending)+`</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <NtryRef>FEE-1</NtryRef><Amt Ccy="CAD">2.50</Amt><CdtDbtInd>CRDT</CdtDbtInd><BookgDt><Dt>2026-01-06</Dt></BookgDt>
+        <AddtlNtryInf>interest</AddtlNtryInf>
+      </Ntry>`)
+
+	l.fail_with("User bob does not have the owner permission in guava 1", "import_statement", "1", "camt053", statement, "bob")
+
+	var resp StatementResponse
+	l.decode(l.ok("import_statement", "1", "camt053", statement, "alice"), &resp)
+	record := resp.Import
+	if record == nil || record.Statement_id != "CAMT-1" || record.Format != "camt053" || record.Schema != StatementSchema {
+		t.Fatalf("unexpected import %+v", record)
+	}
+	if len(record.Matched) != 1 || record.Matched[0].Transfer_id != first || record.Matched[0].Line != 1 {
+		t.Fatalf("unexpected matches %+v", record.Matched)
+	}
+
+	// the second line is 50.00 against 50.50, the pending payment is not approved and credits never settle payments
+	if len(record.Unmatched) != 3 {
+		t.Fatalf("expected 3 unmatched lines, got %+v", record.Unmatched)
+	}
+	reasons := []string{"Amount does not match the 50.50 of transfer " + format_int(second), "No approved payment with reference " + format_int(pending), "Only debits settle payments"}
+	for i, reason := range reasons {
+		if record.Unmatched[i].Reason != reason || record.Unmatched[i].Line != i+2 {
+			t.Fatalf("unexpected unmatched line %+v", record.Unmatched[i])
+		}
+	}
+	if credit := record.Unmatched[2]; credit.Reference != "FEE-1" || credit.Amount != 2.5 || credit.Info != "interest" || credit.Direction != "credit" || credit.Booking_date != "2026-01-06" {
+		t.Fatalf("unexpected credit line %+v", credit)
+	}
+
+	settled := l.transfer(1, first)
+	if settled.Settled_time != "2026-01-05T09:00:00Z" || settled.Statement_id != "CAMT-1" || settled.Status != "settled" {
+		t.Fatalf("the matched payment was not settled %+v", settled)
+	}
+	if got := l.transfer(1, second); got.Settled_time != "" {
+		t.Fatalf("an unmatched payment was settled %+v", got)
+	}
+
+	var read StatementImport
+	l.decode(l.ok("read_statement_import", "1", "CAMT-1", "dave"), &read)
+	if len(read.Matched) != 1 || len(read.Unmatched) != 3 {
+		t.Fatalf("unexpected stored import %+v", read)
+	}
+	l.fail_with("Could not find statement CAMT-9 in guava 1", "read_statement_import", "1", "CAMT-9", "dave")
+
+	l.fail_with("Statement CAMT-1 was already imported into guava 1", "import_statement", "1", "camt053", statement, "alice")
+
+	// a settled payment is not matched again
+	l.decode(l.ok("import_statement", "1", "camt053", camt053("CAMT-2", `
+      <Ntry>
+        <Amt Ccy="CAD">100.00</Amt><CdtDbtInd>DBIT</CdtDbtInd>
+        <NtryDtls><TxDtls><Refs><EndToEndId>`+format_int(first)+`</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>`), "alice"), &resp)
+	if len(resp.Import.Matched) != 0 || len(resp.Import.Unmatched) != 1 {
+		t.Fatalf("a settled payment was matched again %+v", resp.Import)
+	}
+
+	// nor can it be reversed or exported
+	l.fail_with("Transfer "+format_int(first)+" was settled by statement CAMT-1 and can not be reversed", "reverse_transfer", "1", format_int(first), "10", "carol", "refund")
+	var export ExportResponse
+	l.ok("set_bank_account", "1", `{"iban":"DE89 3704 0044 0532 0130 00"}`, "alice")
+	l.decode(l.ok("export_payments", "1", "alice"), &export)
+	if len(export.Export.Transfer_ids) != 1 || export.Export.Transfer_ids[0] != second {
+		t.Fatalf("unexpected export %+v", export.Export)
+	}
+}
+
+func TestImportMT940(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	first, second, _ := l.approved_payments()
+
+	statement := strings.Join([]string{
+		":20:MT940-7",
+		":25:1",
+		":28C:1/1",
+		":60F:C260105CAD1000,00",
+		":61:2601060106D100,00NTRF" + format_int(first) + "//BANK-1",
+		":86:invoice 7",
+		":61:260106DR50,00NTRF" + format_int(second),
+		":61:260106C25,00NMSCNONREF",
+		":86:refund from",
+		"supplier",
+		":62F:C260106CAD874,50",
+		"-"}, "\r\n")
+
+	var resp StatementResponse
+	l.decode(l.ok("import_statement", "1", "mt940", statement, "alice"), &resp)
+	record := resp.Import
+	if record.Statement_id != "MT940-7" || len(record.Matched) != 1 || record.Matched[0].Transfer_id != first {
+		t.Fatalf("unexpected import %+v", record)
+	}
+	if len(record.Unmatched) != 2 {
+		t.Fatalf("expected 2 unmatched lines, got %+v", record.Unmatched)
+	}
+
+	// the funds code is skipped and the amount does not match, a credit keeps its continued information
+	if line := record.Unmatched[0]; line.Reference != format_int(second) || line.Amount != 50 || line.Direction != "debit" || line.Booking_date != "2026-01-06" {
+		t.Fatalf("unexpected debit line %+v", line)
+	}
+	if line := record.Unmatched[1]; line.Reference != "NONREF" || line.Currency != "CAD" || line.Info != "refund from supplier" {
+		t.Fatalf("unexpected credit line %+v", line)
+	}
+}
+
+func TestImportStatementErrors(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("Unknown statement format bai2, expecting camt053 or mt940", "import_statement", "1", "bai2", "x", "alice")
+	l.fail_with("Could not parse camt.053 statement", "import_statement", "1", "camt053", "<Document>", "alice")
+	l.fail_with("Could not parse camt.053 statement: invalid amount ten", "import_statement", "1", "camt053",
+		camt053("C", `<Ntry><Amt Ccy="CAD">ten</Amt><CdtDbtInd>DBIT</CdtDbtInd></Ntry>`), "alice")
+	l.fail_with("Could not parse MT940 statement: invalid statement line 2601X", "import_statement", "1", "mt940", ":20:M\n:61:2601X", "alice")
+	l.fail_with("Statement has no id", "import_statement", "1", "mt940", ":25:1\n:61:260106D1,00NTRF1", "alice")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Transfers are created as one of TransferTypes. An internal transfer moves funds between two accounts of one guava
+// and settles as soon as it is created when both accounts hold the same currency and the same amount leaves and
+// arrives. Internal transfers across currencies wait for approval like payments. Payments are paid out to the
+// beneficiary they name (beneficiary.go), so they only debit the sender. Their To is optional and only names the
+// counterparty's account when it is on the ledger, it is never credited. Reversals are only created by reverse_transfer.
+
+const InternalTransfer = "internal"
+const PaymentTransfer = "payment"
+const ReversalTransfer = "reversal"
+
+var TransferTypes = []string{InternalTransfer, PaymentTransfer}
+
+// ============================================================================================================================
+// check_transfer_type - check the type of a new transfer and its accounts agree, returns whether it settles on creation
+// to_acc is nil for a payment without a To account
+// ============================================================================================================================
+
+func check_transfer_type(from_acc *Account, to_acc *Account, transl *Transfer) (bool, error) {
+
+	err := check_trans_type(transl.T_Type)
+	if err != nil {
+		return false, err
+	}
+
+	if strings.Compare(transl.T_Type, PaymentTransfer) == 0 {
+		return false, check_beneficiary(transl.Beneficiary)
+	}
+
+	if transl.Beneficiary != nil {
+		return false, errors.New("Internal transfers do not have a beneficiary")
+	}
+
+	if strings.Compare(from_acc.Guava_id, to_acc.Guava_id) != 0 {
+		return false, errors.New("Internal transfers must stay within a guava, account " + strconv.FormatInt(to_acc.AccountID, 10) + " is in guava " + to_acc.Guava_id)
+	}
+
+	if strings.Compare(from_acc.Currency, to_acc.Currency) != 0 {
+		return false, nil
+	}
+
+	if transl.Inc_value != transl.Dec_value {
+		return false, errors.New("Internal transfers between accounts in " + from_acc.Currency + " must have the same inc_value and dec_value")
+	}
+
+	return true, nil
+}
+
+// credits_receiver - whether settling a transfer credits its To account, payments leave the ledger instead
+func credits_receiver(transl *Transfer) bool {
+	return strings.Compare(transl.T_Type, PaymentTransfer) != 0
+}
+
+// needs_receiver - whether a new transfer of the type must name an existing To account, payments may leave it 0
+func needs_receiver(trans_type string, to_id int64) bool {
+	return to_id != 0 || strings.Compare(trans_type, PaymentTransfer) != 0
+}
+
+func check_trans_type(trans_type string) error {
+
+	if !contains_string(TransferTypes, trans_type) {
+		return errors.New("Unknown transfer type " + trans_type + ", expecting one of " + strings.Join(TransferTypes, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnknownTransferType(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("Unknown transfer type external, expecting one of internal, payment", "create_transfer", "m", "1", "10", "10", "1", "2", "external", "t", "bob", beneficiary)
+	l.fail_with("Unknown transfer type reversal", "create_transfer", "m", "1", "10", "10", "1", "2", "reversal", "t", "bob", "")
+	l.fail_with("batch item 1: Unknown transfer type wire", "create_batch_transfer", "bob", "t", `[
+		{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"internal"},
+		{"inc_value":10, "dec_value":10, "from":1, "to":2, "type":"wire"}]`)
+	l.fail_with("Unknown transfer type wire", "set_transfer_ttl", "1", "alice", "wire", "60")
+	l.balance(1, 1000)
+}
+
+func TestPaymentBeneficiary(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.fail_with("Payments need a beneficiary", "create_transfer", "m", "1", "10", "10", "1", "2", "payment", "t", "bob", "")
+	l.fail_with("Beneficiary needs an iban or an account_number", "create_transfer", "m", "1", "10", "10", "1", "2", "payment", "t", "bob", `{"name":"Acme Supplies"}`)
+	l.fail_with("Could not parse beneficiary_json", "create_transfer", "m", "1", "10", "10", "1", "2", "payment", "t", "bob", `{"name":`)
+	l.fail_with("Internal transfers do not have a beneficiary", "create_transfer", "m", "1", "10", "10", "1", "2", "internal", "t", "bob", beneficiary)
+
+	id := l.payment("10")
+	transl := l.transfer(1, id)
+	if transl.Beneficiary == nil || transl.Beneficiary.Name != "Acme Supplies" || transl.Beneficiary.Iban != "GB82WEST12345698765432" {
+		t.Fatalf("the beneficiary was not stored %+v", transl)
+	}
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", `{"version":1, "message":"rent", "fx_rate":1, "inc_value":5, "dec_value":5, "from":1, "to":2, "type":"payment",
+		"time":"t", "creator":"bob", "beneficiary":{"name":"Landlord", "account_number":"555", "bic":"ROYCCAT2"}}`), &resp)
+	if resp.Transfer.Beneficiary == nil || resp.Transfer.Beneficiary.Name != "Landlord" {
+		t.Fatalf("the beneficiary of a JSON request was not stored %+v", resp.Transfer)
+	}
+}
+
+func TestInternalCurrencyRule(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+	l.ok("create_account", "usd", "1", "USD", "US", "OPR", "0", "root")
+
+	// one currency settles at once and must move the same amount out and in
+	l.fail_with("Internal transfers between accounts in CAD must have the same inc_value and dec_value", "create_transfer", "m", "0.5", "50", "100", "1", "2", "internal", "t", "bob", "")
+
+	var resp TransferResponse
+	l.decode(l.ok("create_transfer", "m", "1", "100", "100", "1", "2", "internal", "t", "bob", ""), &resp)
+	if resp.Status != "approved" || resp.Transfer.Approver != "bob" {
+		t.Fatalf("a same currency internal transfer did not settle %+v", resp)
+	}
+
+	// across currencies it waits for an approval
+	l.decode(l.ok("create_transfer", "m", "0.75", "75", "100", "1", "3", "internal", "t", "bob", ""), &resp)
+	if resp.Status != "pending" || resp.Transfer.Approver != "pending" {
+		t.Fatalf("a cross currency internal transfer settled without approval %+v", resp)
+	}
+	l.balance(1, 900)
+	l.balance(3, 0)
+
+	l.ok("accept_transfer", "3", "1", format_int(resp.Transfer_id), "100", "75", "carol")
+	l.balance(1, 800)
+	l.balance(3, 75)
+}
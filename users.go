@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type UserChange struct {
+	Action   string      `json:"action"` //what changed <created, updated, disabled, removed, role_assigned, role_revoked, identity_set>
+	By       string      `json:"by"`     //the owner who made the change, empty for create_user
+	Time     string      `json:"time"`   //transaction time of the change
+	Owner    bool        `json:"owner"`  //the rights and state of the user after the change
+	Create   bool        `json:"create"`
+	Approve  bool        `json:"approve"`
+	Read     bool        `json:"read"`
+	Disabled bool        `json:"disabled"`
+	Roles    []RoleGrant `json:"roles"`
+	Identity string      `json:"identity"`
+}
+
+type UserHistory struct {
+	Guava_id string       `json:"guava_id"`
+	Username string       `json:"username"`
+	Changes  []UserChange `json:"changes"` //every change to the user in the order they were made
+	Schema   int          `json:"schema"`  //layout version the history was written with
+}
+
+// ============================================================================================================================
+// update_user - set the access rights of a user and enable it if it was disabled, owners only
+// <guava_id, username, owner, create, approve, read, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) update_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 7 arguments <guava_id, username, owner, create, approve, read, actor>")
+	}
+
+	guava_id := args[0]
+	actor := args[6]
+
+	rights := make([]bool, 4)
+	for i := 0; i < len(rights); i++ {
+		right, err := strconv.ParseBool(args[2+i])
+		if err != nil {
+			return nil, errors.New("Invalid access right " + args[2+i])
+		}
+		rights[i] = right
+	}
+
+	user, err := find_guava_user(stub, guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if !rights[0] {
+		err = check_last_owner(stub, guava_id, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user.Owner = rights[0]
+	user.Create = rights[1]
+	user.Approve = rights[2]
+	user.Read = rights[3]
+	user.Disabled = false
+
+	return change_user(stub, guava_id, user, "updated", actor)
+}
+
+// ============================================================================================================================
+// disable_user - take away the use of every access right of a user while keeping its record, owners only <guava_id, username, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) disable_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, username, actor>")
+	}
+
+	guava_id := args[0]
+
+	user, err := find_guava_user(stub, guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, errors.New("User " + user.Username + " is already disabled in guava " + guava_id)
+	}
+
+	err = check_last_owner(stub, guava_id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Disabled = true
+
+	return change_user(stub, guava_id, user, "disabled", args[2])
+}
+
+// ============================================================================================================================
+// remove_user - delete a user from a guava, its history is kept, owners only <guava_id, username, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) remove_user(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, username, actor>")
+	}
+
+	guava_id := args[0]
+
+	user, err := find_guava_user(stub, guava_id, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = check_last_owner(stub, guava_id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := make_key(stub, UserKey, guava_id, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = record_user_change(stub, guava_id, user, "removed", args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(UserResponse{Version: ApiVersion, Guava_id: guava_id, User: user})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// list_users - list the users of a guava in username order, owners only <guava_id, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) list_users(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 arguments <guava_id, actor>")
+	}
+
+	users, err := get_users(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	usersAsBytes, _ := json.Marshal(users)
+	return usersAsBytes, nil
+}
+
+// ============================================================================================================================
+// read_user_history - read every change made to a user, including removed users, owners only <guava_id, username, actor>
+// ============================================================================================================================
+
+func (t *GuavaChaincode) read_user_history(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 arguments <guava_id, username, actor>")
+	}
+
+	history, err := get_user_history(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(history.Changes) == 0 {
+		return nil, errors.New("User " + args[1] + " has no history in guava " + args[0])
+	}
+
+	historyAsBytes, _ := json.Marshal(history)
+	return historyAsBytes, nil
+}
+
+// ============================================================================================================================
+// find_guava_user - load a user that must exist
+// ============================================================================================================================
+
+func find_guava_user(stub shim.ChaincodeStubInterface, guava_id string, username string) (*User, error) {
+
+	user, err := get_user(stub, guava_id, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("Could not find user " + username + " in guava " + guava_id)
+	}
+
+	return user, nil
+}
+
+// ============================================================================================================================
+// check_last_owner - fail if taking the owner right away from this user would leave the guava without an enabled owner
+// ============================================================================================================================
+
+func check_last_owner(stub shim.ChaincodeStubInterface, guava_id string, user *User) error {
+
+	if !user_has_role(user, "owner") {
+		return nil
+	}
+
+	users, err := get_users(stub, guava_id)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(users); i++ {
+		if strings.Compare(users[i].Username, user.Username) != 0 && user_has_role(&users[i], "owner") {
+			return nil
+		}
+	}
+
+	return errors.New("User " + user.Username + " is the last owner of guava " + guava_id)
+}
+
+// ============================================================================================================================
+// change_user - write a changed user back, record the change and respond with the user
+// ============================================================================================================================
+
+func change_user(stub shim.ChaincodeStubInterface, guava_id string, user *User, action string, by string) ([]byte, error) {
+
+	user.Schema = UserSchema
+	err := put_user(stub, guava_id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = record_user_change(stub, guava_id, user, action, by)
+	if err != nil {
+		return nil, err
+	}
+
+	respAsBytes, _ := json.Marshal(UserResponse{Version: ApiVersion, Guava_id: guava_id, User: user})
+	return respAsBytes, nil
+}
+
+// ============================================================================================================================
+// get_user_history / record_user_change - load the change history of a user and append a change with the user's rights after it
+// ============================================================================================================================
+
+func get_user_history(stub shim.ChaincodeStubInterface, guava_id string, username string) (*UserHistory, error) {
+
+	history := UserHistory{Guava_id: guava_id, Username: username, Changes: make([]UserChange, 0), Schema: UserHistorySchema}
+
+	_, err := get_record(stub, &history, UserHistoryKey, guava_id, username)
+	if err != nil {
+		return nil, err
+	}
+	upgrade_user_history(&history)
+
+	return &history, nil
+}
+
+func record_user_change(stub shim.ChaincodeStubInterface, guava_id string, user *User, action string, by string) error {
+
+	history, err := get_user_history(stub, guava_id, user.Username)
+	if err != nil {
+		return err
+	}
+
+	now, err := tx_time_string(stub)
+	if err != nil {
+		return err
+	}
+
+	history.Changes = append(history.Changes, UserChange{Action: action, By: by, Time: now,
+		Owner: user.Owner, Create: user.Create, Approve: user.Approve, Read: user.Read, Disabled: user.Disabled, Roles: user.Roles, Identity: user.Identity})
+
+	return put_record(stub, history, UserHistoryKey, guava_id, user.Username)
+}
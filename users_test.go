@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpdateUser(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var resp UserResponse
+	l.decode(l.ok("update_user", "1", "dave", "false", "true", "false", "true", "alice"), &resp)
+	if resp.User.Username != "dave" || !resp.User.Create || resp.User.Approve {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// dave can now create transfers
+	l.ok("create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "dave", "")
+
+	l.fail_with("does not have the owner permission", "update_user", "1", "dave", "true", "true", "true", "true", "bob")
+	l.fail_with("Could not find user mallory in guava 1", "update_user", "1", "mallory", "false", "false", "false", "true", "alice")
+	l.fail_with("Invalid access right", "update_user", "1", "dave", "maybe", "true", "false", "true", "alice")
+	l.fail_with("User alice is the last owner of guava 1", "update_user", "1", "alice", "false", "true", "true", "true", "alice")
+
+	// with a second owner alice can step down
+	l.ok("update_user", "1", "erin", "true", "false", "true", "true", "alice")
+	l.ok("update_user", "1", "alice", "false", "true", "true", "true", "erin")
+	l.fail_with("does not have the owner permission", "list_users", "1", "alice")
+}
+
+func TestDisableUser(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("disable_user", "1", "bob", "alice")
+	l.fail_with("User bob does not have the create permission in guava 1", "create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "bob", "")
+	l.fail_with("User bob is already disabled in guava 1", "disable_user", "1", "bob", "alice")
+	l.fail_with("User alice is the last owner of guava 1", "disable_user", "1", "alice", "alice")
+
+	// update_user enables the user again
+	l.ok("update_user", "1", "bob", "false", "true", "false", "true", "alice")
+	l.ok("create_transfer", "m", "1", "1", "1", "1", "2", "internal", "t", "bob", "")
+
+	// a disabled owner does not count as an owner
+	l.ok("update_user", "1", "erin", "true", "false", "false", "true", "alice")
+	l.ok("disable_user", "1", "erin", "alice")
+	l.fail_with("User alice is the last owner of guava 1", "remove_user", "1", "alice", "alice")
+	l.fail_with("does not have the owner permission", "list_users", "1", "erin")
+}
+
+func TestRemoveAndListUsers(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	var users []User
+	l.decode(l.ok("list_users", "1", "alice"), &users)
+	if len(users) != 5 || users[0].Username != "alice" || users[4].Username != "erin" {
+		t.Fatalf("unexpected users %+v", users)
+	}
+
+	l.ok("remove_user", "1", "carol", "alice")
+	l.decode(l.ok("list_users", "1", "alice"), &users)
+	if len(users) != 4 || users[2].Username != "dave" {
+		t.Fatalf("unexpected users after removing carol %+v", users)
+	}
+	l.fail_with("does not have the approve permission", "accept_transfer", "2", "1", format_int(l.payment("5")), "5", "5", "carol")
+	l.fail_with("Could not find user carol in guava 1", "remove_user", "1", "carol", "alice")
+	l.fail_with("User alice is the last owner of guava 1", "remove_user", "1", "alice", "alice")
+	l.fail_with("does not have the owner permission", "remove_user", "1", "dave", "bob")
+
+	// the name can be used again
+	l.ok("create_user", "carol", "false", "false", "false", "true", "1", identity("carol"), "alice")
+}
+
+func TestUserHistory(t *testing.T) {
+	l := new_ledger(t)
+	l.setup()
+
+	l.ok("update_user", "1", "dave", "false", "true", "false", "true", "alice")
+	l.ok("disable_user", "1", "dave", "alice")
+	l.ok("remove_user", "1", "dave", "alice")
+
+	var history UserHistory
+	l.decode(l.ok("read_user_history", "1", "dave", "alice"), &history)
+	if len(history.Changes) != 4 {
+		t.Fatalf("unexpected history %+v", history)
+	}
+
+	want := []UserChange{
+		{Action: "created", By: "alice", Read: true},
+		{Action: "updated", By: "alice", Create: true, Read: true},
+		{Action: "disabled", By: "alice", Create: true, Read: true, Disabled: true},
+		{Action: "removed", By: "alice", Create: true, Read: true, Disabled: true}}
+	for i := 0; i < len(want); i++ {
+		want[i].Time = l.now.Format(time.RFC3339)
+		want[i].Roles = make([]RoleGrant, 0)
+		want[i].Identity = identity("dave")
+		if !reflect.DeepEqual(history.Changes[i], want[i]) {
+			t.Fatalf("change %d = %+v, want %+v", i, history.Changes[i], want[i])
+		}
+	}
+
+	l.fail_with("User mallory has no history in guava 1", "read_user_history", "1", "mallory", "alice")
+	l.fail_with("does not have the owner permission", "read_user_history", "1", "dave", "bob")
+}